@@ -6,16 +6,22 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/marcusziade/stability-go/client"
+	apierrors "github.com/marcusziade/stability-go/errors"
 	"github.com/marcusziade/stability-go/internal/logger"
+	"github.com/marcusziade/stability-go/telemetry"
 )
 
 // Server represents the API server
@@ -30,19 +36,71 @@ type Server struct {
 	AllowedHost   []string
 	AllowedIPs    []string
 	AllowedAppIDs []string
+
+	startTime time.Time
+
+	// Circuit breaker guarding the Stability AI client, reported by the health check
+	breaker *client.CircuitBreaker
+
+	// Tracer and meter every handler reports to (no-ops unless set)
+	tracer telemetry.Tracer
+	meter  telemetry.Meter
+
+	// Cached credit balance reported by the health check
+	balanceMutex     sync.Mutex
+	balance          creditBalance
+	balanceCheckedAt time.Time
+	// Closed when the balance fetch in flight finishes; nil when none is
+	balanceFetch chan struct{}
 }
 
+// creditBalance is the remaining Stability AI credits reported by the health check
+type creditBalance struct {
+	// Total credits; with a key pool, the sum over the keys whose balance was fetched
+	credits float64
+	// Balance of each key of a key pool, in pool order
+	keys []client.KeyBalance
+	// Error fetching the balance; with a key pool, set only when no key's balance was fetched
+	err error
+}
+
+// balanceCacheTTL is how long the health check reuses a fetched credit balance
+const balanceCacheTTL = time.Minute
+
+// uploadMemoryLimit is how much of an upscale upload is kept in memory; larger images are kept in temporary files
+const uploadMemoryLimit = 1 << 20
+
 // Response is the standard JSON response format
 type Response struct {
-	Success bool        `json:"success"`
-	Error   string      `json:"error,omitempty"`
-	Data    interface{} `json:"data,omitempty"`
+	Success bool                   `json:"success"`
+	Error   string                 `json:"error,omitempty"`
+	Errors  []apierrors.FieldError `json:"errors,omitempty"`
+	Data    interface{}            `json:"data,omitempty"`
+	// Machine-readable code for errors clients are expected to handle (e.g., ErrorCodeContentFiltered)
+	Code string `json:"code,omitempty"`
+	// Request and error IDs of a failed Stability AI request, to report to Stability AI support
+	Metadata *client.Metadata `json:"metadata,omitempty"`
 }
 
+// ErrorCodeContentFiltered is the error code of upscales whose result was blurred by Stability AI's content filter.
+// They are answered with a 422 unless the request sets allow_filtered=true.
+const ErrorCodeContentFiltered = "content_filtered"
+
 // UpscaleResponse is the response format for the upscale endpoint
 type UpscaleResponse struct {
+	ID            string                   `json:"id,omitempty"`
+	Image         string                   `json:"image,omitempty"`
+	Pending       bool                     `json:"pending,omitempty"`
+	Preprocessing *client.PreprocessResult `json:"preprocessing,omitempty"`
+	Metadata      *client.Metadata         `json:"metadata,omitempty"`
+	// Whether the image was blurred by the content filter (only when allow_filtered is true)
+	ContentFiltered bool `json:"content_filtered,omitempty"`
+}
+
+// VideoResponse is the response format for the video endpoint
+type VideoResponse struct {
 	ID      string `json:"id,omitempty"`
-	Image   string `json:"image,omitempty"`
+	Video   string `json:"video,omitempty"`
 	Pending bool   `json:"pending,omitempty"`
 }
 
@@ -58,17 +116,20 @@ func New(client *client.Client, logger *logger.Logger, cachePath string, rateLim
 		AllowedHost:   allowedHosts,
 		AllowedIPs:    allowedIPs,
 		AllowedAppIDs: allowedAppIDs,
+		startTime:     time.Now(),
 	}
 
 	// Create the router
 	mux := http.NewServeMux()
 
 	// Register routes with middleware
-	mux.Handle("/", http.HandlerFunc(s.handleRoot))
-	mux.Handle("/api/v1/upscale", WithAuth(clientAPIKey, nil)(http.HandlerFunc(s.handleUpscale)))
-	mux.Handle("/api/v1/upscale/result/", WithAuth(clientAPIKey, nil)(http.HandlerFunc(s.handleUpscaleResult)))
-	mux.Handle("/health", http.HandlerFunc(s.handleHealthCheck))
-	mux.Handle("/api/docs", http.HandlerFunc(s.handleDocs))
+	mux.Handle("/", s.observe("root", http.HandlerFunc(s.handleRoot)))
+	mux.Handle("/api/v1/upscale", s.observe("upscale", WithAuth(clientAPIKey, nil)(http.HandlerFunc(s.handleUpscale))))
+	mux.Handle("/api/v1/upscale/result/", s.observe("upscale_result", WithAuth(clientAPIKey, nil)(http.HandlerFunc(s.handleUpscaleResult))))
+	mux.Handle("/api/v1/video", s.observe("video", WithAuth(clientAPIKey, nil)(http.HandlerFunc(s.handleVideo))))
+	mux.Handle("/api/v1/video/result/", s.observe("video_result", WithAuth(clientAPIKey, nil)(http.HandlerFunc(s.handleVideoResult))))
+	mux.Handle("/health", s.observe("health", http.HandlerFunc(s.handleHealthCheck)))
+	mux.Handle("/api/docs", s.observe("docs", http.HandlerFunc(s.handleDocs)))
 
 	// Apply global middleware
 	s.Router = Chain(
@@ -90,6 +151,60 @@ func New(client *client.Client, logger *logger.Logger, cachePath string, rateLim
 	return s
 }
 
+// WithCircuitBreaker sets the circuit breaker that guards the server's Stability AI client,
+// so its state is reported by the health check
+func (s *Server) WithCircuitBreaker(breaker *client.CircuitBreaker) *Server {
+	s.breaker = breaker
+	return s
+}
+
+// WithTracer reports a span for every request to the tracer. Requests to Stability AI made while serving it are
+// reported as its children, unless the client has a tracer of its own.
+func (s *Server) WithTracer(tracer telemetry.Tracer) *Server {
+	s.tracer = tracer
+	return s
+}
+
+// WithMeter reports request counts and durations of every handler to the meter, along with the client's metrics
+// unless the client has a meter of its own
+func (s *Server) WithMeter(meter telemetry.Meter) *Server {
+	s.meter = meter
+	return s
+}
+
+// observe wraps a handler in a span and records its request count and duration
+func (s *Server) observe(handler string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		ctx := r.Context()
+		if s.tracer != nil {
+			ctx = telemetry.ContextWithTracer(ctx, s.tracer)
+		}
+		if s.meter != nil {
+			ctx = telemetry.ContextWithMeter(ctx, s.meter)
+		}
+		ctx, span := telemetry.StartSpan(ctx, "api."+handler,
+			telemetry.String(telemetry.AttrHandler, handler), telemetry.String(telemetry.AttrMethod, r.Method))
+
+		crw := &captureResponseWriter{
+			ResponseWriter: w,
+			statusCode:     http.StatusOK,
+		}
+		next.ServeHTTP(crw, r.WithContext(ctx))
+
+		span.SetAttributes(telemetry.Int(telemetry.AttrStatusCode, crw.statusCode))
+		if crw.statusCode >= http.StatusInternalServerError {
+			span.RecordError(fmt.Errorf("%s responded with status %d", handler, crw.statusCode))
+		}
+		span.End()
+
+		telemetry.Add(ctx, telemetry.MetricServerRequests, 1,
+			telemetry.String(telemetry.AttrHandler, handler), telemetry.Int(telemetry.AttrStatusCode, crw.statusCode))
+		telemetry.RecordDuration(ctx, telemetry.MetricServerDuration, start, telemetry.String(telemetry.AttrHandler, handler))
+	})
+}
+
 // Start starts the API server
 func (s *Server) Start(addr string) error {
 	s.Logger.Info("Starting API server on %s", addr)
@@ -105,7 +220,7 @@ func (s *Server) handleUpscale(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Parse multipart form
-	if err := r.ParseMultipartForm(32 << 20); err != nil {
+	if err := r.ParseMultipartForm(uploadMemoryLimit); err != nil {
 		s.sendError(w, "Failed to parse form", http.StatusBadRequest)
 		return
 	}
@@ -118,6 +233,7 @@ func (s *Server) handleUpscale(w http.ResponseWriter, r *http.Request) {
 
 	// Map upscale type to enum
 	var upscaleTypeEnum client.UpscaleType
+	tiled := false
 	switch upscaleType {
 	case "fast":
 		upscaleTypeEnum = client.UpscaleTypeFast
@@ -125,6 +241,13 @@ func (s *Server) handleUpscale(w http.ResponseWriter, r *http.Request) {
 		upscaleTypeEnum = client.UpscaleTypeConservative
 	case "creative":
 		upscaleTypeEnum = client.UpscaleTypeCreative
+	case "tiled":
+		// Tiled upscales split the image and upscale each tile with tile_type
+		tiled = true
+		upscaleTypeEnum = client.UpscaleType(r.FormValue("tile_type"))
+		if upscaleTypeEnum == "" {
+			upscaleTypeEnum = client.UpscaleTypeConservative
+		}
 	default:
 		s.sendError(w, "Invalid upscale type", http.StatusBadRequest)
 		return
@@ -145,16 +268,13 @@ func (s *Server) handleUpscale(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	// Read image data
-	imageData, err := io.ReadAll(file)
+	// Generate cache key
+	cacheKey, err := generateCacheKey(file, r.Form)
 	if err != nil {
 		s.sendError(w, "Failed to read image data", http.StatusInternalServerError)
 		return
 	}
 
-	// Generate cache key
-	cacheKey := generateCacheKey(imageData, r.Form)
-
 	// Check cache if enabled
 	if s.CachePath != "" {
 		cachePath := filepath.Join(s.CachePath, cacheKey+".json")
@@ -244,27 +364,27 @@ func (s *Server) handleUpscale(w http.ResponseWriter, r *http.Request) {
 
 	// Create upscale request
 	request := client.UpscaleRequest{
-		Image:          imageData,
-		Filename:       header.Filename,
-		Type:           upscaleTypeEnum,
-		Prompt:         prompt,
-		NegativePrompt: negativePrompt,
-		Seed:           seed,
-		OutputFormat:   outputFormatEnum,
-		Creativity:     creativity,
-		StylePreset:    stylePresetEnum,
-		ReturnAsJSON:   true,
+		ImageReader:          file,
+		Filename:             header.Filename,
+		Type:                 upscaleTypeEnum,
+		Prompt:               prompt,
+		NegativePrompt:       negativePrompt,
+		Seed:                 seed,
+		OutputFormat:         outputFormatEnum,
+		Creativity:           creativity,
+		StylePreset:          stylePresetEnum,
+		ReturnAsJSON:         true,
+		Preprocess:           r.FormValue("preprocess") == "true",
+		AllowContentFiltered: r.FormValue("allow_filtered") == "true",
 	}
 
-	// Send request to Stability AI
-	s.Logger.Info("Sending upscale request to Stability AI (type: %s)", upscaleType)
-	ctx, cancel := context.WithTimeout(r.Context(), 60*time.Second)
-	defer cancel()
-
-	response, err := s.Client.Upscale(ctx, request)
+	var response *client.UpscaleResponse
+	if tiled {
+		response, err = s.upscaleTiled(w, r, request)
+	} else {
+		response, err = s.upscale(w, r, request)
+	}
 	if err != nil {
-		s.Logger.Error("Error from Stability AI: %v", err)
-		s.sendError(w, fmt.Sprintf("Error from Stability AI: %v", err), http.StatusInternalServerError)
 		return
 	}
 
@@ -275,14 +395,19 @@ func (s *Server) handleUpscale(w http.ResponseWriter, r *http.Request) {
 	if upscaleTypeEnum == client.UpscaleTypeCreative {
 		// For creative upscale, we get an ID for polling
 		upscaleResp = UpscaleResponse{
-			ID:      response.CreativeID,
-			Pending: true,
+			ID:            response.CreativeID,
+			Pending:       true,
+			Preprocessing: response.Preprocessing,
+			Metadata:      response.Metadata,
 		}
 	} else {
-		// For fast and conservative upscale, we get the image directly
+		// For fast, conservative and tiled upscale, we get the image directly
 		// Base64 encode the image for JSON response
 		upscaleResp = UpscaleResponse{
-			Image: "data:" + response.MimeType + ";base64," + encodeBase64(response.ImageData),
+			Image:           "data:" + response.MimeType + ";base64," + encodeBase64(response.ImageData),
+			Preprocessing:   response.Preprocessing,
+			Metadata:        response.Metadata,
+			ContentFiltered: response.ContentFiltered,
 		}
 	}
 
@@ -314,6 +439,71 @@ func (s *Server) handleUpscale(w http.ResponseWriter, r *http.Request) {
 	w.Write(responseData)
 }
 
+// upscale sends a single upscale request to Stability AI.
+// Errors are sent to the client before they are returned.
+func (s *Server) upscale(w http.ResponseWriter, r *http.Request, request client.UpscaleRequest) (*client.UpscaleResponse, error) {
+	// Reject requests Stability AI would refuse, without spending a request on them
+	if err := request.Validate(); err != nil {
+		s.sendValidationError(w, err)
+		return nil, err
+	}
+
+	// Send request to Stability AI
+	s.Logger.Info("Sending upscale request to Stability AI (type: %s)", request.Type)
+	ctx, cancel := context.WithTimeout(r.Context(), 60*time.Second)
+	defer cancel()
+
+	response, err := s.Client.Upscale(ctx, request)
+	if err != nil {
+		s.sendUpstreamError(w, "Error from Stability AI", err)
+		return nil, err
+	}
+	return response, nil
+}
+
+// upscaleTiled upscales an image too large for the upscalers in overlapping tiles, using the fields of request for every tile.
+// Errors are sent to the client before they are returned.
+func (s *Server) upscaleTiled(w http.ResponseWriter, r *http.Request, request client.UpscaleRequest) (*client.UpscaleResponse, error) {
+	tileSize, _ := strconv.Atoi(r.FormValue("tile_size"))
+	tileOverlap, _ := strconv.Atoi(r.FormValue("tile_overlap"))
+
+	// The tiles are cut from the decoded image, so it has to be read into memory
+	imageData, err := io.ReadAll(request.ImageReader)
+	if err != nil {
+		s.sendError(w, "Failed to read image data", http.StatusInternalServerError)
+		return nil, err
+	}
+
+	tiledRequest := client.TiledUpscaleRequest{
+		Image:                imageData,
+		Type:                 request.Type,
+		Prompt:               request.Prompt,
+		NegativePrompt:       request.NegativePrompt,
+		Seed:                 request.Seed,
+		Creativity:           request.Creativity,
+		OutputFormat:         request.OutputFormat,
+		TileSize:             tileSize,
+		Overlap:              tileOverlap,
+		AllowContentFiltered: request.AllowContentFiltered,
+	}
+	if err := tiledRequest.Validate(); err != nil {
+		s.sendValidationError(w, err)
+		return nil, err
+	}
+
+	// Every tile is a separate request, so allow much longer than for a single upscale
+	s.Logger.Info("Sending tiled upscale request to Stability AI (tile type: %s)", request.Type)
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Minute)
+	defer cancel()
+
+	response, err := s.Client.UpscaleTiled(ctx, tiledRequest)
+	if err != nil {
+		s.sendUpstreamError(w, "Error from Stability AI", err)
+		return nil, err
+	}
+	return response, nil
+}
+
 // handleUpscaleResult handles polling for creative upscale results
 func (s *Server) handleUpscaleResult(w http.ResponseWriter, r *http.Request) {
 	// Only allow GET requests
@@ -336,8 +526,7 @@ func (s *Server) handleUpscaleResult(w http.ResponseWriter, r *http.Request) {
 
 	result, finished, err := s.Client.PollCreativeResult(ctx, id)
 	if err != nil {
-		s.Logger.Error("Error polling for creative upscale result: %v", err)
-		s.sendError(w, fmt.Sprintf("Error polling for creative upscale result: %v", err), http.StatusInternalServerError)
+		s.sendUpstreamError(w, "Error polling for creative upscale result", err)
 		return
 	}
 
@@ -349,7 +538,18 @@ func (s *Server) handleUpscaleResult(w http.ResponseWriter, r *http.Request) {
 
 	// If the upscale is finished, include the image data
 	if finished {
+		if result.ContentFiltered && r.URL.Query().Get("allow_filtered") != "true" {
+			s.sendContentFiltered(w, &apierrors.ContentFilteredError{
+				Operation: "creative upscale",
+				RequestID: result.Metadata.RequestID,
+				Seed:      result.Metadata.Seed,
+			})
+			return
+		}
+
 		upscaleResp.Image = "data:" + result.MimeType + ";base64," + encodeBase64(result.ImageData)
+		upscaleResp.Metadata = result.Metadata
+		upscaleResp.ContentFiltered = result.ContentFiltered
 	}
 
 	// Send response
@@ -359,6 +559,128 @@ func (s *Server) handleUpscaleResult(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// handleVideo handles image-to-video requests
+func (s *Server) handleVideo(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		s.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse multipart form
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		s.sendError(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	// Get image file
+	file, header, err := r.FormFile("image")
+	if err != nil {
+		s.sendError(w, "Failed to get image file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	// Read image data
+	imageData, err := io.ReadAll(file)
+	if err != nil {
+		s.sendError(w, "Failed to read image data", http.StatusInternalServerError)
+		return
+	}
+
+	// Get optional parameters
+	seed, _ := strconv.ParseInt(r.FormValue("seed"), 10, 64)
+	var cfgScale float64
+	if cfgScaleStr := r.FormValue("cfg_scale"); cfgScaleStr != "" {
+		cfgScale, err = strconv.ParseFloat(cfgScaleStr, 64)
+		if err != nil {
+			s.sendError(w, "Invalid cfg_scale", http.StatusBadRequest)
+			return
+		}
+	}
+	var motionBucketID int
+	if motionBucketIDStr := r.FormValue("motion_bucket_id"); motionBucketIDStr != "" {
+		motionBucketID, err = strconv.Atoi(motionBucketIDStr)
+		if err != nil {
+			s.sendError(w, "Invalid motion_bucket_id", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Create image-to-video request
+	request := client.ImageToVideoRequest{
+		Image:          imageData,
+		Filename:       header.Filename,
+		Seed:           seed,
+		CfgScale:       cfgScale,
+		MotionBucketID: motionBucketID,
+	}
+
+	// Send request to Stability AI
+	s.Logger.Info("Sending image-to-video request to Stability AI")
+	ctx, cancel := context.WithTimeout(r.Context(), 60*time.Second)
+	defer cancel()
+
+	response, err := s.Client.ImageToVideo(ctx, request)
+	if err != nil {
+		s.sendUpstreamError(w, "Error from Stability AI", err)
+		return
+	}
+
+	// Videos are generated asynchronously, so we return an ID for polling
+	s.sendJSON(w, Response{
+		Success: true,
+		Data: VideoResponse{
+			ID:      response.ID,
+			Pending: true,
+		},
+	})
+}
+
+// handleVideoResult handles polling for image-to-video results
+func (s *Server) handleVideoResult(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		s.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get video ID from URL
+	id := filepath.Base(r.URL.Path)
+	if id == "" {
+		s.sendError(w, "Missing video ID", http.StatusBadRequest)
+		return
+	}
+
+	// Poll for the result
+	s.Logger.Info("Polling for video result (ID: %s)", id)
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	result, finished, err := s.Client.PollVideoResult(ctx, id)
+	if err != nil {
+		s.sendUpstreamError(w, "Error polling for video result", err)
+		return
+	}
+
+	// Prepare response
+	videoResp := VideoResponse{
+		ID:      id,
+		Pending: !finished,
+	}
+
+	// If the video is finished, include the video data
+	if finished {
+		videoResp.Video = "data:" + result.MimeType + ";base64," + encodeBase64(result.VideoData)
+	}
+
+	// Send response
+	s.sendJSON(w, Response{
+		Success: true,
+		Data:    videoResp,
+	})
+}
+
 // handleHealthCheck handles health check requests
 func (s *Server) handleHealthCheck(w http.ResponseWriter, r *http.Request) {
 	// Only allow GET requests
@@ -371,7 +693,67 @@ func (s *Server) handleHealthCheck(w http.ResponseWriter, r *http.Request) {
 	info := map[string]interface{}{
 		"status":  "ok",
 		"version": "1.0.0",
-		"uptime":  "up",
+		"uptime":  time.Since(s.startTime).Round(time.Second).String(),
+	}
+
+	// Report the state of the circuit breaker guarding Stability AI
+	if s.breaker != nil {
+		stats := s.breaker.Stats()
+		upstream := map[string]interface{}{
+			"state":    stats.State.String(),
+			"since":    stats.Since.UTC().Format(time.RFC3339),
+			"requests": stats.Requests,
+			"failures": stats.Failures,
+		}
+		if stats.State == client.CircuitOpen {
+			upstream["retry_after"] = stats.RetryAfter.Round(time.Second).String()
+		}
+		if stats.State != client.CircuitClosed {
+			info["status"] = "degraded"
+		}
+		info["upstream"] = upstream
+	}
+
+	// Report the remaining Stability AI credits so operators can alert on low balance
+	balance := s.getBalance(r.Context())
+	if balance.err != nil {
+		info["balance_error"] = balance.err.Error()
+	} else {
+		info["credits"] = balance.credits
+	}
+
+	// Report the health and balance of each Stability AI key, identified by position and fingerprint only
+	if s.Client.Keys != nil {
+		keys := make([]map[string]interface{}, 0, s.Client.Keys.Len())
+		healthy := 0
+		for i, stats := range s.Client.Keys.Stats() {
+			key := map[string]interface{}{
+				"name":        stats.Name,
+				"fingerprint": stats.Fingerprint,
+				"healthy":     stats.Healthy,
+				"requests":    stats.Requests,
+				"rate_limits": stats.RateLimits,
+				"failures":    stats.Failures,
+			}
+			if stats.Healthy {
+				healthy++
+			} else {
+				key["benched_until"] = stats.BenchedUntil.UTC().Format(time.RFC3339)
+				key["bench_reason"] = stats.BenchReason
+			}
+			if i < len(balance.keys) {
+				if err := balance.keys[i].Err; err != nil {
+					key["balance_error"] = err.Error()
+				} else {
+					key["credits"] = balance.keys[i].Credits
+				}
+			}
+			keys = append(keys, key)
+		}
+		if healthy == 0 {
+			info["status"] = "degraded"
+		}
+		info["keys"] = keys
 	}
 
 	// Send response
@@ -381,6 +763,80 @@ func (s *Server) handleHealthCheck(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// getBalance returns the credit balance, fetching it from Stability AI at most once per balanceCacheTTL.
+// Concurrent health checks share a single fetch, and the lock is never held while it runs.
+func (s *Server) getBalance(ctx context.Context) creditBalance {
+	s.balanceMutex.Lock()
+	if !s.balanceCheckedAt.IsZero() && time.Since(s.balanceCheckedAt) < balanceCacheTTL {
+		defer s.balanceMutex.Unlock()
+		return s.balance
+	}
+
+	// Join the fetch in flight or start one. It outlives the request that started it,
+	// so a health check that gives up doesn't fail the others.
+	done := s.balanceFetch
+	if done == nil {
+		done = make(chan struct{})
+		s.balanceFetch = done
+		go s.refreshBalance(context.WithoutCancel(ctx), done)
+	}
+	s.balanceMutex.Unlock()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return creditBalance{err: ctx.Err()}
+	}
+
+	s.balanceMutex.Lock()
+	defer s.balanceMutex.Unlock()
+	return s.balance
+}
+
+// refreshBalance fetches the credit balance and swaps it into the cache, closing done when finished
+func (s *Server) refreshBalance(ctx context.Context, done chan struct{}) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	balance := s.fetchBalance(ctx)
+
+	s.balanceMutex.Lock()
+	s.balance = balance
+	s.balanceCheckedAt = time.Now()
+	s.balanceFetch = nil
+	s.balanceMutex.Unlock()
+	close(done)
+}
+
+// fetchBalance fetches the credit balance from Stability AI. With a key pool, the balance of every key is fetched,
+// since keys may belong to different accounts.
+func (s *Server) fetchBalance(ctx context.Context) creditBalance {
+	if s.Client.Keys == nil || s.Client.Keys.Len() == 0 {
+		balance, err := s.Client.GetBalance(ctx)
+		if err != nil {
+			s.Logger.Warn("Failed to fetch credit balance: %v", err)
+			return creditBalance{err: err}
+		}
+		return creditBalance{credits: balance.Credits}
+	}
+
+	b := creditBalance{keys: s.Client.GetKeyBalances(ctx)}
+	fetched := 0
+	for _, key := range b.keys {
+		if key.Err != nil {
+			s.Logger.Warn("Failed to fetch credit balance of %s: %v", key.Name, key.Err)
+			b.err = key.Err
+			continue
+		}
+		b.credits += key.Credits
+		fetched++
+	}
+	if fetched > 0 {
+		b.err = nil
+	}
+	return b
+}
+
 // handleDocs serves the API documentation
 func (s *Server) handleDocs(w http.ResponseWriter, r *http.Request) {
 	// Only allow GET requests
@@ -416,10 +872,26 @@ func (s *Server) handleDocs(w http.ResponseWriter, r *http.Request) {
 										},
 										"type": map[string]interface{}{
 											"type":        "string",
-											"description": "The upscale type (fast, conservative, creative)",
-											"enum":        []string{"fast", "conservative", "creative"},
+											"description": "The upscale type (fast, conservative, creative, or tiled for images larger than the upscalers accept)",
+											"enum":        []string{"fast", "conservative", "creative", "tiled"},
 											"default":     "fast",
 										},
+										"tile_type": map[string]interface{}{
+											"type":        "string",
+											"description": "The upscale type used for each tile of a tiled upscale",
+											"enum":        []string{"fast", "conservative"},
+											"default":     "conservative",
+										},
+										"tile_size": map[string]interface{}{
+											"type":        "integer",
+											"description": "The width and height of the tiles in input pixels (tiled only)",
+											"default":     512,
+										},
+										"tile_overlap": map[string]interface{}{
+											"type":        "integer",
+											"description": "The number of input pixels neighbouring tiles share (tiled only)",
+											"default":     64,
+										},
 										"prompt": map[string]interface{}{
 											"type":        "string",
 											"description": "The prompt to guide upscaling (required for conservative and creative)",
@@ -452,6 +924,16 @@ func (s *Server) handleDocs(w http.ResponseWriter, r *http.Request) {
 											"enum":        []string{"png", "jpeg", "webp"},
 											"default":     "png",
 										},
+										"preprocess": map[string]interface{}{
+											"type":        "boolean",
+											"description": "Downsize, rotate and re-encode the image to fit the upscaler's input limits",
+											"default":     false,
+										},
+										"allow_filtered": map[string]interface{}{
+											"type":        "boolean",
+											"description": "Return images blurred by the content filter with content_filtered set instead of a 422",
+											"default":     false,
+										},
 									},
 									"required": []string{"image"},
 								},
@@ -479,6 +961,16 @@ func (s *Server) handleDocs(w http.ResponseWriter, r *http.Request) {
 								},
 							},
 						},
+						"422": map[string]interface{}{
+							"description": "The result was blurred by the content filter (code content_filtered)",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": map[string]interface{}{
+										"$ref": "#/components/schemas/ErrorResponse",
+									},
+								},
+							},
+						},
 					},
 				},
 			},
@@ -496,6 +988,15 @@ func (s *Server) handleDocs(w http.ResponseWriter, r *http.Request) {
 								"type": "string",
 							},
 						},
+						{
+							"name":        "allow_filtered",
+							"in":          "query",
+							"description": "Return images blurred by the content filter with content_filtered set instead of a 422",
+							"schema": map[string]interface{}{
+								"type":    "boolean",
+								"default": false,
+							},
+						},
 					},
 					"responses": map[string]interface{}{
 						"200": map[string]interface{}{
@@ -518,6 +1019,93 @@ func (s *Server) handleDocs(w http.ResponseWriter, r *http.Request) {
 								},
 							},
 						},
+						"422": map[string]interface{}{
+							"description": "The result was blurred by the content filter (code content_filtered)",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": map[string]interface{}{
+										"$ref": "#/components/schemas/ErrorResponse",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			"/api/v1/video": map[string]interface{}{
+				"post": map[string]interface{}{
+					"summary":     "Generate a video from an image",
+					"description": "Starts an image-to-video job and returns an ID for polling",
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content": map[string]interface{}{
+							"multipart/form-data": map[string]interface{}{
+								"schema": map[string]interface{}{
+									"type": "object",
+									"properties": map[string]interface{}{
+										"image": map[string]interface{}{
+											"type":        "string",
+											"format":      "binary",
+											"description": "The image to animate (1024x576, 576x1024 or 768x768)",
+										},
+										"seed": map[string]interface{}{
+											"type":        "integer",
+											"description": "The seed for consistent results (optional)",
+										},
+										"cfg_scale": map[string]interface{}{
+											"type":        "number",
+											"description": "How strongly the video sticks to the original image (0-10)",
+										},
+										"motion_bucket_id": map[string]interface{}{
+											"type":        "integer",
+											"description": "Amount of motion in the video (1-255)",
+										},
+									},
+									"required": []string{"image"},
+								},
+							},
+						},
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "Successful response",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": map[string]interface{}{
+										"$ref": "#/components/schemas/VideoResponse",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			"/api/v1/video/result/{id}": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary":     "Get the result of an image-to-video job",
+					"description": "Polls for the result of an image-to-video job",
+					"parameters": []map[string]interface{}{
+						{
+							"name":        "id",
+							"in":          "path",
+							"description": "The video ID",
+							"required":    true,
+							"schema": map[string]interface{}{
+								"type": "string",
+							},
+						},
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "Successful response",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": map[string]interface{}{
+										"$ref": "#/components/schemas/VideoResponse",
+									},
+								},
+							},
+						},
 					},
 				},
 			},
@@ -568,6 +1156,43 @@ func (s *Server) handleDocs(w http.ResponseWriter, r *http.Request) {
 									"type":        "boolean",
 									"description": "Whether the upscale is still pending (only for creative upscale)",
 								},
+								"preprocessing": map[string]interface{}{
+									"type":        "object",
+									"description": "How the image was resized, rotated or re-encoded to fit the upscaler's limits (only when preprocess is true)",
+								},
+								"metadata": map[string]interface{}{
+									"$ref": "#/components/schemas/Metadata",
+								},
+								"content_filtered": map[string]interface{}{
+									"type":        "boolean",
+									"description": "Whether the image was blurred by the content filter (only when allow_filtered is true)",
+								},
+							},
+						},
+					},
+				},
+				"VideoResponse": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"success": map[string]interface{}{
+							"type":        "boolean",
+							"description": "Whether the request was successful",
+						},
+						"data": map[string]interface{}{
+							"type": "object",
+							"properties": map[string]interface{}{
+								"id": map[string]interface{}{
+									"type":        "string",
+									"description": "The video ID to poll",
+								},
+								"video": map[string]interface{}{
+									"type":        "string",
+									"description": "The mp4 video as a base64-encoded data URI",
+								},
+								"pending": map[string]interface{}{
+									"type":        "boolean",
+									"description": "Whether the video is still generating",
+								},
 							},
 						},
 					},
@@ -583,6 +1208,62 @@ func (s *Server) handleDocs(w http.ResponseWriter, r *http.Request) {
 							"type":        "string",
 							"description": "Error message",
 						},
+						"code": map[string]interface{}{
+							"type":        "string",
+							"description": "Machine-readable error code (content_filtered for results blurred by the content filter)",
+						},
+						"errors": map[string]interface{}{
+							"type":        "array",
+							"description": "Fields that failed validation",
+							"items": map[string]interface{}{
+								"type": "object",
+								"properties": map[string]interface{}{
+									"field": map[string]interface{}{
+										"type": "string",
+									},
+									"message": map[string]interface{}{
+										"type": "string",
+									},
+								},
+							},
+						},
+						"metadata": map[string]interface{}{
+							"$ref": "#/components/schemas/Metadata",
+						},
+					},
+				},
+				"Metadata": map[string]interface{}{
+					"type":        "object",
+					"description": "How Stability AI produced the response; include the request ID when contacting Stability AI support",
+					"properties": map[string]interface{}{
+						"seed": map[string]interface{}{
+							"type":        "integer",
+							"description": "The seed used, to reproduce the result (tiled upscales report the requested seed)",
+						},
+						"finish_reason": map[string]interface{}{
+							"type":        "string",
+							"description": "SUCCESS or CONTENT_FILTERED",
+						},
+						"request_id": map[string]interface{}{
+							"type":        "string",
+							"description": "The Stability AI request ID",
+						},
+						"error_id": map[string]interface{}{
+							"type":        "string",
+							"description": "The ID of the error reported by Stability AI (only for failed requests)",
+						},
+						"duration_ms": map[string]interface{}{
+							"type":        "integer",
+							"description": "How long the Stability AI request took in milliseconds, including retries",
+						},
+						"attempts": map[string]interface{}{
+							"type":        "integer",
+							"description": "How many attempts were made to send the request",
+						},
+						"credits": map[string]interface{}{
+							"type":        "number",
+							"description": "Credits charged for the request",
+						},
 					},
 				},
 				"HealthResponse": map[string]interface{}{
@@ -607,6 +1288,14 @@ func (s *Server) handleDocs(w http.ResponseWriter, r *http.Request) {
 									"type":        "string",
 									"description": "API uptime",
 								},
+								"credits": map[string]interface{}{
+									"type":        "number",
+									"description": "Remaining Stability AI credits, summed over the keys of a key pool",
+								},
+								"balance_error": map[string]interface{}{
+									"type":        "string",
+									"description": "Error fetching the credit balance, if any",
+								},
 							},
 						},
 					},
@@ -646,6 +1335,101 @@ func (s *Server) sendError(w http.ResponseWriter, message string, statusCode int
 	json.NewEncoder(w).Encode(response)
 }
 
+// sendValidationError sends a 400 listing the fields of a request that failed validation
+func (s *Server) sendValidationError(w http.ResponseWriter, err error) {
+	response := Response{
+		Success: false,
+		Error:   err.Error(),
+	}
+	var validationErr *apierrors.ValidationError
+	if errors.As(err, &validationErr) {
+		response.Errors = validationErr.Errors
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(response)
+}
+
+// sendUpstreamError sends the error of a failed Stability AI request.
+// Requests rejected by the open circuit breaker fail fast with a 503 and a Retry-After header.
+// Requests the client rejected before sending them are answered with a 400 listing the invalid fields.
+func (s *Server) sendUpstreamError(w http.ResponseWriter, message string, err error) {
+	if apierrors.IsValidationError(err) {
+		s.sendValidationError(w, err)
+		return
+	}
+
+	if apierrors.IsContentFiltered(err) {
+		s.sendContentFiltered(w, err)
+		return
+	}
+
+	var circuitErr *apierrors.CircuitOpenError
+	if errors.As(err, &circuitErr) {
+		s.Logger.Warn("%s: %v", message, err)
+		if circuitErr.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(circuitErr.RetryAfter.Seconds()))))
+		}
+		s.sendError(w, "Stability AI is currently unavailable, please try again later", http.StatusServiceUnavailable)
+		return
+	}
+
+	statusCode := upstreamStatus(err)
+	if statusCode < http.StatusInternalServerError {
+		s.Logger.Warn("%s: %v", message, err)
+	} else {
+		s.Logger.Error("%s: %v", message, err)
+	}
+
+	// Pass on how long Stability AI asked us to back off, so our clients slow down too
+	if apiErr, ok := apierrors.AsAPIError(err); ok && statusCode == http.StatusTooManyRequests && apiErr.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(apiErr.RetryAfter.Seconds()))))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(Response{
+		Success:  false,
+		Error:    fmt.Sprintf("%s: %v", message, err),
+		Metadata: client.ErrorMetadata(err),
+	})
+}
+
+// upstreamStatus returns the status code to answer a failed Stability AI request with.
+// Client errors reported by Stability AI (400, 401, 402, 403, 429...) are passed through, so callers can tell
+// a bad request or missing credits from an outage; Stability AI server errors and failures to reach it are
+// answered with a 502, and anything else with a 500.
+func upstreamStatus(err error) int {
+	if apiErr, ok := apierrors.AsAPIError(err); ok {
+		if apiErr.StatusCode >= 400 && apiErr.StatusCode < 500 {
+			return apiErr.StatusCode
+		}
+		return http.StatusBadGateway
+	}
+
+	var retryErr *apierrors.RetryError
+	var urlErr *url.Error
+	if errors.As(err, &retryErr) || errors.As(err, &urlErr) {
+		return http.StatusBadGateway
+	}
+	return http.StatusInternalServerError
+}
+
+// sendContentFiltered sends a 422 for a result blurred by the content filter, with the seed and request ID
+func (s *Server) sendContentFiltered(w http.ResponseWriter, err error) {
+	s.Logger.Warn("%v", err)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(Response{
+		Success:  false,
+		Error:    err.Error(),
+		Code:     ErrorCodeContentFiltered,
+		Metadata: client.ErrorMetadata(err),
+	})
+}
+
 // sendJSON sends a JSON response
 func (s *Server) sendJSON(w http.ResponseWriter, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
@@ -653,17 +1437,24 @@ func (s *Server) sendJSON(w http.ResponseWriter, data interface{}) {
 	json.NewEncoder(w).Encode(data)
 }
 
-// generateCacheKey generates a cache key for the request
-func generateCacheKey(imageData []byte, formData map[string][]string) string {
+// generateCacheKey generates a cache key for the request.
+// The image is hashed as it is read and rewound afterwards, so it doesn't have to be held in memory.
+func generateCacheKey(image io.ReadSeeker, formData map[string][]string) (string, error) {
 	// Create a hash of the image data
-	hash := sha256.Sum256(imageData)
-	imageHash := hex.EncodeToString(hash[:])
+	hash := sha256.New()
+	if _, err := io.Copy(hash, image); err != nil {
+		return "", err
+	}
+	if _, err := image.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	imageHash := hex.EncodeToString(hash.Sum(nil))
 
 	// Add form data to the hash
 	formString := fmt.Sprintf("%v", formData)
 	combinedHash := sha256.Sum256([]byte(imageHash + formString))
 
-	return hex.EncodeToString(combinedHash[:])
+	return hex.EncodeToString(combinedHash[:]), nil
 }
 
 // encodeBase64 encodes data as base64
@@ -753,20 +1544,23 @@ func (s *Server) handleRoot(w http.ResponseWriter, r *http.Request) {
             <span class="url">/api/v1/upscale</span>
         </h4>
         <p>Upscale an image using Stability AI's upscaling API.</p>
-        <p>Supports fast, conservative, and creative upscaling types.</p>
+        <p>Supports fast, conservative, and creative upscaling types, and tiled upscaling for images larger than the upscalers accept.</p>
         <p>Required parameters:</p>
         <ul>
             <li><code>image</code>: The image file to upscale (multipart/form-data)</li>
         </ul>
         <p>Optional parameters:</p>
         <ul>
-            <li><code>type</code>: Upscale type - "fast", "conservative", or "creative" (default: "fast")</li>
+            <li><code>type</code>: Upscale type - "fast", "conservative", "creative", or "tiled" (default: "fast")</li>
             <li><code>prompt</code>: Text prompt to guide upscaling (required for "conservative" and "creative" types)</li>
             <li><code>negative_prompt</code>: Negative prompt to guide upscaling</li>
             <li><code>seed</code>: Seed for consistent results</li>
             <li><code>creativity</code>: Creativity level (0.1-0.5)</li>
             <li><code>output_format</code>: Output format - "png", "jpeg", or "webp" (default: "png")</li>
             <li><code>style_preset</code>: Style preset for creative upscaling (e.g., "enhance", "anime", "photographic")</li>
+            <li><code>tile_type</code>: Upscale type for each tile of a tiled upscale - "fast" or "conservative" (default: "conservative")</li>
+            <li><code>tile_size</code>, <code>tile_overlap</code>: Tile size and overlap in input pixels for tiled upscaling (default: 512 and 64)</li>
+            <li><code>allow_filtered</code>: Return images blurred by the content filter instead of a 422 with code "content_filtered" (default: false)</li>
         </ul>
     </div>
     
@@ -776,7 +1570,33 @@ func (s *Server) handleRoot(w http.ResponseWriter, r *http.Request) {
             <span class="url">/api/v1/upscale/result/{id}</span>
         </h4>
         <p>Poll for the result of a creative upscale request.</p>
-        <p>Replace <code>{id}</code> with the ID returned from a creative upscale request.</p>
+        <p>Replace <code>{id}</code> with the ID returned from a creative upscale request. Add <code>?allow_filtered=true</code> to receive results blurred by the content filter.</p>
+    </div>
+    
+    <div class="endpoint">
+        <h4>
+            <span class="method post">POST</span>
+            <span class="url">/api/v1/video</span>
+        </h4>
+        <p>Generate a short mp4 video from an image. Returns an ID for polling.</p>
+        <p>Required parameters:</p>
+        <ul>
+            <li><code>image</code>: The image to animate, 1024x576, 576x1024 or 768x768 (multipart/form-data)</li>
+        </ul>
+        <p>Optional parameters:</p>
+        <ul>
+            <li><code>seed</code>: Seed for consistent results</li>
+            <li><code>cfg_scale</code>: How strongly the video sticks to the original image (0-10)</li>
+            <li><code>motion_bucket_id</code>: Amount of motion in the video (1-255)</li>
+        </ul>
+    </div>
+    
+    <div class="endpoint">
+        <h4>
+            <span class="method get">GET</span>
+            <span class="url">/api/v1/video/result/{id}</span>
+        </h4>
+        <p>Poll for the result of an image-to-video request.</p>
     </div>
     
     <div class="endpoint">
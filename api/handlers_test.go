@@ -0,0 +1,424 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/marcusziade/stability-go/client"
+	"github.com/marcusziade/stability-go/internal/logger"
+	"github.com/marcusziade/stability-go/stabilitytest"
+)
+
+// testClientKey is the key clients of the test server authenticate with
+const testClientKey = "client-key"
+
+// newTestServer returns an API server talking to a fake Stability API
+func newTestServer(t *testing.T, config stabilitytest.ServerConfig) (*Server, *stabilitytest.Server) {
+	t.Helper()
+	upstream := stabilitytest.NewServer(config)
+	t.Cleanup(upstream.Close)
+	return New(upstream.NewClient(), logger.New(logger.Error), "", 0, "", testClientKey, nil, nil, nil), upstream
+}
+
+// upscaleRequest returns an authenticated upscale request for a 100x100 PNG with the given form fields
+func upscaleRequest(t *testing.T, fields map[string]string) *http.Request {
+	t.Helper()
+
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewNRGBA(image.Rect(0, 0, 100, 100))); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	fw, err := w.CreateFormFile("image", "input.png")
+	if err != nil {
+		t.Fatalf("failed to create form file: %v", err)
+	}
+	fw.Write(img.Bytes())
+	for key, value := range fields {
+		w.WriteField(key, value)
+	}
+	w.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/upscale", &body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+testClientKey)
+	return req
+}
+
+// testMetadata is client.Metadata as encoded in responses
+type testMetadata struct {
+	Seed      int64  `json:"seed"`
+	RequestID string `json:"request_id"`
+}
+
+// testResponse is a Response carrying an UpscaleResponse, decoded by the test client
+type testResponse struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error"`
+	Code    string `json:"code"`
+	Data    struct {
+		ID              string        `json:"id"`
+		Image           string        `json:"image"`
+		Pending         bool          `json:"pending"`
+		ContentFiltered bool          `json:"content_filtered"`
+		Metadata        *testMetadata `json:"metadata"`
+	} `json:"data"`
+	Metadata *testMetadata `json:"metadata"`
+}
+
+// serve sends a request to the server and decodes the response
+func serve(t *testing.T, s *Server, req *http.Request) (*httptest.ResponseRecorder, testResponse) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.Router.ServeHTTP(rec, req)
+
+	var resp testResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response %q: %v", rec.Body.String(), err)
+	}
+	return rec, resp
+}
+
+func TestHandleUpscale(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields map[string]string
+		path   string
+	}{
+		{"fast", map[string]string{"type": "fast"}, client.UpscaleFastPath},
+		{"conservative", map[string]string{"type": "conservative", "prompt": "a lighthouse", "creativity": "0.3"}, client.UpscaleConservativePath},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, upstream := newTestServer(t, stabilitytest.ServerConfig{Seed: 42})
+
+			rec, resp := serve(t, s, upscaleRequest(t, tt.fields))
+			if rec.Code != http.StatusOK || !resp.Success {
+				t.Fatalf("status = %d, response = %+v, want a successful 200", rec.Code, resp)
+			}
+
+			upscale := resp.Data
+			if !strings.HasPrefix(upscale.Image, "data:image/png;base64,") {
+				t.Errorf("image = %.40q, want a PNG data URL", upscale.Image)
+			}
+			if upscale.Metadata == nil || upscale.Metadata.Seed != 42 || upscale.Metadata.RequestID != "req-1" {
+				t.Errorf("metadata = %+v, want seed 42 and request ID req-1", upscale.Metadata)
+			}
+			if requests := upstream.Requests(); len(requests) != 1 || requests[0].Path != tt.path {
+				t.Errorf("upstream requests = %+v, want a single request to %s", requests, tt.path)
+			}
+		})
+	}
+}
+
+func TestHandleUpscaleCreative(t *testing.T) {
+	s, _ := newTestServer(t, stabilitytest.ServerConfig{PendingPolls: 2})
+
+	rec, resp := serve(t, s, upscaleRequest(t, map[string]string{"type": "creative", "prompt": "a lighthouse"}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, response = %+v, want 200", rec.Code, resp)
+	}
+	started := resp.Data
+	if started.ID == "" || !started.Pending {
+		t.Fatalf("response = %+v, want a pending job ID", started)
+	}
+
+	// The job is reported pending for two polls, then finished
+	for poll := 1; poll <= 3; poll++ {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/upscale/result/"+started.ID, nil)
+		req.Header.Set("Authorization", "Bearer "+testClientKey)
+		rec, resp := serve(t, s, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("poll %d: status = %d, response = %+v, want 200", poll, rec.Code, resp)
+		}
+
+		result := resp.Data
+		if pending := poll <= 2; result.Pending != pending {
+			t.Fatalf("poll %d: pending = %v, want %v", poll, result.Pending, pending)
+		}
+		if !result.Pending && !strings.HasPrefix(result.Image, "data:image/png;base64,") {
+			t.Errorf("poll %d: image = %.40q, want a PNG data URL", poll, result.Image)
+		}
+	}
+}
+
+func TestHandleUpscaleUpstreamErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		fault      stabilitytest.Fault
+		status     int
+		retryAfter string
+	}{
+		{"rate limited", stabilitytest.RateLimitFault(client.UpscaleFastPath, 3*time.Second), http.StatusTooManyRequests, "3"},
+		{"content policy", stabilitytest.ContentPolicyFault(client.UpscaleFastPath), http.StatusForbidden, ""},
+		{"server error", stabilitytest.ServerErrorFault(client.UpscaleFastPath), http.StatusBadGateway, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, upstream := newTestServer(t, stabilitytest.ServerConfig{})
+			upstream.InjectFault(tt.fault)
+
+			rec, resp := serve(t, s, upscaleRequest(t, map[string]string{"type": "fast"}))
+			if rec.Code != tt.status || resp.Success {
+				t.Fatalf("status = %d, response = %+v, want a failed %d", rec.Code, resp, tt.status)
+			}
+			if got := rec.Header().Get("Retry-After"); got != tt.retryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, tt.retryAfter)
+			}
+			if resp.Metadata == nil || resp.Metadata.RequestID != "req-1" {
+				t.Errorf("metadata = %+v, want the upstream request ID req-1", resp.Metadata)
+			}
+		})
+	}
+}
+
+func TestHandleUpscaleContentFiltered(t *testing.T) {
+	tests := []struct {
+		name          string
+		allowFiltered string
+		status        int
+	}{
+		{"rejected", "", http.StatusUnprocessableEntity},
+		{"allowed", "true", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestServer(t, stabilitytest.ServerConfig{Seed: 9, FinishReason: client.FinishReasonContentFiltered})
+
+			rec, resp := serve(t, s, upscaleRequest(t, map[string]string{"type": "fast", "allow_filtered": tt.allowFiltered}))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, response = %+v, want %d", rec.Code, resp, tt.status)
+			}
+
+			if tt.status == http.StatusUnprocessableEntity {
+				if resp.Success || resp.Code != ErrorCodeContentFiltered {
+					t.Errorf("response = %+v, want a failure with code %s", resp, ErrorCodeContentFiltered)
+				}
+				if resp.Metadata == nil || resp.Metadata.Seed != 9 || resp.Metadata.RequestID != "req-1" {
+					t.Errorf("metadata = %+v, want seed 9 and request ID req-1 to report the filtered result", resp.Metadata)
+				}
+				return
+			}
+			if !resp.Success || !resp.Data.ContentFiltered || !strings.HasPrefix(resp.Data.Image, "data:image/png;base64,") {
+				t.Errorf("response = %+v, want the blurred image flagged as content filtered", resp)
+			}
+		})
+	}
+}
+
+func TestHandleUpscaleResultContentFiltered(t *testing.T) {
+	s, _ := newTestServer(t, stabilitytest.ServerConfig{FinishReason: client.FinishReasonContentFiltered})
+
+	_, started := serve(t, s, upscaleRequest(t, map[string]string{"type": "creative", "prompt": "a lighthouse"}))
+	if started.Data.ID == "" {
+		t.Fatalf("response = %+v, want a job ID", started)
+	}
+
+	for _, tt := range []struct {
+		query  string
+		status int
+	}{
+		{"", http.StatusUnprocessableEntity},
+		{"?allow_filtered=true", http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/upscale/result/"+started.Data.ID+tt.query, nil)
+		req.Header.Set("Authorization", "Bearer "+testClientKey)
+		rec, resp := serve(t, s, req)
+		if rec.Code != tt.status {
+			t.Fatalf("result%s: status = %d, response = %+v, want %d", tt.query, rec.Code, resp, tt.status)
+		}
+		if tt.status == http.StatusOK && !resp.Data.ContentFiltered {
+			t.Errorf("result%s: response = %+v, want the image flagged as content filtered", tt.query, resp)
+		}
+		if tt.status != http.StatusOK && resp.Code != ErrorCodeContentFiltered {
+			t.Errorf("result%s: code = %q, want %s", tt.query, resp.Code, ErrorCodeContentFiltered)
+		}
+	}
+}
+
+func TestHandleUpscaleRejectsInvalidRequests(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields map[string]string
+		want   []string
+	}{
+		{"creative creativity above the maximum", map[string]string{"type": "creative", "prompt": "a lighthouse", "creativity": "0.9"}, []string{"creativity"}},
+		{"conservative creativity below the minimum", map[string]string{"type": "conservative", "prompt": "a lighthouse", "creativity": "0.1"}, []string{"creativity"}},
+		{"negative prompt too long", map[string]string{"type": "creative", "prompt": "a lighthouse",
+			"negative_prompt": strings.Repeat("a", client.MaxPromptLength+1)}, []string{"negative_prompt"}},
+		// Checked by the handler before the request is validated, so no fields are listed
+		{"conservative without a prompt", map[string]string{"type": "conservative"}, nil},
+		{"unknown type", map[string]string{"type": "huge"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, upstream := newTestServer(t, stabilitytest.ServerConfig{})
+
+			rec := httptest.NewRecorder()
+			s.Router.ServeHTTP(rec, upscaleRequest(t, tt.fields))
+
+			var resp struct {
+				Success bool `json:"success"`
+				Errors  []struct {
+					Field string `json:"field"`
+				} `json:"errors"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to decode response %q: %v", rec.Body.String(), err)
+			}
+			if rec.Code != http.StatusBadRequest || resp.Success {
+				t.Fatalf("status = %d, response = %s, want a failed 400", rec.Code, rec.Body)
+			}
+
+			var got []string
+			for _, fieldErr := range resp.Errors {
+				got = append(got, fieldErr.Field)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("invalid fields = %v, want %v", got, tt.want)
+			}
+
+			// Invalid requests aren't sent to Stability AI
+			if requests := upstream.Requests(); len(requests) != 0 {
+				t.Errorf("upstream received %d requests, want none", len(requests))
+			}
+		})
+	}
+}
+
+func TestHealthCheckReportsBalancePerKey(t *testing.T) {
+	// Each key belongs to its own account; the third one was revoked
+	credits := map[string]string{"Bearer sk-a": `{"credits":12.5}`, "Bearer sk-b": `{"credits":30}`}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		balance, ok := credits[r.Header.Get("Authorization")]
+		if r.URL.Path != client.BalancePath || !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"name":"unauthorized","errors":["invalid API key"]}`))
+			return
+		}
+		w.Write([]byte(balance))
+	}))
+	defer upstream.Close()
+
+	stClient := client.NewClient("").WithBaseURL(upstream.URL).WithKeys([]string{"sk-a", "sk-b", "sk-c"}, client.KeyPoolConfig{})
+	s := New(stClient, logger.New(logger.Error), "", 0, "", testClientKey, nil, nil, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/health", nil)
+	req.Header.Set("Authorization", "Bearer "+testClientKey)
+	rec := httptest.NewRecorder()
+	s.Router.ServeHTTP(rec, req)
+
+	var resp struct {
+		Data struct {
+			Credits      *float64 `json:"credits"`
+			BalanceError string   `json:"balance_error"`
+			Keys         []struct {
+				Name         string   `json:"name"`
+				Credits      *float64 `json:"credits"`
+				BalanceError string   `json:"balance_error"`
+			} `json:"keys"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response %q: %v", rec.Body.String(), err)
+	}
+
+	if resp.Data.Credits == nil || *resp.Data.Credits != 42.5 || resp.Data.BalanceError != "" {
+		t.Errorf("credits = %v (error %q), want the 42.5 credits of the keys whose balance was fetched",
+			resp.Data.Credits, resp.Data.BalanceError)
+	}
+	if len(resp.Data.Keys) != 3 {
+		t.Fatalf("keys = %+v, want 3", resp.Data.Keys)
+	}
+	for i, want := range []float64{12.5, 30} {
+		key := resp.Data.Keys[i]
+		if key.Credits == nil || *key.Credits != want {
+			t.Errorf("%s: credits = %v, want %v", key.Name, key.Credits, want)
+		}
+	}
+	if key := resp.Data.Keys[2]; key.Credits != nil || key.BalanceError == "" {
+		t.Errorf("%s: credits = %v, error = %q, want the balance error of the revoked key", key.Name, key.Credits, key.BalanceError)
+	}
+}
+
+func TestHealthCheckSharesBalanceFetch(t *testing.T) {
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"credits":7}`))
+	}))
+	defer upstream.Close()
+
+	s := New(client.NewClient("sk-test").WithBaseURL(upstream.URL), logger.New(logger.Error), "", 0, "", testClientKey, nil, nil, nil)
+	health := func(ctx context.Context) map[string]interface{} {
+		req := httptest.NewRequest(http.MethodGet, "/health", nil).WithContext(ctx)
+		req.Header.Set("Authorization", "Bearer "+testClientKey)
+		rec := httptest.NewRecorder()
+		s.Router.ServeHTTP(rec, req)
+
+		var resp struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Errorf("failed to decode response %q: %v", rec.Body.String(), err)
+		}
+		return resp.Data
+	}
+
+	// Health checks waiting on the fetch each give up at their own deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if data := health(ctx); data["balance_error"] == nil {
+		t.Errorf("health check past its deadline = %v, want a balance error", data)
+	}
+
+	// Concurrent health checks share the fetch still in flight
+	var wg sync.WaitGroup
+	results := make([]map[string]interface{}, 5)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = health(context.Background())
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	for i, data := range results {
+		if data["credits"] != 7.0 {
+			t.Errorf("health check %d = %v, want 7 credits", i+1, data)
+		}
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("upstream received %d balance requests, want 1", n)
+	}
+
+	// The fetched balance is cached
+	health(context.Background())
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("upstream received %d balance requests after the cached check, want 1", n)
+	}
+}
@@ -0,0 +1,131 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// Account API endpoints
+const (
+	AccountPath     = "/v1/user/account"
+	BalancePath     = "/v1/user/balance"
+	EnginesListPath = "/v1/engines/list"
+)
+
+// Organization represents an organization the account belongs to
+type Organization struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Role      string `json:"role"`
+	IsDefault bool   `json:"is_default"`
+}
+
+// Account represents the account associated with the API key
+type Account struct {
+	ID             string         `json:"id"`
+	Email          string         `json:"email"`
+	ProfilePicture string         `json:"profile_picture"`
+	Organizations  []Organization `json:"organizations"`
+}
+
+// Balance represents the credit balance of the account
+type Balance struct {
+	Credits float64 `json:"credits"`
+}
+
+// KeyBalance is the credit balance of one key of a KeyPool, identified like its KeyStats
+type KeyBalance struct {
+	// Position of the key in the pool (e.g., "key-1")
+	Name string
+	// Short SHA-256 fingerprint of the key
+	Fingerprint string
+	// Remaining credits of the key's account, unless Err is set
+	Credits float64
+	// Error fetching the balance, if any
+	Err error
+}
+
+// Engine represents an engine available to the account
+type Engine struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Type        string `json:"type"`
+}
+
+// GetAccount returns the account associated with the API key
+func (c *Client) GetAccount(ctx context.Context) (*Account, error) {
+	var account Account
+	if err := c.getJSON(ctx, AccountPath, "account", &account); err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// GetBalance returns the remaining credit balance of the account
+func (c *Client) GetBalance(ctx context.Context) (*Balance, error) {
+	var balance Balance
+	if err := c.getJSON(ctx, BalancePath, "balance", &balance); err != nil {
+		return nil, err
+	}
+	return &balance, nil
+}
+
+// GetKeyBalances returns the credit balance of every key of the client's key pool, in pool order.
+// Keys may belong to different accounts, so each key is asked for its own balance. Without a key pool it returns nil.
+func (c *Client) GetKeyBalances(ctx context.Context) []KeyBalance {
+	if c.Keys == nil {
+		return nil
+	}
+
+	stats := c.Keys.Stats()
+	balances := make([]KeyBalance, len(stats))
+	var wg sync.WaitGroup
+	for i, s := range stats {
+		balances[i] = KeyBalance{Name: s.Name, Fingerprint: s.Fingerprint}
+
+		// Send with this key alone, bypassing the pool's key selection and failover
+		single := *c
+		single.APIKey = c.Keys.key(i)
+		single.Keys = nil
+
+		wg.Add(1)
+		go func(b *KeyBalance) {
+			defer wg.Done()
+			balance, err := single.GetBalance(ctx)
+			if err != nil {
+				b.Err = err
+				return
+			}
+			b.Credits = balance.Credits
+		}(&balances[i])
+	}
+	wg.Wait()
+
+	return balances
+}
+
+// ListEngines returns the engines available to the account
+func (c *Client) ListEngines(ctx context.Context) ([]Engine, error) {
+	var engines []Engine
+	if err := c.getJSON(ctx, EnginesListPath, "engines", &engines); err != nil {
+		return nil, err
+	}
+	return engines, nil
+}
+
+// getJSON sends a GET request and decodes the JSON response into v
+func (c *Client) getJSON(ctx context.Context, path string, operation string, v interface{}) error {
+	resp, err := c.send(ctx, Operation{
+		Method: http.MethodGet,
+		Path:   path,
+		Name:   operation,
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return decodeJSONResponse(resp, operation, v)
+}
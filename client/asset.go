@@ -0,0 +1,37 @@
+package client
+
+import (
+	"fmt"
+	"os"
+)
+
+// SaveAsset saves a binary asset, such as an image, 3D model or video, to a file without re-encoding it
+func SaveAsset(data []byte, outputPath string) error {
+	if len(data) == 0 {
+		return fmt.Errorf("asset is empty")
+	}
+
+	if err := os.WriteFile(outputPath, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write asset file: %w", err)
+	}
+
+	return nil
+}
+
+// ExtensionForMimeType returns the file extension (including the dot) for a mime type, or an empty string if unknown
+func ExtensionForMimeType(mimeType string) string {
+	switch mimeType {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/png":
+		return ".png"
+	case "image/webp":
+		return ".webp"
+	case "video/mp4":
+		return ".mp4"
+	case MimeTypeGLB:
+		return ".glb"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,194 @@
+package client
+
+import (
+	"context"
+	"strconv"
+)
+
+// Background API endpoints
+const (
+	RemoveBackgroundPath            = "/v2beta/stable-image/edit/remove-background"
+	ReplaceBackgroundAndRelightPath = "/v2beta/stable-image/edit/replace-background-and-relight"
+)
+
+// LightSourceDirection defines the available light source directions for relighting
+type LightSourceDirection string
+
+const (
+	LightSourceLeft  LightSourceDirection = "left"
+	LightSourceRight LightSourceDirection = "right"
+	LightSourceAbove LightSourceDirection = "above"
+	LightSourceBelow LightSourceDirection = "below"
+)
+
+// RemoveBackgroundRequest represents the parameters for a remove background request
+type RemoveBackgroundRequest struct {
+	// The image to remove the background from (binary data)
+	Image []byte
+	// The filename of the image
+	Filename string
+	// Output format (png, webp)
+	OutputFormat OutputFormat
+	// Whether to return image as base64 JSON instead of binary
+	ReturnAsJSON bool
+}
+
+// ReplaceBackgroundAndRelightRequest represents the parameters for a replace background and relight request
+type ReplaceBackgroundAndRelightRequest struct {
+	// The image containing the subject to keep (binary data)
+	SubjectImage []byte
+	// The filename of the subject image
+	SubjectFilename string
+	// Optional image to use as a reference for the new background
+	BackgroundReference []byte
+	// The filename of the background reference
+	BackgroundReferenceFilename string
+	// Description of the new background (required unless a background reference is given)
+	BackgroundPrompt string
+	// Optional description of the subject
+	ForegroundPrompt string
+	// Optional negative prompt
+	NegativePrompt string
+	// How much of the original subject to preserve (0-1, 0 uses the API default)
+	PreserveOriginalSubject float64
+	// Depth matching of the original background (0-1, 0 uses the API default)
+	OriginalBackgroundDepth float64
+	// Whether to keep the original background and only relight the subject
+	KeepOriginalBackground bool
+	// Optional direction of the light source
+	LightSourceDirection LightSourceDirection
+	// Optional image to use as a reference for the lighting
+	LightReference []byte
+	// The filename of the light reference
+	LightReferenceFilename string
+	// Strength of the light source (0-1, requires a light direction or reference)
+	LightSourceStrength float64
+	// Optional seed value
+	Seed int64
+	// Output format (jpeg, png, webp)
+	OutputFormat OutputFormat
+}
+
+// Validate checks the request against the documented limits of the remove-background endpoint
+func (r RemoveBackgroundRequest) Validate() error {
+	var v validator
+	v.image("image", r.Image, RemoveBackgroundImageLimits, true)
+	// Remove background cannot produce jpeg, since it needs an alpha channel
+	v.outputFormat(r.OutputFormat, OutputFormatPNG, OutputFormatWEBP)
+	return v.err("remove background")
+}
+
+// RemoveBackground removes the background from an image
+func (c *Client) RemoveBackground(ctx context.Context, request RemoveBackgroundRequest) (*EditResponse, error) {
+	if err := request.Validate(); err != nil {
+		return nil, err
+	}
+
+	fields := map[string]string{}
+	if request.OutputFormat != "" {
+		fields["output_format"] = string(request.OutputFormat)
+	}
+
+	image := EditImage{Image: request.Image, Filename: request.Filename}
+	return c.edit(ctx, RemoveBackgroundPath, image, fields, request.ReturnAsJSON)
+}
+
+// ratios returns the fields of the request that range from 0 to 1
+func (r ReplaceBackgroundAndRelightRequest) ratios() []struct {
+	name  string
+	value float64
+} {
+	return []struct {
+		name  string
+		value float64
+	}{
+		{"preserve_original_subject", r.PreserveOriginalSubject},
+		{"original_background_depth", r.OriginalBackgroundDepth},
+		{"light_source_strength", r.LightSourceStrength},
+	}
+}
+
+// Validate checks the request against the documented limits of the replace-background-and-relight endpoint
+func (r ReplaceBackgroundAndRelightRequest) Validate() error {
+	var v validator
+	v.image("subject_image", r.SubjectImage, EditImageLimits, true)
+	v.image("background_reference", r.BackgroundReference, EditImageLimits, false)
+	v.image("light_reference", r.LightReference, EditImageLimits, false)
+	if r.BackgroundPrompt == "" && len(r.BackgroundReference) == 0 {
+		v.add("background_prompt", "background prompt or background reference is required")
+	}
+	v.prompt("background_prompt", r.BackgroundPrompt, false)
+	v.prompt("foreground_prompt", r.ForegroundPrompt, false)
+	v.prompt("negative_prompt", r.NegativePrompt, false)
+
+	for _, ratio := range r.ratios() {
+		if ratio.value < 0 || ratio.value > 1 {
+			v.add(ratio.name, "must be between 0 and 1")
+		}
+	}
+	if r.LightSourceStrength > 0 && r.LightSourceDirection == "" && len(r.LightReference) == 0 {
+		v.add("light_source_strength", "requires a light source direction or light reference")
+	}
+
+	v.seed(r.Seed)
+	v.outputFormat(r.OutputFormat)
+	return v.err("replace background and relight")
+}
+
+// ReplaceBackgroundAndRelight replaces the background of an image and relights the subject.
+// The job runs asynchronously; poll for the result with PollResult using the returned ID.
+func (c *Client) ReplaceBackgroundAndRelight(ctx context.Context, request ReplaceBackgroundAndRelightRequest) (*AsyncResponse, error) {
+	if err := request.Validate(); err != nil {
+		return nil, err
+	}
+
+	fields := map[string]string{}
+	for _, ratio := range request.ratios() {
+		if ratio.value > 0 {
+			fields[ratio.name] = strconv.FormatFloat(ratio.value, 'f', 2, 64)
+		}
+	}
+
+	if request.BackgroundPrompt != "" {
+		fields["background_prompt"] = request.BackgroundPrompt
+	}
+	if request.ForegroundPrompt != "" {
+		fields["foreground_prompt"] = request.ForegroundPrompt
+	}
+	if request.NegativePrompt != "" {
+		fields["negative_prompt"] = request.NegativePrompt
+	}
+	if request.KeepOriginalBackground {
+		fields["keep_original_background"] = "true"
+	}
+	if request.LightSourceDirection != "" {
+		fields["light_source_direction"] = string(request.LightSourceDirection)
+	}
+	addCommonEditFields(fields, request.Seed, request.OutputFormat, "")
+
+	files := []FormFile{{Field: "subject_image", Filename: request.SubjectFilename, Data: request.SubjectImage}}
+	if len(request.BackgroundReference) > 0 {
+		files = append(files, FormFile{Field: "background_reference", Filename: request.BackgroundReferenceFilename, Data: request.BackgroundReference})
+	}
+	if len(request.LightReference) > 0 {
+		files = append(files, FormFile{Field: "light_reference", Filename: request.LightReferenceFilename, Data: request.LightReference})
+	}
+
+	resp, err := c.send(ctx, Operation{
+		Path:   ReplaceBackgroundAndRelightPath,
+		Fields: fields,
+		Files:  files,
+		Name:   "relight",
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var asyncResp AsyncResponse
+	if err := decodeJSONResponse(resp, "relight", &asyncResp); err != nil {
+		return nil, err
+	}
+
+	return &asyncResp, nil
+}
@@ -0,0 +1,148 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultBatchConcurrency is the number of upscales a batch runs at once unless configured otherwise
+const DefaultBatchConcurrency = 4
+
+// UpscaleCredits is the number of credits Stability AI charges for a successful upscale of each type.
+// Batch summaries use it to report the credits spent; update it if the published pricing changes.
+var UpscaleCredits = map[UpscaleType]float64{
+	UpscaleTypeFast:         2,
+	UpscaleTypeConservative: 40,
+	UpscaleTypeCreative:     60,
+}
+
+// BatchOptions configures BatchUpscale. Zero values are replaced by the defaults.
+type BatchOptions struct {
+	// Maximum number of upscales running at once (defaults to DefaultBatchConcurrency)
+	Concurrency int
+	// How creative upscales are polled until they finish
+	Wait WaitOptions
+}
+
+// BatchResult is the outcome of one request of a batch
+type BatchResult struct {
+	// Position of the request in the batch
+	Index int
+	// The upscaled image; for creative upscales, the finished result
+	Response *UpscaleResponse
+	// Why the request failed, or nil if it succeeded
+	Err error
+	// How long the request took, including waiting for creative results
+	Duration time.Duration
+	// Credits charged for the request according to UpscaleCredits. Failed requests are free, except creative
+	// upscales, which are charged once the job is accepted even if waiting for the result fails.
+	Credits float64
+}
+
+// BatchSummary describes a finished batch
+type BatchSummary struct {
+	// Number of requests in the batch
+	Total int
+	// Number of requests that succeeded and failed
+	Succeeded int
+	Failed    int
+	// Credits spent on the batch according to UpscaleCredits, including creative upscales that failed after they were accepted
+	Credits float64
+	// Time from the start of the batch until the last request finished
+	Duration time.Duration
+}
+
+// Batch is a running batch of upscales started by BatchUpscale
+type Batch struct {
+	results chan BatchResult
+	done    chan struct{}
+	summary BatchSummary
+}
+
+// Results returns a channel delivering the result of every request as it finishes, in completion order.
+// The channel is closed once the batch is finished. It is buffered for the whole batch, so it is safe not to read it.
+func (b *Batch) Results() <-chan BatchResult {
+	return b.results
+}
+
+// Wait blocks until every request of the batch has finished and returns the summary
+func (b *Batch) Wait() BatchSummary {
+	<-b.done
+	return b.summary
+}
+
+// BatchUpscale upscales many images with a bounded number of concurrent requests and returns immediately.
+// Every request goes through the client's middleware, so the workers share its rate limiter, and creative
+// upscales are polled until they finish. Cancelling the context fails the requests that have not finished.
+func (c *Client) BatchUpscale(ctx context.Context, requests []UpscaleRequest, opts BatchOptions) *Batch {
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultBatchConcurrency
+	}
+	if concurrency > len(requests) {
+		concurrency = len(requests)
+	}
+
+	batch := &Batch{
+		results: make(chan BatchResult, len(requests)),
+		done:    make(chan struct{}),
+		summary: BatchSummary{Total: len(requests)},
+	}
+
+	start := time.Now()
+	indexes := make(chan int)
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+
+	for worker := 0; worker < concurrency; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				result := c.batchUpscale(ctx, i, requests[i], opts.Wait)
+
+				mutex.Lock()
+				if result.Err != nil {
+					batch.summary.Failed++
+				} else {
+					batch.summary.Succeeded++
+				}
+				batch.summary.Credits += result.Credits
+				mutex.Unlock()
+
+				batch.results <- result
+			}
+		}()
+	}
+
+	go func() {
+		for i := range requests {
+			indexes <- i
+		}
+		close(indexes)
+
+		wg.Wait()
+		batch.summary.Duration = time.Since(start)
+		close(batch.results)
+		close(batch.done)
+	}()
+
+	return batch
+}
+
+// batchUpscale runs one request of a batch, waiting for creative results
+func (c *Client) batchUpscale(ctx context.Context, index int, request UpscaleRequest, wait WaitOptions) BatchResult {
+	start := time.Now()
+	result := BatchResult{Index: index}
+
+	// Don't start requests once the batch has been cancelled
+	if err := ctx.Err(); err != nil {
+		result.Err = err
+		return result
+	}
+
+	result.Response, result.Credits, result.Err = c.upscaleAndWait(ctx, request, wait)
+	result.Duration = time.Since(start)
+	return result
+}
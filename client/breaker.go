@@ -0,0 +1,335 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	apierrors "github.com/marcusziade/stability-go/errors"
+	"github.com/marcusziade/stability-go/telemetry"
+)
+
+// Default circuit breaker settings
+const (
+	DefaultCircuitWindow           = time.Minute
+	DefaultCircuitMinRequests      = 10
+	DefaultCircuitFailureRate      = 0.5
+	DefaultCircuitCooldown         = 30 * time.Second
+	DefaultCircuitHalfOpenRequests = 1
+)
+
+// circuitBuckets is the number of buckets the failure-rate window is divided into
+const circuitBuckets = 10
+
+// CircuitState is the state of a circuit breaker
+type CircuitState int
+
+const (
+	// CircuitClosed lets requests through and tracks their failure rate
+	CircuitClosed CircuitState = iota
+	// CircuitOpen rejects requests until the cooldown has passed
+	CircuitOpen
+	// CircuitHalfOpen lets a limited number of probe requests through to test whether the upstream recovered
+	CircuitHalfOpen
+)
+
+// String returns the name of the state
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// CircuitBreakerConfig configures a circuit breaker. Zero values are replaced by the defaults.
+type CircuitBreakerConfig struct {
+	// Length of the sliding window the failure rate is measured over
+	Window time.Duration
+	// Minimum number of requests in the window before the breaker can trip
+	MinRequests int
+	// Failure rate (0-1) at which the breaker trips
+	FailureRate float64
+	// How long the breaker stays open before letting probe requests through
+	Cooldown time.Duration
+	// Number of probe requests that must succeed in the half-open state to close the breaker
+	HalfOpenRequests int
+	// Optional function that decides whether an attempt counts as a failure (defaults to IsUpstreamFailure)
+	IsFailure func(resp *http.Response, err error) bool
+	// Optional callback invoked whenever the state changes. It runs with the breaker locked,
+	// so it must not call the breaker's methods.
+	OnStateChange func(from, to CircuitState)
+}
+
+// withDefaults returns the config with zero values replaced by the defaults
+func (c CircuitBreakerConfig) withDefaults() CircuitBreakerConfig {
+	if c.Window <= 0 {
+		c.Window = DefaultCircuitWindow
+	}
+	if c.MinRequests <= 0 {
+		c.MinRequests = DefaultCircuitMinRequests
+	}
+	if c.FailureRate <= 0 || c.FailureRate > 1 {
+		c.FailureRate = DefaultCircuitFailureRate
+	}
+	if c.Cooldown <= 0 {
+		c.Cooldown = DefaultCircuitCooldown
+	}
+	if c.HalfOpenRequests <= 0 {
+		c.HalfOpenRequests = DefaultCircuitHalfOpenRequests
+	}
+	if c.IsFailure == nil {
+		c.IsFailure = IsUpstreamFailure
+	}
+	return c
+}
+
+// IsUpstreamFailure reports whether an attempt indicates that the upstream is unhealthy:
+// a transport error or timeout, or a 5xx response. Requests cancelled by the caller are not failures.
+func IsUpstreamFailure(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled)
+	}
+	return resp.StatusCode >= 500
+}
+
+// CircuitStats is a snapshot of a circuit breaker
+type CircuitStats struct {
+	// The current state
+	State CircuitState
+	// Requests and failures recorded in the current window
+	Requests int
+	Failures int
+	// When the state last changed
+	Since time.Time
+	// How long until an open breaker lets probe requests through
+	RetryAfter time.Duration
+}
+
+// circuitBucket counts the outcomes of requests in one slice of the window
+type circuitBucket struct {
+	start    time.Time
+	requests int
+	failures int
+}
+
+// CircuitBreaker tracks the health of an upstream and stops sending it requests while it is failing.
+// Use CircuitBreak to add it to a client's middleware chain; the same breaker can be shared by several clients.
+type CircuitBreaker struct {
+	config CircuitBreakerConfig
+
+	mutex      sync.Mutex
+	state      CircuitState
+	since      time.Time
+	generation uint64
+	buckets    [circuitBuckets]circuitBucket
+	probes     int
+	successes  int
+}
+
+// NewCircuitBreaker creates a new, closed circuit breaker
+func NewCircuitBreaker(config CircuitBreakerConfig) *CircuitBreaker {
+	return &CircuitBreaker{
+		config: config.withDefaults(),
+		state:  CircuitClosed,
+		since:  time.Now(),
+	}
+}
+
+// State returns the current state of the breaker
+func (b *CircuitBreaker) State() CircuitState {
+	return b.Stats().State
+}
+
+// Stats returns a snapshot of the breaker
+func (b *CircuitBreaker) Stats() CircuitStats {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+	b.advance(now)
+
+	stats := CircuitStats{
+		State: b.state,
+		Since: b.since,
+	}
+	for _, bucket := range b.buckets {
+		if now.Sub(bucket.start) < b.config.Window {
+			stats.Requests += bucket.requests
+			stats.Failures += bucket.failures
+		}
+	}
+	if b.state == CircuitOpen {
+		stats.RetryAfter = b.since.Add(b.config.Cooldown).Sub(now)
+	}
+	return stats
+}
+
+// allow reports whether a request may be sent, returning the generation its outcome must be recorded against
+func (b *CircuitBreaker) allow() (uint64, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+	b.advance(now)
+
+	switch b.state {
+	case CircuitOpen:
+		return 0, &apierrors.CircuitOpenError{RetryAfter: b.since.Add(b.config.Cooldown).Sub(now)}
+	case CircuitHalfOpen:
+		if b.probes >= b.config.HalfOpenRequests {
+			return 0, &apierrors.CircuitOpenError{}
+		}
+		b.probes++
+	}
+	return b.generation, nil
+}
+
+// record records the outcome of a request allowed in the given generation
+func (b *CircuitBreaker) record(generation uint64, failed bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	// Ignore requests that started before the last state change
+	if generation != b.generation {
+		return
+	}
+
+	now := time.Now()
+	switch b.state {
+	case CircuitClosed:
+		bucket := b.bucket(now)
+		bucket.requests++
+		if failed {
+			bucket.failures++
+		}
+
+		requests, failures := 0, 0
+		for _, bucket := range b.buckets {
+			if now.Sub(bucket.start) < b.config.Window {
+				requests += bucket.requests
+				failures += bucket.failures
+			}
+		}
+		if requests >= b.config.MinRequests && float64(failures) >= b.config.FailureRate*float64(requests) {
+			b.setState(CircuitOpen, now)
+		}
+	case CircuitHalfOpen:
+		if failed {
+			b.setState(CircuitOpen, now)
+			return
+		}
+		b.successes++
+		if b.successes >= b.config.HalfOpenRequests {
+			b.setState(CircuitClosed, now)
+		}
+	}
+}
+
+// release gives back the probe slot of a request allowed in the given generation without recording an outcome,
+// for requests the caller cancelled before the upstream answered
+func (b *CircuitBreaker) release(generation uint64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if generation == b.generation && b.state == CircuitHalfOpen && b.probes > 0 {
+		b.probes--
+	}
+}
+
+// advance moves an open breaker to half-open once the cooldown has passed. Must be called with the mutex held.
+func (b *CircuitBreaker) advance(now time.Time) {
+	if b.state == CircuitOpen && now.Sub(b.since) >= b.config.Cooldown {
+		b.setState(CircuitHalfOpen, now)
+	}
+}
+
+// bucket returns the bucket for the given time, resetting it if it belongs to an earlier window.
+// Must be called with the mutex held.
+func (b *CircuitBreaker) bucket(now time.Time) *circuitBucket {
+	width := b.config.Window / circuitBuckets
+	if width <= 0 {
+		width = 1
+	}
+
+	start := now.Truncate(width)
+	bucket := &b.buckets[(start.UnixNano()/int64(width))%circuitBuckets]
+	if !bucket.start.Equal(start) {
+		*bucket = circuitBucket{start: start}
+	}
+	return bucket
+}
+
+// setState switches to a new state and starts a new generation. Must be called with the mutex held.
+func (b *CircuitBreaker) setState(state CircuitState, now time.Time) {
+	from := b.state
+	b.state = state
+	b.since = now
+	b.generation++
+	b.probes = 0
+	b.successes = 0
+	if state == CircuitClosed {
+		b.buckets = [circuitBuckets]circuitBucket{}
+	}
+
+	if b.config.OnStateChange != nil {
+		b.config.OnStateChange(from, state)
+	}
+}
+
+// CircuitBreakerMiddleware is a middleware that fails fast while its circuit breaker is open
+type CircuitBreakerMiddleware struct {
+	breaker *CircuitBreaker
+	next    http.RoundTripper
+}
+
+// NewCircuitBreakerMiddleware creates a new circuit breaker middleware
+func NewCircuitBreakerMiddleware(breaker *CircuitBreaker, next http.RoundTripper) *CircuitBreakerMiddleware {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return &CircuitBreakerMiddleware{
+		breaker: breaker,
+		next:    next,
+	}
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+// While the breaker is open it returns an *errors.CircuitOpenError without sending the request.
+func (m *CircuitBreakerMiddleware) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := telemetry.StartSpan(req.Context(), "stability.middleware.circuit_breaker",
+		telemetry.String(telemetry.AttrCircuitState, m.breaker.State().String()))
+
+	generation, err := m.breaker.allow()
+	if err != nil {
+		closeBody(req)
+		telemetry.Add(ctx, telemetry.MetricCircuitRejected, 1)
+		endSpan(span, nil, err)
+		return nil, err
+	}
+
+	resp, err := m.next.RoundTrip(req.WithContext(ctx))
+	if err != nil && errors.Is(err, context.Canceled) {
+		// A cancelled request says nothing about the upstream, so it must not close a half-open breaker
+		m.breaker.release(generation)
+	} else {
+		m.breaker.record(generation, m.breaker.config.IsFailure(resp, err))
+	}
+	endSpan(span, resp, err)
+	return resp, err
+}
+
+// CircuitBreak returns a middleware that guards requests with the given circuit breaker
+func CircuitBreak(breaker *CircuitBreaker) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return NewCircuitBreakerMiddleware(breaker, next)
+	}
+}
@@ -0,0 +1,210 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	apierrors "github.com/marcusziade/stability-go/errors"
+)
+
+// roundTripperFunc adapts a function to http.RoundTripper
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// statusTransport answers every request with the given status, counting the requests it receives
+func statusTransport(status *int, calls *int) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		*calls++
+		return &http.Response{StatusCode: *status, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("")), Request: req}, nil
+	})
+}
+
+// expireCooldown moves the breaker's last state change back by the cooldown, as if it had passed
+func expireCooldown(b *CircuitBreaker) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.since = b.since.Add(-b.config.Cooldown)
+}
+
+// newTestBreaker returns a breaker that trips at 50% failures over 4 requests and closes after 2 successful probes,
+// recording its state changes
+func newTestBreaker(transitions *[]string) *CircuitBreaker {
+	return NewCircuitBreaker(CircuitBreakerConfig{
+		MinRequests:      4,
+		FailureRate:      0.5,
+		Cooldown:         time.Hour,
+		HalfOpenRequests: 2,
+		OnStateChange: func(from, to CircuitState) {
+			*transitions = append(*transitions, from.String()+"->"+to.String())
+		},
+	})
+}
+
+// sendThrough sends a request through the middleware
+func sendThrough(t *testing.T, m http.RoundTripper) error {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, "https://api.stability.ai/v1/user/account", nil)
+	_, err := m.RoundTrip(req)
+	return err
+}
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	var transitions []string
+	breaker := newTestBreaker(&transitions)
+	status, calls := http.StatusOK, 0
+	m := NewCircuitBreakerMiddleware(breaker, statusTransport(&status, &calls))
+
+	// Two successes and two failures reach the failure rate
+	for _, status = range []int{http.StatusOK, http.StatusOK, http.StatusInternalServerError, http.StatusBadGateway} {
+		if err := sendThrough(t, m); err != nil {
+			t.Fatalf("request while closed failed: %v", err)
+		}
+	}
+	if state := breaker.State(); state != CircuitOpen {
+		t.Fatalf("state after 50%% failures = %s, want open", state)
+	}
+
+	// An open breaker fails fast without sending the request
+	err := sendThrough(t, m)
+	var circuitErr *apierrors.CircuitOpenError
+	if !errors.As(err, &circuitErr) || circuitErr.RetryAfter <= 0 || circuitErr.RetryAfter > time.Hour {
+		t.Fatalf("request while open = %v, want a CircuitOpenError with the remaining cooldown", err)
+	}
+	if calls != 4 {
+		t.Errorf("transport received %d requests, want 4", calls)
+	}
+
+	// After the cooldown, two successful probes close it
+	expireCooldown(breaker)
+	if state := breaker.State(); state != CircuitHalfOpen {
+		t.Fatalf("state after the cooldown = %s, want half-open", state)
+	}
+	status = http.StatusOK
+	for probe := 1; probe <= 2; probe++ {
+		if err := sendThrough(t, m); err != nil {
+			t.Fatalf("probe %d failed: %v", probe, err)
+		}
+	}
+	if state := breaker.State(); state != CircuitClosed {
+		t.Fatalf("state after two successful probes = %s, want closed", state)
+	}
+
+	want := []string{"closed->open", "open->half-open", "half-open->closed"}
+	if strings.Join(transitions, ",") != strings.Join(want, ",") {
+		t.Errorf("transitions = %v, want %v", transitions, want)
+	}
+	if stats := breaker.Stats(); stats.Requests != 0 || stats.Failures != 0 {
+		t.Errorf("stats after closing = %+v, want an empty window", stats)
+	}
+}
+
+func TestCircuitBreakerClientErrorsAreNotFailures(t *testing.T) {
+	var transitions []string
+	breaker := newTestBreaker(&transitions)
+	status, calls := http.StatusTooManyRequests, 0
+	m := NewCircuitBreakerMiddleware(breaker, statusTransport(&status, &calls))
+
+	for i := 0; i < 10; i++ {
+		sendThrough(t, m)
+	}
+	if state := breaker.State(); state != CircuitClosed {
+		t.Errorf("state after 10 rate limited requests = %s, want closed", state)
+	}
+}
+
+func TestCircuitBreakerProbeFailure(t *testing.T) {
+	var transitions []string
+	breaker := newTestBreaker(&transitions)
+	status, calls := http.StatusInternalServerError, 0
+	m := NewCircuitBreakerMiddleware(breaker, statusTransport(&status, &calls))
+
+	for i := 0; i < 4; i++ {
+		sendThrough(t, m)
+	}
+	expireCooldown(breaker)
+
+	// A failed probe reopens the breaker for another cooldown
+	if err := sendThrough(t, m); err != nil {
+		t.Fatalf("probe failed to send: %v", err)
+	}
+	stats := breaker.Stats()
+	if stats.State != CircuitOpen || stats.RetryAfter <= 59*time.Minute {
+		t.Fatalf("stats after a failed probe = %+v, want open for a fresh cooldown", stats)
+	}
+
+	want := []string{"closed->open", "open->half-open", "half-open->open"}
+	if strings.Join(transitions, ",") != strings.Join(want, ",") {
+		t.Errorf("transitions = %v, want %v", transitions, want)
+	}
+}
+
+func TestCircuitBreakerProbeLimit(t *testing.T) {
+	var transitions []string
+	breaker := newTestBreaker(&transitions)
+	breaker.mutex.Lock()
+	breaker.setState(CircuitHalfOpen, time.Now())
+	breaker.mutex.Unlock()
+
+	// Only HalfOpenRequests probes are in flight at a time
+	for probe := 1; probe <= 2; probe++ {
+		if _, err := breaker.allow(); err != nil {
+			t.Fatalf("probe %d was rejected: %v", probe, err)
+		}
+	}
+	if _, err := breaker.allow(); !apierrors.IsCircuitOpen(err) {
+		t.Errorf("third probe = %v, want a CircuitOpenError", err)
+	}
+}
+
+func TestCircuitBreakerCancelledProbe(t *testing.T) {
+	var transitions []string
+	breaker := newTestBreaker(&transitions)
+	breaker.mutex.Lock()
+	breaker.setState(CircuitHalfOpen, time.Now())
+	breaker.mutex.Unlock()
+
+	cancelled := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, context.Canceled
+	})
+	m := NewCircuitBreakerMiddleware(breaker, cancelled)
+
+	// Cancelled probes neither count as successes nor keep their slot
+	for i := 0; i < 3; i++ {
+		if err := sendThrough(t, m); !errors.Is(err, context.Canceled) {
+			t.Fatalf("cancelled probe = %v, want context.Canceled", err)
+		}
+	}
+
+	breaker.mutex.Lock()
+	probes, successes := breaker.probes, breaker.successes
+	breaker.mutex.Unlock()
+	if state := breaker.State(); state != CircuitHalfOpen || probes != 0 || successes != 0 {
+		t.Errorf("after cancelled probes: state = %s, probes = %d, successes = %d, want half-open with no probes", state, probes, successes)
+	}
+}
+
+func TestCircuitBreakerIgnoresStaleGenerations(t *testing.T) {
+	var transitions []string
+	breaker := newTestBreaker(&transitions)
+
+	// A request sent while closed finishes after the breaker tripped and reached half-open
+	generation, _ := breaker.allow()
+	breaker.mutex.Lock()
+	breaker.setState(CircuitOpen, time.Now())
+	breaker.setState(CircuitHalfOpen, time.Now())
+	breaker.mutex.Unlock()
+
+	breaker.record(generation, false)
+	breaker.record(generation, false)
+	if state := breaker.State(); state != CircuitHalfOpen {
+		t.Errorf("state after stale successes = %s, want half-open", state)
+	}
+}
@@ -3,12 +3,19 @@ package client
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
-	"mime/multipart"
 	"net/http"
+	"path"
+	"strconv"
+	"strings"
 	"time"
+
+	apierrors "github.com/marcusziade/stability-go/errors"
+	"github.com/marcusziade/stability-go/internal/utils"
+	"github.com/marcusziade/stability-go/telemetry"
 )
 
 const (
@@ -21,6 +28,11 @@ type Client struct {
 	BaseURL    string
 	APIKey     string
 	HTTPClient *http.Client
+	// Optional pool of API keys used instead of APIKey
+	Keys *KeyPool
+	// Optional tracer and meter the client and its middleware report to (see WithTracer and WithMeter)
+	Tracer telemetry.Tracer
+	Meter  telemetry.Meter
 }
 
 // NewClient creates a new Stability AI client with the given API key
@@ -44,73 +56,275 @@ func (c *Client) WithHTTPClient(httpClient *http.Client) *Client {
 	return c
 }
 
-// request sends an HTTP request and returns the response
-func (c *Client) request(ctx context.Context, method, path string, body interface{}, headers map[string]string) (*http.Response, error) {
-	url := fmt.Sprintf("%s%s", c.BaseURL, path)
+// WithKeys spreads requests over several API keys, replacing APIKey.
+// Keys that are rate limited, rejected or out of credits are benched for a while and the request is resent with another key.
+func (c *Client) WithKeys(keys []string, config KeyPoolConfig) *Client {
+	return c.WithKeyPool(NewKeyPool(keys, config))
+}
 
-	var bodyReader io.Reader
-	if body != nil {
-		bodyBytes, err := json.Marshal(body)
+// WithKeyPool sets the pool of API keys used instead of APIKey. A pool can be shared by several clients.
+func (c *Client) WithKeyPool(pool *KeyPool) *Client {
+	c.Keys = pool
+	return c
+}
+
+// WithMiddleware wraps the client's transport in the given middleware chain.
+// The chain is built once on a copy of the HTTP client, so an *http.Client shared with other code is left untouched.
+func (c *Client) WithMiddleware(middleware ...Middleware) *Client {
+	httpClient := *c.HTTPClient
+	httpClient.Transport = Chain(c.HTTPClient.Transport, middleware...)
+	c.HTTPClient = &httpClient
+	return c
+}
+
+// WithTracer reports spans for upscales, polls and every middleware to the tracer
+func (c *Client) WithTracer(tracer telemetry.Tracer) *Client {
+	c.Tracer = tracer
+	return c
+}
+
+// WithMeter reports request counts, durations, retries and credits to the meter
+func (c *Client) WithMeter(meter telemetry.Meter) *Client {
+	c.Meter = meter
+	return c
+}
+
+// withTelemetry returns a context carrying the client's tracer and meter for its middleware.
+// Without them, a tracer and meter already carried by the context, such as the API server's, are kept.
+func (c *Client) withTelemetry(ctx context.Context) context.Context {
+	if c.Tracer != nil {
+		ctx = telemetry.ContextWithTracer(ctx, c.Tracer)
+	}
+	if c.Meter != nil {
+		ctx = telemetry.ContextWithMeter(ctx, c.Meter)
+	}
+	return ctx
+}
+
+// send builds the HTTP request for an operation and sends it.
+// Transport failures and non-2xx responses are returned as errors; on success the caller must close the response body.
+// With a key pool, a request rejected for its key (429, 401 or 402) is resent with the next key.
+func (c *Client) send(ctx context.Context, op Operation) (*http.Response, error) {
+	ctx = c.withTelemetry(ctx)
+	start := time.Now()
+
+	resp, err := c.sendWithKeys(ctx, op)
+
+	statusCode := 0
+	if resp != nil {
+		statusCode = resp.StatusCode
+	} else if apiErr, ok := apierrors.AsAPIError(err); ok {
+		statusCode = apiErr.StatusCode
+	}
+	telemetry.Add(ctx, telemetry.MetricClientRequests, 1,
+		telemetry.String(telemetry.AttrOperation, op.name()), telemetry.Int(telemetry.AttrStatusCode, statusCode))
+	telemetry.RecordDuration(ctx, telemetry.MetricClientDuration, start, telemetry.String(telemetry.AttrOperation, op.name()))
+
+	return resp, err
+}
+
+// sendWithKeys sends an operation with the client's API key or, with a key pool, with the keys of the pool
+func (c *Client) sendWithKeys(ctx context.Context, op Operation) (*http.Response, error) {
+	name := op.name()
+
+	// Prepare the body once, so resending it for another key doesn't rebuild it
+	body, err := op.body()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build %s request: %w", name, err)
+	}
+
+	if c.Keys == nil || c.Keys.Len() == 0 {
+		resp, err := c.sendWithKey(ctx, op, body, c.APIKey)
 		if err != nil {
 			return nil, err
 		}
-		bodyReader = bytes.NewReader(bodyBytes)
+		return checkResponse(resp, name)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, method, url, bodyReader)
+	// Poll asynchronous jobs with the key that started them, since results are private to an account
+	if index, key, ok := c.Keys.jobKey(path.Base(op.Path)); ok {
+		resp, err := c.sendWithKey(ctx, op, body, key)
+		if err != nil {
+			return nil, err
+		}
+		c.Keys.report(index, resp, utils.ParseRetryAfter(resp.Header.Get("Retry-After")))
+		return checkResponse(resp, name)
+	}
+
+	tried := make(map[int]bool, c.Keys.Len())
+	for {
+		index, key := c.Keys.acquire(tried)
+		tried[index] = true
+
+		// While other keys are left, a rejected key is failed over here instead of being retried by the middleware
+		keyCtx := ctx
+		if len(tried) < c.Keys.Len() {
+			keyCtx = withKeyFailover(ctx)
+		}
+
+		resp, err := c.sendWithKey(keyCtx, op, body, key)
+		if err != nil {
+			return nil, err
+		}
+
+		failover := c.Keys.report(index, resp, utils.ParseRetryAfter(resp.Header.Get("Retry-After")))
+		if failover && len(tried) < c.Keys.Len() {
+			resp.Body.Close()
+			continue
+		}
+
+		resp, err = checkResponse(resp, name)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.bindJob(op, resp, index); err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to read %s response: %w", name, err)
+		}
+		return resp, nil
+	}
+}
+
+// sendWithKey builds the HTTP request for an operation and sends it, authenticated with the given key
+func (c *Client) sendWithKey(ctx context.Context, op Operation, body *requestBody, apiKey string) (*http.Response, error) {
+	name := op.name()
+
+	url := fmt.Sprintf("%s%s", c.BaseURL, op.Path)
+	req, err := http.NewRequestWithContext(ctx, op.method(), url, nil)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to create %s request: %w", name, err)
+	}
+
+	// Stream the body; GetBody lets retries and redirects send it again without buffering a copy
+	if body != nil {
+		req.Body, err = body.open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to build %s request: %w", name, err)
+		}
+		req.GetBody = body.open
+		req.ContentLength = body.contentLength
+		req.Header.Set("Content-Type", body.contentType)
 	}
 
 	// Set default headers
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.APIKey))
+	req.Header.Set("Accept", op.accept())
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey))
 
 	// Set custom headers
-	for key, value := range headers {
-		req.Header.Set(key, value)
+	for key, values := range op.Header {
+		req.Header[http.CanonicalHeaderKey(key)] = values
 	}
 
-	return c.HTTPClient.Do(req)
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send %s request: %w", name, err)
+	}
+	return resp, nil
 }
 
-// createMultipartRequest creates a multipart request with a file
-func (c *Client) createMultipartRequest(ctx context.Context, path string, fileName string, fileData []byte, fields map[string]string) (*http.Response, error) {
-	url := fmt.Sprintf("%s%s", c.BaseURL, path)
+// checkResponse turns non-2xx responses into errors
+func checkResponse(resp *http.Response, operation string) (*http.Response, error) {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		return nil, parseErrorResponse(resp, operation)
+	}
+	return resp, nil
+}
 
-	var b bytes.Buffer
-	w := multipart.NewWriter(&b)
+// bindJob remembers the key that started an asynchronous job, so its result is polled with the same key.
+// Jobs are recognised by the ID in the JSON response to a POST; the body is buffered so the caller can still read it.
+func (c *Client) bindJob(op Operation, resp *http.Response, index int) error {
+	if op.method() != http.MethodPost || !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		return nil
+	}
 
-	// Add the file
-	fw, err := w.CreateFormFile("image", fileName)
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 100*1024*1024)) // 100MB limit
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
 	if err != nil {
-		return nil, err
-	}
-	if _, err = fw.Write(fileData); err != nil {
-		return nil, err
+		return err
 	}
 
-	// Add fields
-	for key, value := range fields {
-		if err := w.WriteField(key, value); err != nil {
-			return nil, err
-		}
+	var job struct {
+		ID string `json:"id"`
+	}
+	if json.Unmarshal(body, &job) == nil && job.ID != "" {
+		c.Keys.bindJob(job.ID, index)
 	}
+	return nil
+}
 
-	if err = w.Close(); err != nil {
-		return nil, err
+// decodeJSONResponse decodes a JSON response body into v
+func decodeJSONResponse(resp *http.Response, operation string, v interface{}) error {
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode %s response: %w", operation, err)
 	}
+	return nil
+}
+
+// parseErrorResponse converts a non-2xx API response into an *errors.APIError
+func parseErrorResponse(resp *http.Response, operation string) error {
+	apiErr := apierrors.ReadAPIError(resp, operation)
+	apiErr.Attempts, _ = strconv.Atoi(resp.Header.Get(RetryAttemptsHeader))
+	return apiErr
+}
+
+// imageResult holds an image returned by one of the synchronous image endpoints
+type imageResult struct {
+	Data         []byte
+	MimeType     string
+	Seed         int64
+	FinishReason string
+}
+
+// imageJSONResponse is the body returned by the image endpoints when Accept is application/json
+type imageJSONResponse struct {
+	Image        string `json:"image"`
+	FinishReason string `json:"finish_reason"`
+	Seed         int64  `json:"seed"`
+}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", url, &b)
+// readImageResult reads an image from a successful response, handling both binary and base64 JSON bodies
+func readImageResult(resp *http.Response) (*imageResult, error) {
+	// Add a buffer size limit to prevent excessive memory usage
+	bodyData, err := io.ReadAll(io.LimitReader(resp.Body, 100*1024*1024)) // 100MB limit
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
 
-	req.Header.Set("Content-Type", w.FormDataContentType())
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.APIKey))
+	// If no data was received but no error, it might be a silent failure or content policy violation
+	if len(bodyData) == 0 {
+		return nil, fmt.Errorf("no data received in response; this may indicate a content policy violation")
+	}
 
-	return c.HTTPClient.Do(req)
-}
+	contentType := resp.Header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, "application/json") {
+		seed, _ := strconv.ParseInt(resp.Header.Get("seed"), 10, 64)
+		return &imageResult{
+			Data:         bodyData,
+			MimeType:     contentType,
+			Seed:         seed,
+			FinishReason: resp.Header.Get("finish-reason"),
+		}, nil
+	}
+
+	var jsonResp imageJSONResponse
+	if err := json.Unmarshal(bodyData, &jsonResp); err != nil {
+		return nil, fmt.Errorf("failed to decode image response: %w", err)
+	}
 
+	imageData, err := base64.StdEncoding.DecodeString(jsonResp.Image)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode base64 image: %w", err)
+	}
+	if len(imageData) == 0 {
+		return nil, fmt.Errorf("no data received in response; this may indicate a content policy violation")
+	}
+
+	return &imageResult{
+		Data:         imageData,
+		MimeType:     http.DetectContentType(imageData),
+		Seed:         jsonResp.Seed,
+		FinishReason: jsonResp.FinishReason,
+	}, nil
+}
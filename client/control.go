@@ -0,0 +1,187 @@
+package client
+
+import (
+	"context"
+	"strconv"
+)
+
+// Control API endpoints
+const (
+	ControlSketchPath    = "/v2beta/stable-image/control/sketch"
+	ControlStructurePath = "/v2beta/stable-image/control/structure"
+	ControlStylePath     = "/v2beta/stable-image/control/style"
+)
+
+// ControlType represents the available control methods
+type ControlType string
+
+const (
+	ControlTypeSketch    ControlType = "sketch"
+	ControlTypeStructure ControlType = "structure"
+	ControlTypeStyle     ControlType = "style"
+)
+
+// ReferenceImage is an image that guides generation rather than being edited
+type ReferenceImage struct {
+	// The reference image (binary data)
+	Image []byte
+	// The filename of the image
+	Filename string
+}
+
+// ControlRequest represents the parameters for a control request
+type ControlRequest struct {
+	// The control method to use
+	Type ControlType
+	// The control image: a sketch, an image whose structure to keep, or a style reference
+	Control ReferenceImage
+	// The prompt describing the image to generate
+	Prompt string
+	// Optional negative prompt
+	NegativePrompt string
+	// How strongly the control image guides generation (0-1, only for sketch and structure)
+	ControlStrength float64
+	// How closely the output resembles the style reference (0-1, only for style)
+	Fidelity float64
+	// Aspect ratio of the generated image (only for style)
+	AspectRatio AspectRatio
+	// Optional seed value
+	Seed int64
+	// Output format (jpeg, png, webp)
+	OutputFormat OutputFormat
+	// Optional style preset
+	StylePreset StylePreset
+	// Whether to return image as base64 JSON instead of binary
+	ReturnAsJSON bool
+}
+
+// ControlResponse represents the response from the control API
+type ControlResponse struct {
+	// The generated image data
+	ImageData []byte
+	// The mime type of the image (e.g., "image/png")
+	MimeType string
+	// The seed used to generate the image
+	Seed int64
+	// The finish reason reported by the API (SUCCESS or CONTENT_FILTERED)
+	FinishReason string
+}
+
+// Validate checks the request against the documented limits of the control endpoints
+func (r ControlRequest) Validate() error {
+	var v validator
+	switch r.Type {
+	case ControlTypeSketch, ControlTypeStructure, ControlTypeStyle:
+	default:
+		v.add("type", "invalid control type %q", r.Type)
+	}
+
+	v.image("image", r.Control.Image, ControlImageLimits, true)
+	v.prompt("prompt", r.Prompt, true)
+	v.prompt("negative_prompt", r.NegativePrompt, false)
+
+	if r.Type == ControlTypeStyle {
+		if r.ControlStrength != 0 {
+			v.add("control_strength", "is not supported for style control; use fidelity instead")
+		}
+		v.between("fidelity", r.Fidelity, 0, 1)
+		v.aspectRatio(r.AspectRatio)
+	} else {
+		if r.Fidelity != 0 {
+			v.add("fidelity", "is only supported for style control")
+		}
+		if r.AspectRatio != "" {
+			v.add("aspect_ratio", "is only supported for style control")
+		}
+		v.between("control_strength", r.ControlStrength, 0, 1)
+	}
+
+	v.seed(r.Seed)
+	v.outputFormat(r.OutputFormat)
+	return v.err(string(r.Type) + " control")
+}
+
+// Control generates an image guided by a control image
+func (c *Client) Control(ctx context.Context, request ControlRequest) (*ControlResponse, error) {
+	if err := request.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Determine the endpoint based on control type
+	endpoint := ControlSketchPath
+	switch request.Type {
+	case ControlTypeStructure:
+		endpoint = ControlStructurePath
+	case ControlTypeStyle:
+		endpoint = ControlStylePath
+	}
+
+	fields := map[string]string{
+		"prompt": request.Prompt,
+	}
+	if request.NegativePrompt != "" {
+		fields["negative_prompt"] = request.NegativePrompt
+	}
+
+	if request.Type == ControlTypeStyle {
+		if request.Fidelity != 0 {
+			fields["fidelity"] = strconv.FormatFloat(request.Fidelity, 'f', 2, 64)
+		}
+		if request.AspectRatio != "" {
+			fields["aspect_ratio"] = string(request.AspectRatio)
+		}
+	} else {
+		if request.ControlStrength != 0 {
+			fields["control_strength"] = strconv.FormatFloat(request.ControlStrength, 'f', 2, 64)
+		}
+	}
+	addCommonEditFields(fields, request.Seed, request.OutputFormat, request.StylePreset)
+
+	// Set the accept header based on whether we want JSON or binary response
+	accept := "image/*"
+	if request.ReturnAsJSON {
+		accept = "application/json"
+	}
+
+	resp, err := c.send(ctx, Operation{
+		Path:   endpoint,
+		Accept: accept,
+		Fields: fields,
+		Files:  []FormFile{{Field: "image", Filename: request.Control.Filename, Data: request.Control.Image}},
+		Name:   "control",
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	result, err := readImageResult(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ControlResponse{
+		ImageData:    result.Data,
+		MimeType:     result.MimeType,
+		Seed:         result.Seed,
+		FinishReason: result.FinishReason,
+	}, nil
+}
+
+// ControlSketch generates an image from a sketch
+func (c *Client) ControlSketch(ctx context.Context, request ControlRequest) (*ControlResponse, error) {
+	request.Type = ControlTypeSketch
+	return c.Control(ctx, request)
+}
+
+// ControlStructure generates an image that keeps the structure of the control image
+func (c *Client) ControlStructure(ctx context.Context, request ControlRequest) (*ControlResponse, error) {
+	request.Type = ControlTypeStructure
+	return c.Control(ctx, request)
+}
+
+// ControlStyle generates an image in the style of the control image
+func (c *Client) ControlStyle(ctx context.Context, request ControlRequest) (*ControlResponse, error) {
+	request.Type = ControlTypeStyle
+	return c.Control(ctx, request)
+}
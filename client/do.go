@@ -0,0 +1,320 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// FormFile is a file part of a multipart operation
+type FormFile struct {
+	// The form field name (e.g., "image" or "mask")
+	Field string
+	// The filename sent with the part
+	Filename string
+	// The file contents
+	Data []byte
+	// Reader streams the file contents instead of Data, so they are never held in memory.
+	// Readers that implement io.Seeker (such as *os.File) are rewound to where they were when the operation was sent,
+	// so the body can be sent again for retries; other readers can only be sent once.
+	Reader io.Reader
+}
+
+// errBodyConsumed is returned when a body streamed from a reader that can't be rewound has to be sent again
+var errBodyConsumed = errors.New("the request body was streamed from a reader that can't be rewound and has already been sent")
+
+// requestBody is the body of an operation, which can be opened again to resend it
+type requestBody struct {
+	contentType string
+	// Length of the body in bytes, or -1 if it isn't known in advance
+	contentLength int64
+	// open returns a reader for the whole body
+	open func() (io.ReadCloser, error)
+}
+
+// Operation describes a single request to the Stability API.
+// Setting Fields or Files sends a multipart/form-data body; otherwise JSON is sent if set.
+type Operation struct {
+	// HTTP method (defaults to POST when there is a body and GET otherwise)
+	Method string
+	// Path relative to the base URL (e.g., "/v2beta/stable-image/generate/core")
+	Path string
+	// Accept header (defaults to application/json)
+	Accept string
+	// Form fields for a multipart body
+	Fields map[string]string
+	// File parts for a multipart body
+	Files []FormFile
+	// Value to send as a JSON body
+	JSON interface{}
+	// Additional request headers
+	Header http.Header
+	// Name used in error messages (defaults to the path)
+	Name string
+}
+
+// Result is the raw response to an Operation
+type Result struct {
+	// The HTTP status code (always 2xx, since other statuses are returned as errors)
+	StatusCode int
+	// The response headers
+	Header http.Header
+	// The response body
+	Body []byte
+}
+
+// ContentType returns the Content-Type of the response
+func (r *Result) ContentType() string {
+	return r.Header.Get("Content-Type")
+}
+
+// DecodeJSON decodes a JSON response body into v
+func (r *Result) DecodeJSON(v interface{}) error {
+	if err := json.Unmarshal(r.Body, v); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// Do sends an arbitrary operation through the client's request pipeline and returns the raw response.
+// It is an escape hatch for calling Stability endpoints that don't have a typed method yet; authentication,
+// Accept negotiation and error parsing work exactly as they do for the typed methods.
+func (c *Client) Do(ctx context.Context, op Operation) (*Result, error) {
+	if op.Path == "" {
+		return nil, fmt.Errorf("operation path is required")
+	}
+
+	resp, err := c.send(ctx, op)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Add a buffer size limit to prevent excessive memory usage
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 100*1024*1024)) // 100MB limit
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s response: %w", op.name(), err)
+	}
+
+	return &Result{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       body,
+	}, nil
+}
+
+// isMultipart reports whether the operation sends a multipart body
+func (op Operation) isMultipart() bool {
+	return len(op.Fields) > 0 || len(op.Files) > 0
+}
+
+// method returns the HTTP method of the operation
+func (op Operation) method() string {
+	if op.Method != "" {
+		return op.Method
+	}
+	if op.isMultipart() || op.JSON != nil {
+		return http.MethodPost
+	}
+	return http.MethodGet
+}
+
+// accept returns the Accept header of the operation
+func (op Operation) accept() string {
+	if op.Accept != "" {
+		return op.Accept
+	}
+	return "application/json"
+}
+
+// name returns the name of the operation used in error messages
+func (op Operation) name() string {
+	if op.Name != "" {
+		return op.Name
+	}
+	return strings.TrimPrefix(op.Path, "/")
+}
+
+// body prepares the request body, or returns nil if the operation has none
+func (op Operation) body() (*requestBody, error) {
+	if op.isMultipart() {
+		return op.multipartBody()
+	}
+
+	if op.JSON != nil {
+		data, err := json.Marshal(op.JSON)
+		if err != nil {
+			return nil, err
+		}
+		return &requestBody{
+			contentType:   "application/json",
+			contentLength: int64(len(data)),
+			open: func() (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(data)), nil
+			},
+		}, nil
+	}
+
+	return nil, nil
+}
+
+// multipartBody prepares a multipart/form-data body from the operation's files and fields.
+// The body is streamed through a pipe as it is sent, so files are never copied into a buffer.
+func (op Operation) multipartBody() (*requestBody, error) {
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+
+	// Remember where seekable readers start, so every attempt sends the same bytes, and measure the files
+	starts := make([]int64, len(op.Files))
+	var filesLength int64
+	knownLength := true
+	for i, file := range op.Files {
+		if file.Reader == nil {
+			filesLength += int64(len(file.Data))
+			continue
+		}
+
+		seeker, ok := file.Reader.(io.Seeker)
+		if !ok {
+			knownLength = false
+			continue
+		}
+		start, err := seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, fmt.Errorf("failed to seek %s: %w", file.Field, err)
+		}
+		end, err := seeker.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, fmt.Errorf("failed to seek %s: %w", file.Field, err)
+		}
+		if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("failed to seek %s: %w", file.Field, err)
+		}
+		starts[i] = start
+		filesLength += end - start
+	}
+
+	// The multipart framing doesn't depend on the file contents, so measure it by writing the body without them
+	contentLength := int64(-1)
+	if knownLength {
+		var framing countingWriter
+		if err := op.writeMultipart(&framing, boundary, false); err != nil {
+			return nil, err
+		}
+		contentLength = framing.n + filesLength
+	}
+
+	var (
+		mutex    sync.Mutex
+		sent     bool
+		previous *io.PipeReader
+		written  chan struct{}
+	)
+	open := func() (io.ReadCloser, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		// Stop the previous attempt's writer before rewinding the readers it is copying from
+		if previous != nil {
+			previous.Close()
+			<-written
+		}
+
+		for i, file := range op.Files {
+			if file.Reader == nil {
+				continue
+			}
+			seeker, ok := file.Reader.(io.Seeker)
+			if !ok {
+				if sent {
+					return nil, errBodyConsumed
+				}
+				continue
+			}
+			if _, err := seeker.Seek(starts[i], io.SeekStart); err != nil {
+				return nil, fmt.Errorf("failed to rewind %s: %w", file.Field, err)
+			}
+		}
+		sent = true
+
+		pr, pw := io.Pipe()
+		previous, written = pr, make(chan struct{})
+		go func(written chan struct{}) {
+			defer close(written)
+			pw.CloseWithError(op.writeMultipart(pw, boundary, true))
+		}(written)
+		return pr, nil
+	}
+
+	w := multipart.NewWriter(io.Discard)
+	if err := w.SetBoundary(boundary); err != nil {
+		return nil, fmt.Errorf("failed to set multipart boundary: %w", err)
+	}
+
+	return &requestBody{
+		contentType:   w.FormDataContentType(),
+		contentLength: contentLength,
+		open:          open,
+	}, nil
+}
+
+// writeMultipart writes the multipart body to dst, leaving out the file contents unless withFiles is set
+func (op Operation) writeMultipart(dst io.Writer, boundary string, withFiles bool) error {
+	w := multipart.NewWriter(dst)
+	if err := w.SetBoundary(boundary); err != nil {
+		return fmt.Errorf("failed to set multipart boundary: %w", err)
+	}
+
+	// Add the files
+	for _, file := range op.Files {
+		fw, err := w.CreateFormFile(file.Field, file.Filename)
+		if err != nil {
+			return fmt.Errorf("failed to create form file: %w", err)
+		}
+		if !withFiles {
+			continue
+		}
+		if file.Reader != nil {
+			_, err = io.Copy(fw, file.Reader)
+		} else {
+			_, err = fw.Write(file.Data)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write file data: %w", err)
+		}
+	}
+
+	// Add fields in a stable order so identical operations produce identical bodies
+	keys := make([]string, 0, len(op.Fields))
+	for key := range op.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := w.WriteField(key, op.Fields[key]); err != nil {
+			return fmt.Errorf("failed to write form field %s: %w", key, err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to close multipart writer: %w", err)
+	}
+	return nil
+}
+
+// countingWriter counts the bytes written to it
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
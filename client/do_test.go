@@ -0,0 +1,244 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	apierrors "github.com/marcusziade/stability-go/errors"
+)
+
+// nonSeeker hides the io.Seeker of a reader
+type nonSeeker struct {
+	io.Reader
+}
+
+// readParts reads a multipart body, returning the contents of its parts by form name
+func readParts(t *testing.T, contentType string, body io.Reader) map[string]string {
+	t.Helper()
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		t.Fatalf("invalid content type %q: %v", contentType, err)
+	}
+
+	parts := map[string]string{}
+	r := multipart.NewReader(body, params["boundary"])
+	for {
+		part, err := r.NextPart()
+		if err == io.EOF {
+			return parts
+		}
+		if err != nil {
+			t.Fatalf("invalid multipart body: %v", err)
+		}
+		data, _ := io.ReadAll(part)
+		parts[part.FormName()] = string(data)
+	}
+}
+
+// uploadServer answers the first failures requests with a 500 and later ones with a 200,
+// recording the multipart parts each request received
+type uploadServer struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	failures int
+	received []map[string]string
+}
+
+func newUploadServer(t *testing.T, failures int) *uploadServer {
+	s := &uploadServer{failures: failures}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := readParts(t, r.Header.Get("Content-Type"), r.Body)
+
+		s.mutex.Lock()
+		s.received = append(s.received, parts)
+		fail := len(s.received) <= s.failures
+		s.mutex.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"name":"internal_error","errors":["try again"]}`))
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestMultipartBodyContentLength(t *testing.T) {
+	file := bytes.NewReader([]byte("skipped header|streamed contents"))
+	file.Seek(int64(len("skipped header|")), io.SeekStart)
+
+	op := Operation{
+		Fields: map[string]string{"prompt": "a lighthouse", "seed": "42"},
+		Files: []FormFile{
+			{Field: "image", Filename: "image.png", Data: []byte("in-memory contents")},
+			{Field: "mask", Filename: "mask.png", Reader: file},
+		},
+	}
+	body, err := op.multipartBody()
+	if err != nil {
+		t.Fatalf("multipartBody failed: %v", err)
+	}
+
+	// The length is measured up front but must match the bytes streamed, for every attempt
+	for attempt := 1; attempt <= 2; attempt++ {
+		r, err := body.open()
+		if err != nil {
+			t.Fatalf("attempt %d: open failed: %v", attempt, err)
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("attempt %d: read failed: %v", attempt, err)
+		}
+		if int64(len(data)) != body.contentLength {
+			t.Errorf("attempt %d: streamed %d bytes, Content-Length is %d", attempt, len(data), body.contentLength)
+		}
+
+		parts := readParts(t, body.contentType, bytes.NewReader(data))
+		want := map[string]string{"image": "in-memory contents", "mask": "streamed contents", "prompt": "a lighthouse", "seed": "42"}
+		for name, contents := range want {
+			if parts[name] != contents {
+				t.Errorf("attempt %d: part %s = %q, want %q", attempt, name, parts[name], contents)
+			}
+		}
+	}
+}
+
+func TestMultipartBodyUnknownLength(t *testing.T) {
+	op := Operation{Files: []FormFile{{Field: "image", Filename: "image.png", Reader: nonSeeker{bytes.NewReader([]byte("contents"))}}}}
+	body, err := op.multipartBody()
+	if err != nil {
+		t.Fatalf("multipartBody failed: %v", err)
+	}
+	if body.contentLength != -1 {
+		t.Errorf("Content-Length = %d, want -1 for a reader that can't be measured", body.contentLength)
+	}
+
+	r, err := body.open()
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	io.Copy(io.Discard, r)
+
+	// The reader can't be rewound, so the body can't be sent again
+	if _, err := body.open(); !errors.Is(err, errBodyConsumed) {
+		t.Errorf("second open = %v, want errBodyConsumed", err)
+	}
+}
+
+func TestRetryRewindsSeekableReader(t *testing.T) {
+	server := newUploadServer(t, 1)
+	c := NewClient("sk-test").WithBaseURL(server.URL).WithMiddleware(Retry(2, time.Millisecond, time.Millisecond))
+
+	_, err := c.Do(context.Background(), Operation{
+		Path:  "/v2beta/upload",
+		Files: []FormFile{{Field: "image", Filename: "image.png", Reader: bytes.NewReader([]byte("image contents"))}},
+	})
+	if err != nil {
+		t.Fatalf("Do failed: %v", err)
+	}
+
+	if len(server.received) != 2 {
+		t.Fatalf("server received %d requests, want 2", len(server.received))
+	}
+	for i, parts := range server.received {
+		if parts["image"] != "image contents" {
+			t.Errorf("attempt %d: image = %q, want the whole file", i+1, parts["image"])
+		}
+	}
+}
+
+func TestRetryStopsForNonSeekableReader(t *testing.T) {
+	server := newUploadServer(t, 1)
+	c := NewClient("sk-test").WithBaseURL(server.URL).WithMiddleware(Retry(2, time.Millisecond, time.Millisecond))
+
+	_, err := c.Do(context.Background(), Operation{
+		Path:  "/v2beta/upload",
+		Files: []FormFile{{Field: "image", Filename: "image.png", Reader: nonSeeker{bytes.NewReader([]byte("image contents"))}}},
+	})
+
+	// The body can't be sent again, so the first failure is returned instead of a retry with a truncated body
+	apiErr, ok := apierrors.AsAPIError(err)
+	if !ok || apiErr.StatusCode != http.StatusInternalServerError || apiErr.Attempts != 1 {
+		t.Fatalf("err = %v, want the 500 of the only attempt", err)
+	}
+	if len(server.received) != 1 {
+		t.Errorf("server received %d requests, want 1", len(server.received))
+	}
+}
+
+func TestRejectedRequestReleasesStreamedBody(t *testing.T) {
+	unreachable := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		t.Error("rejected request reached the transport")
+		return nil, errors.New("unreachable")
+	})
+
+	tests := []struct {
+		name string
+		// reject returns a middleware that rejects requests sent with the context it returns
+		reject func() (http.RoundTripper, context.Context, context.CancelFunc)
+	}{
+		{"open circuit", func() (http.RoundTripper, context.Context, context.CancelFunc) {
+			breaker := NewCircuitBreaker(CircuitBreakerConfig{Cooldown: time.Hour})
+			breaker.mutex.Lock()
+			breaker.setState(CircuitOpen, time.Now())
+			breaker.mutex.Unlock()
+			ctx, cancel := context.WithCancel(context.Background())
+			return NewCircuitBreakerMiddleware(breaker, unreachable), ctx, cancel
+		}},
+		{"misconfigured proxy", func() (http.RoundTripper, context.Context, context.CancelFunc) {
+			ctx, cancel := context.WithCancel(context.Background())
+			return NewProxyMiddleware(ProxyConfig{URL: "http://proxy.internal:3128"}, unreachable), ctx, cancel
+		}},
+		{"rate limit wait cancelled", func() (http.RoundTripper, context.Context, context.CancelFunc) {
+			m := NewRateLimitMiddleware(time.Hour, unreachable)
+			m.fallback.Wait(context.Background())
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			return m, ctx, cancel
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, ctx, cancel := tt.reject()
+			defer cancel()
+
+			op := Operation{Files: []FormFile{{Field: "image", Filename: "image.png", Data: []byte("image contents")}}}
+			body, err := op.multipartBody()
+			if err != nil {
+				t.Fatalf("multipartBody failed: %v", err)
+			}
+
+			before := runtime.NumGoroutine()
+			r, err := body.open()
+			if err != nil {
+				t.Fatalf("open failed: %v", err)
+			}
+			req, _ := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.stability.ai/v2beta/upload", r)
+			if _, err := m.RoundTrip(req); err == nil {
+				t.Fatal("RoundTrip succeeded, want the request rejected")
+			}
+
+			// The goroutine streaming the body exits once the rejected body is closed
+			deadline := time.Now().Add(time.Second)
+			for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			if n := runtime.NumGoroutine(); n > before {
+				t.Errorf("%d goroutines left after the rejected request, want %d", n, before)
+			}
+		})
+	}
+}
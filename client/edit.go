@@ -0,0 +1,400 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// Edit API endpoints
+const (
+	EditErasePath            = "/v2beta/stable-image/edit/erase"
+	EditInpaintPath          = "/v2beta/stable-image/edit/inpaint"
+	EditOutpaintPath         = "/v2beta/stable-image/edit/outpaint"
+	EditSearchAndReplacePath = "/v2beta/stable-image/edit/search-and-replace"
+	EditSearchAndRecolorPath = "/v2beta/stable-image/edit/search-and-recolor"
+)
+
+// EditImage holds the image to edit along with an optional mask
+type EditImage struct {
+	// The image to edit (binary data)
+	Image []byte
+	// The filename of the image
+	Filename string
+	// Optional mask image; white pixels mark the area to edit
+	Mask []byte
+	// The filename of the mask
+	MaskFilename string
+}
+
+// EraseRequest represents the parameters for an erase request
+type EraseRequest struct {
+	EditImage
+	// Pixels to grow the mask edges by (0-20, 0 uses the API default)
+	GrowMask int
+	// Optional seed value
+	Seed int64
+	// Output format (jpeg, png, webp)
+	OutputFormat OutputFormat
+	// Whether to return image as base64 JSON instead of binary
+	ReturnAsJSON bool
+}
+
+// InpaintRequest represents the parameters for an inpaint request
+type InpaintRequest struct {
+	EditImage
+	// The prompt describing what to paint into the masked area
+	Prompt string
+	// Optional negative prompt
+	NegativePrompt string
+	// Pixels to grow the mask edges by (0-100, 0 uses the API default)
+	GrowMask int
+	// Optional seed value
+	Seed int64
+	// Output format (jpeg, png, webp)
+	OutputFormat OutputFormat
+	// Optional style preset
+	StylePreset StylePreset
+	// Whether to return image as base64 JSON instead of binary
+	ReturnAsJSON bool
+}
+
+// OutpaintRequest represents the parameters for an outpaint request
+type OutpaintRequest struct {
+	// The image to outpaint (binary data)
+	Image []byte
+	// The filename of the image
+	Filename string
+	// Pixels to extend the image by on each side (0-2000)
+	Left  int
+	Right int
+	Up    int
+	Down  int
+	// Creativity level (0-1, 0 uses the API default)
+	Creativity float64
+	// Optional prompt to guide the outpainted content
+	Prompt string
+	// Optional seed value
+	Seed int64
+	// Output format (jpeg, png, webp)
+	OutputFormat OutputFormat
+	// Optional style preset
+	StylePreset StylePreset
+	// Whether to return image as base64 JSON instead of binary
+	ReturnAsJSON bool
+}
+
+// SearchAndReplaceRequest represents the parameters for a search-and-replace request
+type SearchAndReplaceRequest struct {
+	// The image to edit (binary data)
+	Image []byte
+	// The filename of the image
+	Filename string
+	// The prompt describing the replacement
+	Prompt string
+	// Short description of the object to replace
+	SearchPrompt string
+	// Optional negative prompt
+	NegativePrompt string
+	// Pixels to grow the detected mask by (0-20, 0 uses the API default)
+	GrowMask int
+	// Optional seed value
+	Seed int64
+	// Output format (jpeg, png, webp)
+	OutputFormat OutputFormat
+	// Optional style preset
+	StylePreset StylePreset
+	// Whether to return image as base64 JSON instead of binary
+	ReturnAsJSON bool
+}
+
+// SearchAndRecolorRequest represents the parameters for a search-and-recolor request
+type SearchAndRecolorRequest struct {
+	// The image to edit (binary data)
+	Image []byte
+	// The filename of the image
+	Filename string
+	// The prompt describing the new colors
+	Prompt string
+	// Short description of the object to recolor
+	SelectPrompt string
+	// Optional negative prompt
+	NegativePrompt string
+	// Pixels to grow the detected mask by (0-20, 0 uses the API default)
+	GrowMask int
+	// Optional seed value
+	Seed int64
+	// Output format (jpeg, png, webp)
+	OutputFormat OutputFormat
+	// Optional style preset
+	StylePreset StylePreset
+	// Whether to return image as base64 JSON instead of binary
+	ReturnAsJSON bool
+}
+
+// EditResponse represents the response from the edit API
+type EditResponse struct {
+	// The edited image data
+	ImageData []byte
+	// The mime type of the image (e.g., "image/png")
+	MimeType string
+	// The seed used for the edit
+	Seed int64
+	// The finish reason reported by the API (SUCCESS or CONTENT_FILTERED)
+	FinishReason string
+}
+
+// Validate checks the request against the documented limits of the erase endpoint
+func (r EraseRequest) Validate() error {
+	var v validator
+	r.EditImage.validate(&v)
+	v.growMask(r.GrowMask, 20)
+	v.seed(r.Seed)
+	v.outputFormat(r.OutputFormat)
+	return v.err("erase")
+}
+
+// Erase removes unwanted objects from an image using a mask
+func (c *Client) Erase(ctx context.Context, request EraseRequest) (*EditResponse, error) {
+	if err := request.Validate(); err != nil {
+		return nil, err
+	}
+
+	fields := map[string]string{}
+	addGrowMask(fields, request.GrowMask)
+	addCommonEditFields(fields, request.Seed, request.OutputFormat, "")
+
+	return c.edit(ctx, EditErasePath, request.EditImage, fields, request.ReturnAsJSON)
+}
+
+// Validate checks the request against the documented limits of the inpaint endpoint
+func (r InpaintRequest) Validate() error {
+	var v validator
+	r.EditImage.validate(&v)
+	v.prompt("prompt", r.Prompt, true)
+	v.prompt("negative_prompt", r.NegativePrompt, false)
+	v.growMask(r.GrowMask, 100)
+	v.seed(r.Seed)
+	v.outputFormat(r.OutputFormat)
+	return v.err("inpaint")
+}
+
+// Inpaint fills or replaces the masked area of an image based on a prompt
+func (c *Client) Inpaint(ctx context.Context, request InpaintRequest) (*EditResponse, error) {
+	if err := request.Validate(); err != nil {
+		return nil, err
+	}
+
+	fields := map[string]string{
+		"prompt": request.Prompt,
+	}
+	if request.NegativePrompt != "" {
+		fields["negative_prompt"] = request.NegativePrompt
+	}
+	addGrowMask(fields, request.GrowMask)
+	addCommonEditFields(fields, request.Seed, request.OutputFormat, request.StylePreset)
+
+	return c.edit(ctx, EditInpaintPath, request.EditImage, fields, request.ReturnAsJSON)
+}
+
+// directions returns the outpaint distances by field name
+func (r OutpaintRequest) directions() []struct {
+	name   string
+	pixels int
+} {
+	return []struct {
+		name   string
+		pixels int
+	}{
+		{"left", r.Left},
+		{"right", r.Right},
+		{"up", r.Up},
+		{"down", r.Down},
+	}
+}
+
+// Validate checks the request against the documented limits of the outpaint endpoint
+func (r OutpaintRequest) Validate() error {
+	var v validator
+	v.image("image", r.Image, EditImageLimits, true)
+
+	total := 0
+	for _, direction := range r.directions() {
+		if direction.pixels < 0 || direction.pixels > 2000 {
+			v.add(direction.name, "must be between 0 and 2000")
+		}
+		total += direction.pixels
+	}
+	if total == 0 {
+		v.add("left", "at least one outpaint direction is required")
+	}
+
+	v.between("creativity", r.Creativity, 0, 1)
+	v.prompt("prompt", r.Prompt, false)
+	v.seed(r.Seed)
+	v.outputFormat(r.OutputFormat)
+	return v.err("outpaint")
+}
+
+// Outpaint extends an image in any direction
+func (c *Client) Outpaint(ctx context.Context, request OutpaintRequest) (*EditResponse, error) {
+	if err := request.Validate(); err != nil {
+		return nil, err
+	}
+
+	fields := map[string]string{}
+	for _, direction := range request.directions() {
+		if direction.pixels > 0 {
+			fields[direction.name] = strconv.Itoa(direction.pixels)
+		}
+	}
+
+	if request.Creativity != 0 {
+		fields["creativity"] = strconv.FormatFloat(request.Creativity, 'f', 2, 64)
+	}
+	if request.Prompt != "" {
+		fields["prompt"] = request.Prompt
+	}
+	addCommonEditFields(fields, request.Seed, request.OutputFormat, request.StylePreset)
+
+	image := EditImage{Image: request.Image, Filename: request.Filename}
+	return c.edit(ctx, EditOutpaintPath, image, fields, request.ReturnAsJSON)
+}
+
+// Validate checks the request against the documented limits of the search-and-replace endpoint
+func (r SearchAndReplaceRequest) Validate() error {
+	var v validator
+	v.image("image", r.Image, EditImageLimits, true)
+	v.prompt("prompt", r.Prompt, true)
+	v.prompt("search_prompt", r.SearchPrompt, true)
+	v.prompt("negative_prompt", r.NegativePrompt, false)
+	v.growMask(r.GrowMask, 20)
+	v.seed(r.Seed)
+	v.outputFormat(r.OutputFormat)
+	return v.err("search and replace")
+}
+
+// SearchAndReplace replaces an object described by a search prompt with new content
+func (c *Client) SearchAndReplace(ctx context.Context, request SearchAndReplaceRequest) (*EditResponse, error) {
+	if err := request.Validate(); err != nil {
+		return nil, err
+	}
+
+	fields := map[string]string{
+		"prompt":        request.Prompt,
+		"search_prompt": request.SearchPrompt,
+	}
+	if request.NegativePrompt != "" {
+		fields["negative_prompt"] = request.NegativePrompt
+	}
+	addGrowMask(fields, request.GrowMask)
+	addCommonEditFields(fields, request.Seed, request.OutputFormat, request.StylePreset)
+
+	image := EditImage{Image: request.Image, Filename: request.Filename}
+	return c.edit(ctx, EditSearchAndReplacePath, image, fields, request.ReturnAsJSON)
+}
+
+// Validate checks the request against the documented limits of the search-and-recolor endpoint
+func (r SearchAndRecolorRequest) Validate() error {
+	var v validator
+	v.image("image", r.Image, EditImageLimits, true)
+	v.prompt("prompt", r.Prompt, true)
+	v.prompt("select_prompt", r.SelectPrompt, true)
+	v.prompt("negative_prompt", r.NegativePrompt, false)
+	v.growMask(r.GrowMask, 20)
+	v.seed(r.Seed)
+	v.outputFormat(r.OutputFormat)
+	return v.err("search and recolor")
+}
+
+// SearchAndRecolor changes the color of an object described by a select prompt
+func (c *Client) SearchAndRecolor(ctx context.Context, request SearchAndRecolorRequest) (*EditResponse, error) {
+	if err := request.Validate(); err != nil {
+		return nil, err
+	}
+
+	fields := map[string]string{
+		"prompt":        request.Prompt,
+		"select_prompt": request.SelectPrompt,
+	}
+	if request.NegativePrompt != "" {
+		fields["negative_prompt"] = request.NegativePrompt
+	}
+	addGrowMask(fields, request.GrowMask)
+	addCommonEditFields(fields, request.Seed, request.OutputFormat, request.StylePreset)
+
+	image := EditImage{Image: request.Image, Filename: request.Filename}
+	return c.edit(ctx, EditSearchAndRecolorPath, image, fields, request.ReturnAsJSON)
+}
+
+// edit sends an edit request with the image and optional mask parts
+func (c *Client) edit(ctx context.Context, endpoint string, image EditImage, fields map[string]string, returnAsJSON bool) (*EditResponse, error) {
+	if len(image.Image) == 0 {
+		return nil, fmt.Errorf("image is required")
+	}
+
+	files := []FormFile{{Field: "image", Filename: image.Filename, Data: image.Image}}
+	if len(image.Mask) > 0 {
+		maskFilename := image.MaskFilename
+		if maskFilename == "" {
+			maskFilename = "mask.png"
+		}
+		files = append(files, FormFile{Field: "mask", Filename: maskFilename, Data: image.Mask})
+	}
+
+	// Set the accept header based on whether we want JSON or binary response
+	accept := "image/*"
+	if returnAsJSON {
+		accept = "application/json"
+	}
+
+	resp, err := c.send(ctx, Operation{
+		Path:   endpoint,
+		Accept: accept,
+		Fields: fields,
+		Files:  files,
+		Name:   "edit",
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	result, err := readImageResult(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EditResponse{
+		ImageData:    result.Data,
+		MimeType:     result.MimeType,
+		Seed:         result.Seed,
+		FinishReason: result.FinishReason,
+	}, nil
+}
+
+// validate checks the image and optional mask of an edit request
+func (e EditImage) validate(v *validator) {
+	v.image("image", e.Image, EditImageLimits, true)
+	v.image("mask", e.Mask, EditImageLimits, false)
+}
+
+// addGrowMask adds the grow_mask field if it is set
+func addGrowMask(fields map[string]string, growMask int) {
+	if growMask > 0 {
+		fields["grow_mask"] = strconv.Itoa(growMask)
+	}
+}
+
+// addCommonEditFields adds the seed, output format and style preset fields shared by the edit endpoints
+func addCommonEditFields(fields map[string]string, seed int64, outputFormat OutputFormat, stylePreset StylePreset) {
+	if seed > 0 {
+		fields["seed"] = strconv.FormatInt(seed, 10)
+	}
+	if outputFormat != "" {
+		fields["output_format"] = string(outputFormat)
+	}
+	if stylePreset != "" {
+		fields["style_preset"] = string(stylePreset)
+	}
+}
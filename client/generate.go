@@ -0,0 +1,225 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// Generate API endpoints
+const (
+	GenerateCorePath  = "/v2beta/stable-image/generate/core"
+	GenerateUltraPath = "/v2beta/stable-image/generate/ultra"
+	GenerateSD3Path   = "/v2beta/stable-image/generate/sd3"
+)
+
+// GenerateType represents the available text-to-image services
+type GenerateType string
+
+const (
+	GenerateTypeCore  GenerateType = "core"
+	GenerateTypeUltra GenerateType = "ultra"
+	GenerateTypeSD3   GenerateType = "sd3"
+)
+
+// SD3Model defines the available models for the SD3 endpoint
+type SD3Model string
+
+const (
+	SD3ModelLarge      SD3Model = "sd3.5-large"
+	SD3ModelLargeTurbo SD3Model = "sd3.5-large-turbo"
+	SD3ModelMedium     SD3Model = "sd3.5-medium"
+)
+
+// AspectRatio defines the available aspect ratios for generated images
+type AspectRatio string
+
+const (
+	AspectRatio16x9 AspectRatio = "16:9"
+	AspectRatio1x1  AspectRatio = "1:1"
+	AspectRatio21x9 AspectRatio = "21:9"
+	AspectRatio2x3  AspectRatio = "2:3"
+	AspectRatio3x2  AspectRatio = "3:2"
+	AspectRatio4x5  AspectRatio = "4:5"
+	AspectRatio5x4  AspectRatio = "5:4"
+	AspectRatio9x16 AspectRatio = "9:16"
+	AspectRatio9x21 AspectRatio = "9:21"
+)
+
+// Finish reasons returned by the image endpoints
+const (
+	FinishReasonSuccess         = "SUCCESS"
+	FinishReasonContentFiltered = "CONTENT_FILTERED"
+)
+
+// GenerateRequest represents the parameters for a text-to-image request
+type GenerateRequest struct {
+	// The generation service to use
+	Type GenerateType
+	// The prompt describing the image to generate
+	Prompt string
+	// Optional negative prompt
+	NegativePrompt string
+	// Aspect ratio of the generated image (defaults to 1:1)
+	AspectRatio AspectRatio
+	// Optional seed value
+	Seed int64
+	// Style preset (only for core)
+	StylePreset StylePreset
+	// Output format (jpeg, png, webp)
+	OutputFormat OutputFormat
+	// Model to use (only for sd3)
+	Model SD3Model
+	// Whether to return image as base64 JSON instead of binary
+	ReturnAsJSON bool
+}
+
+// GenerateResponse represents the response from the generate API
+type GenerateResponse struct {
+	// The generated image data
+	ImageData []byte
+	// The mime type of the image (e.g., "image/png")
+	MimeType string
+	// The seed used to generate the image
+	Seed int64
+	// The finish reason reported by the API (SUCCESS or CONTENT_FILTERED)
+	FinishReason string
+}
+
+// Validate checks the request against the documented limits of the generate endpoint for its type
+func (r GenerateRequest) Validate() error {
+	var v validator
+
+	switch r.Type {
+	case GenerateTypeCore, GenerateTypeUltra, GenerateTypeSD3:
+	default:
+		v.add("type", "invalid generate type %q", r.Type)
+	}
+
+	v.prompt("prompt", r.Prompt, true)
+	v.prompt("negative_prompt", r.NegativePrompt, false)
+	// SD3 Large Turbo does not support negative prompts
+	if r.NegativePrompt != "" && r.Model == SD3ModelLargeTurbo {
+		v.add("negative_prompt", "is not supported by %s", r.Model)
+	}
+
+	v.aspectRatio(r.AspectRatio)
+	v.seed(r.Seed)
+
+	// SD3 does not support webp output
+	if r.Type == GenerateTypeSD3 {
+		v.outputFormat(r.OutputFormat, OutputFormatJPEG, OutputFormatPNG)
+	} else {
+		v.outputFormat(r.OutputFormat)
+	}
+
+	// Style preset is only for core and model is only for sd3
+	if r.StylePreset != "" && r.Type != GenerateTypeCore {
+		v.add("style_preset", "is only supported for core generation")
+	}
+	if r.Model != "" && r.Type != GenerateTypeSD3 {
+		v.add("model", "is only supported for sd3 generation")
+	}
+
+	return v.err("generate")
+}
+
+// Generate generates an image from a text prompt using the specified parameters
+func (c *Client) Generate(ctx context.Context, request GenerateRequest) (*GenerateResponse, error) {
+	if err := request.Validate(); err != nil {
+		return nil, err
+	}
+
+	var endpoint string
+
+	// Determine the endpoint based on generate type
+	switch request.Type {
+	case GenerateTypeCore:
+		endpoint = GenerateCorePath
+	case GenerateTypeUltra:
+		endpoint = GenerateUltraPath
+	case GenerateTypeSD3:
+		endpoint = GenerateSD3Path
+	default:
+		return nil, fmt.Errorf("invalid generate type: %s", request.Type)
+	}
+
+	// Create form fields
+	fields := map[string]string{
+		"prompt": request.Prompt,
+	}
+
+	if request.NegativePrompt != "" {
+		fields["negative_prompt"] = request.NegativePrompt
+	}
+
+	if request.AspectRatio != "" {
+		fields["aspect_ratio"] = string(request.AspectRatio)
+	}
+
+	if request.Seed > 0 {
+		fields["seed"] = strconv.FormatInt(request.Seed, 10)
+	}
+
+	if request.OutputFormat != "" {
+		fields["output_format"] = string(request.OutputFormat)
+	}
+
+	// Style preset is only for core
+	if request.StylePreset != "" {
+		fields["style_preset"] = string(request.StylePreset)
+	}
+
+	// Model is only for sd3
+	if request.Model != "" {
+		fields["model"] = string(request.Model)
+	}
+
+	// Set the accept header based on whether we want JSON or binary response
+	accept := "image/*"
+	if request.ReturnAsJSON {
+		accept = "application/json"
+	}
+
+	// Send the request (the generate endpoints take multipart form data without a file part)
+	resp, err := c.send(ctx, Operation{
+		Path:   endpoint,
+		Accept: accept,
+		Fields: fields,
+		Name:   "generate",
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	result, err := readImageResult(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GenerateResponse{
+		ImageData:    result.Data,
+		MimeType:     result.MimeType,
+		Seed:         result.Seed,
+		FinishReason: result.FinishReason,
+	}, nil
+}
+
+// GenerateCore generates an image using Stable Image Core
+func (c *Client) GenerateCore(ctx context.Context, request GenerateRequest) (*GenerateResponse, error) {
+	request.Type = GenerateTypeCore
+	return c.Generate(ctx, request)
+}
+
+// GenerateUltra generates an image using Stable Image Ultra
+func (c *Client) GenerateUltra(ctx context.Context, request GenerateRequest) (*GenerateResponse, error) {
+	request.Type = GenerateTypeUltra
+	return c.Generate(ctx, request)
+}
+
+// GenerateSD3 generates an image using Stable Diffusion 3.5
+func (c *Client) GenerateSD3(ctx context.Context, request GenerateRequest) (*GenerateResponse, error) {
+	request.Type = GenerateTypeSD3
+	return c.Generate(ctx, request)
+}
@@ -0,0 +1,294 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Default key pool settings
+const (
+	DefaultKeyRateLimitBench = time.Minute
+	DefaultKeyFailureBench   = 15 * time.Minute
+	// How long a key is remembered for the asynchronous jobs it started (results expire after 24 hours)
+	keyJobTTL = 24 * time.Hour
+)
+
+// KeyStrategy decides which key of a KeyPool is used for the next request
+type KeyStrategy string
+
+const (
+	// KeyStrategyRoundRobin cycles through the available keys
+	KeyStrategyRoundRobin KeyStrategy = "round-robin"
+	// KeyStrategyLeastRateLimited prefers the key that was rate limited longest ago
+	KeyStrategyLeastRateLimited KeyStrategy = "least-rate-limited"
+	// KeyStrategyFailover uses the keys in order, moving on only when the earlier ones are benched
+	KeyStrategyFailover KeyStrategy = "failover"
+)
+
+// ParseKeyStrategy parses a key strategy name, defaulting to round-robin for an empty name
+func ParseKeyStrategy(name string) (KeyStrategy, error) {
+	switch strategy := KeyStrategy(name); strategy {
+	case "":
+		return KeyStrategyRoundRobin, nil
+	case KeyStrategyRoundRobin, KeyStrategyLeastRateLimited, KeyStrategyFailover:
+		return strategy, nil
+	default:
+		return "", fmt.Errorf("unknown key strategy %q", name)
+	}
+}
+
+// KeyPoolConfig configures a KeyPool. Zero values are replaced by the defaults.
+type KeyPoolConfig struct {
+	// How the key for each request is chosen (defaults to round-robin)
+	Strategy KeyStrategy
+	// How long a rate limited key is benched when the API doesn't send a Retry-After header
+	RateLimitBench time.Duration
+	// How long a key that was rejected (401) or ran out of credits (402) is benched
+	FailureBench time.Duration
+}
+
+// KeyStats describes the health of one key of a KeyPool without revealing the key
+type KeyStats struct {
+	// Position of the key in the pool (e.g., "key-1")
+	Name string
+	// Short SHA-256 fingerprint of the key, to tell keys apart across restarts
+	Fingerprint string
+	// Whether the key is currently used for new requests
+	Healthy bool
+	// When a benched key is used again
+	BenchedUntil time.Time
+	// Why the key was last benched (e.g., "rate limited" or "insufficient credits")
+	BenchReason string
+	// Number of requests sent with the key
+	Requests int
+	// Number of requests rejected with 429
+	RateLimits int
+	// Number of requests rejected with 401 or 402
+	Failures int
+	// When the key was last rate limited
+	LastRateLimited time.Time
+}
+
+// poolKey is a key of a KeyPool along with its health
+type poolKey struct {
+	key             string
+	benchedUntil    time.Time
+	benchReason     string
+	requests        int
+	rateLimits      int
+	failures        int
+	lastRateLimited time.Time
+}
+
+// poolJob remembers which key started an asynchronous job
+type poolJob struct {
+	index   int
+	expires time.Time
+}
+
+// KeyPool spreads requests over several Stability API keys and benches keys that hit limits.
+// Asynchronous jobs are always polled with the key that started them, since results are private to an account.
+type KeyPool struct {
+	config KeyPoolConfig
+
+	mutex sync.Mutex
+	keys  []*poolKey
+	next  int
+	jobs  map[string]poolJob
+}
+
+// NewKeyPool creates a key pool from the given keys
+func NewKeyPool(keys []string, config KeyPoolConfig) *KeyPool {
+	if config.Strategy == "" {
+		config.Strategy = KeyStrategyRoundRobin
+	}
+	if config.RateLimitBench <= 0 {
+		config.RateLimitBench = DefaultKeyRateLimitBench
+	}
+	if config.FailureBench <= 0 {
+		config.FailureBench = DefaultKeyFailureBench
+	}
+
+	poolKeys := make([]*poolKey, 0, len(keys))
+	for _, key := range keys {
+		poolKeys = append(poolKeys, &poolKey{key: key})
+	}
+
+	return &KeyPool{
+		config: config,
+		keys:   poolKeys,
+		jobs:   make(map[string]poolJob),
+	}
+}
+
+// Len returns the number of keys in the pool
+func (p *KeyPool) Len() int {
+	return len(p.keys)
+}
+
+// key returns the key at index
+func (p *KeyPool) key(index int) string {
+	return p.keys[index].key
+}
+
+// Stats returns the health of every key in the pool
+func (p *KeyPool) Stats() []KeyStats {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := time.Now()
+	stats := make([]KeyStats, 0, len(p.keys))
+	for i, k := range p.keys {
+		fingerprint := sha256.Sum256([]byte(k.key))
+		s := KeyStats{
+			Name:            fmt.Sprintf("key-%d", i+1),
+			Fingerprint:     hex.EncodeToString(fingerprint[:4]),
+			Healthy:         !now.Before(k.benchedUntil),
+			Requests:        k.requests,
+			RateLimits:      k.rateLimits,
+			Failures:        k.failures,
+			LastRateLimited: k.lastRateLimited,
+		}
+		if !s.Healthy {
+			s.BenchedUntil = k.benchedUntil
+			s.BenchReason = k.benchReason
+		}
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+// acquire picks the key for a request, skipping the keys in tried.
+// When every remaining key is benched, the one that comes off the bench first is used.
+func (p *KeyPool) acquire(tried map[int]bool) (int, string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := time.Now()
+	best := -1
+	for n := 0; n < len(p.keys); n++ {
+		i := n
+		if p.config.Strategy != KeyStrategyFailover {
+			i = (p.next + n) % len(p.keys)
+		}
+		if tried[i] {
+			continue
+		}
+
+		k := p.keys[i]
+		switch {
+		case best == -1:
+			best = i
+		case now.Before(p.keys[best].benchedUntil):
+			// Prefer any key over a benched one, and the benched key that comes back first
+			if !now.Before(k.benchedUntil) || k.benchedUntil.Before(p.keys[best].benchedUntil) {
+				best = i
+			}
+		case p.config.Strategy == KeyStrategyLeastRateLimited && !now.Before(k.benchedUntil):
+			if k.lastRateLimited.Before(p.keys[best].lastRateLimited) {
+				best = i
+			}
+		}
+	}
+	if best == -1 {
+		return -1, ""
+	}
+
+	p.next = (best + 1) % len(p.keys)
+	p.keys[best].requests++
+	return best, p.keys[best].key
+}
+
+// keyFailoverKey marks the context of a request the key pool resends with another key if its key is rejected
+type keyFailoverKey struct{}
+
+// withKeyFailover marks a request the key pool fails over to another key when it is rate limited, unauthorized
+// or out of credits, so the retry middleware returns those responses at once instead of retrying the same key
+func withKeyFailover(ctx context.Context) context.Context {
+	return context.WithValue(ctx, keyFailoverKey{}, true)
+}
+
+// failsOverKey reports whether resp rejected the key of a request the key pool will resend with another key
+func failsOverKey(req *http.Request, resp *http.Response) bool {
+	if resp == nil || req.Context().Value(keyFailoverKey{}) == nil {
+		return false
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusUnauthorized, http.StatusPaymentRequired:
+		return true
+	}
+	return false
+}
+
+// report records the response to a request sent with the key at index,
+// returning whether the request should be retried with another key
+func (p *KeyPool) report(index int, resp *http.Response, retryAfter time.Duration) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	k := p.keys[index]
+	now := time.Now()
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		bench := retryAfter
+		if bench <= 0 {
+			bench = p.config.RateLimitBench
+		}
+		k.rateLimits++
+		k.lastRateLimited = now
+		k.bench(now.Add(bench), "rate limited")
+		return true
+	case http.StatusUnauthorized:
+		k.failures++
+		k.bench(now.Add(p.config.FailureBench), "unauthorized")
+		return true
+	case http.StatusPaymentRequired:
+		k.failures++
+		k.bench(now.Add(p.config.FailureBench), "insufficient credits")
+		return true
+	}
+	return false
+}
+
+// bench stops using the key until the given time
+func (k *poolKey) bench(until time.Time, reason string) {
+	if until.After(k.benchedUntil) {
+		k.benchedUntil = until
+	}
+	k.benchReason = reason
+}
+
+// bindJob remembers that the asynchronous job with the given ID was started with the key at index
+func (p *KeyPool) bindJob(id string, index int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	// Forget expired jobs
+	now := time.Now()
+	for jobID, job := range p.jobs {
+		if now.After(job.expires) {
+			delete(p.jobs, jobID)
+		}
+	}
+
+	p.jobs[id] = poolJob{index: index, expires: now.Add(keyJobTTL)}
+}
+
+// jobKey returns the key that started the asynchronous job with the given ID, if known
+func (p *KeyPool) jobKey(id string) (int, string, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	job, ok := p.jobs[id]
+	if !ok || time.Now().After(job.expires) {
+		return -1, "", false
+	}
+
+	p.keys[job.index].requests++
+	return job.index, p.keys[job.index].key, true
+}
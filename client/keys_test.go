@@ -0,0 +1,200 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// acquireKeys acquires n keys without skipping any, returning them in order
+func acquireKeys(p *KeyPool, n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		_, keys[i] = p.acquire(nil)
+	}
+	return keys
+}
+
+// equalKeys reports whether two key sequences are equal
+func equalKeys(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestKeyPoolRoundRobin(t *testing.T) {
+	p := NewKeyPool([]string{"a", "b", "c"}, KeyPoolConfig{})
+
+	want := []string{"a", "b", "c", "a", "b", "c"}
+	if got := acquireKeys(p, 6); !equalKeys(got, want) {
+		t.Errorf("keys = %v, want %v", got, want)
+	}
+	for _, s := range p.Stats() {
+		if s.Requests != 2 || !s.Healthy {
+			t.Errorf("%s: %+v, want 2 requests and healthy", s.Name, s)
+		}
+	}
+}
+
+func TestKeyPoolFailoverStrategy(t *testing.T) {
+	p := NewKeyPool([]string{"a", "b", "c"}, KeyPoolConfig{Strategy: KeyStrategyFailover})
+
+	if got := acquireKeys(p, 3); !equalKeys(got, []string{"a", "a", "a"}) {
+		t.Errorf("keys = %v, want the first key every time", got)
+	}
+
+	p.report(0, &http.Response{StatusCode: http.StatusPaymentRequired}, 0)
+	if got := acquireKeys(p, 2); !equalKeys(got, []string{"b", "b"}) {
+		t.Errorf("keys after benching the first = %v, want the second key every time", got)
+	}
+}
+
+func TestKeyPoolLeastRateLimited(t *testing.T) {
+	p := NewKeyPool([]string{"a", "b", "c"}, KeyPoolConfig{Strategy: KeyStrategyLeastRateLimited})
+
+	// Keys rate limited a while ago are healthy again, but the one never rate limited is preferred
+	now := time.Now()
+	p.keys[0].lastRateLimited = now.Add(-time.Hour)
+	p.keys[2].lastRateLimited = now.Add(-2 * time.Hour)
+
+	if _, key := p.acquire(nil); key != "b" {
+		t.Errorf("key = %s, want b, which was never rate limited", key)
+	}
+	if _, key := p.acquire(map[int]bool{1: true}); key != "c" {
+		t.Errorf("key = %s, want c, which was rate limited longest ago", key)
+	}
+}
+
+func TestKeyPoolReport(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		retryAfter time.Duration
+		failover   bool
+		bench      time.Duration
+		reason     string
+	}{
+		{"rate limited", http.StatusTooManyRequests, 0, true, time.Minute, "rate limited"},
+		{"rate limited with Retry-After", http.StatusTooManyRequests, 10 * time.Second, true, 10 * time.Second, "rate limited"},
+		{"unauthorized", http.StatusUnauthorized, 0, true, time.Hour, "unauthorized"},
+		{"out of credits", http.StatusPaymentRequired, 0, true, time.Hour, "insufficient credits"},
+		{"success", http.StatusOK, 0, false, 0, ""},
+		{"server error", http.StatusInternalServerError, 0, false, 0, ""},
+		{"bad request", http.StatusBadRequest, 0, false, 0, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewKeyPool([]string{"a", "b"}, KeyPoolConfig{RateLimitBench: time.Minute, FailureBench: time.Hour})
+
+			start := time.Now()
+			if failover := p.report(0, &http.Response{StatusCode: tt.status}, tt.retryAfter); failover != tt.failover {
+				t.Errorf("report = %v, want %v", failover, tt.failover)
+			}
+
+			s := p.Stats()[0]
+			if tt.bench == 0 {
+				if !s.Healthy {
+					t.Errorf("key benched after a %d: %+v", tt.status, s)
+				}
+				return
+			}
+			if s.Healthy || s.BenchReason != tt.reason {
+				t.Errorf("stats = %+v, want benched as %q", s, tt.reason)
+			}
+			if until := s.BenchedUntil.Sub(start); until < tt.bench || until > tt.bench+time.Second {
+				t.Errorf("benched for %v, want %v", until, tt.bench)
+			}
+
+			// Benched keys are skipped
+			if got := acquireKeys(p, 2); !equalKeys(got, []string{"b", "b"}) {
+				t.Errorf("keys = %v, want only the healthy key", got)
+			}
+		})
+	}
+}
+
+func TestKeyPoolAcquireSkipsTriedKeys(t *testing.T) {
+	p := NewKeyPool([]string{"a", "b", "c"}, KeyPoolConfig{})
+	tried := map[int]bool{}
+
+	// A request failing over tries every key once
+	var keys []string
+	for range p.keys {
+		index, key := p.acquire(tried)
+		tried[index] = true
+		keys = append(keys, key)
+	}
+	if !equalKeys(keys, []string{"a", "b", "c"}) {
+		t.Errorf("keys = %v, want every key once", keys)
+	}
+	if index, _ := p.acquire(tried); index != -1 {
+		t.Errorf("acquire with every key tried = %d, want -1", index)
+	}
+}
+
+func TestKeyPoolAllBenched(t *testing.T) {
+	p := NewKeyPool([]string{"a", "b", "c"}, KeyPoolConfig{})
+	p.report(0, &http.Response{StatusCode: http.StatusTooManyRequests}, time.Hour)
+	p.report(1, &http.Response{StatusCode: http.StatusTooManyRequests}, time.Minute)
+	p.report(2, &http.Response{StatusCode: http.StatusTooManyRequests}, 2*time.Hour)
+
+	// The key that comes off the bench first is used
+	if _, key := p.acquire(nil); key != "b" {
+		t.Errorf("key = %s, want b, which is benched the shortest", key)
+	}
+}
+
+func TestKeyPoolJobs(t *testing.T) {
+	p := NewKeyPool([]string{"a", "b", "c"}, KeyPoolConfig{})
+	p.bindJob("job-1", 2)
+
+	index, key, ok := p.jobKey("job-1")
+	if !ok || index != 2 || key != "c" {
+		t.Errorf("jobKey(job-1) = %d, %q, %v, want the key that started it", index, key, ok)
+	}
+	if _, _, ok := p.jobKey("job-2"); ok {
+		t.Error("jobKey found a key for an unknown job")
+	}
+	if requests := p.Stats()[2].Requests; requests != 1 {
+		t.Errorf("requests of the job's key = %d, want the poll counted", requests)
+	}
+
+	// Expired jobs are forgotten, and pruned when the next job is bound
+	p.jobs["job-1"] = poolJob{index: 2, expires: time.Now().Add(-time.Second)}
+	if _, _, ok := p.jobKey("job-1"); ok {
+		t.Error("jobKey found a key for an expired job")
+	}
+	p.bindJob("job-3", 0)
+	if _, ok := p.jobs["job-1"]; ok || len(p.jobs) != 1 {
+		t.Errorf("jobs = %v, want only job-3", p.jobs)
+	}
+}
+
+func TestFailsOverKey(t *testing.T) {
+	marked, _ := http.NewRequestWithContext(withKeyFailover(context.Background()), http.MethodPost, "https://api.stability.ai", nil)
+	unmarked, _ := http.NewRequest(http.MethodPost, "https://api.stability.ai", nil)
+
+	for _, status := range []int{http.StatusTooManyRequests, http.StatusUnauthorized, http.StatusPaymentRequired} {
+		resp := &http.Response{StatusCode: status}
+		if !failsOverKey(marked, resp) {
+			t.Errorf("%d on a pooled request isn't left to the key pool", status)
+		}
+		if failsOverKey(unmarked, resp) {
+			t.Errorf("%d on a request without a pool is left to the key pool", status)
+		}
+	}
+	if failsOverKey(marked, &http.Response{StatusCode: http.StatusInternalServerError}) {
+		t.Error("500 on a pooled request is left to the key pool")
+	}
+	if failsOverKey(marked, nil) {
+		t.Error("transport error on a pooled request is left to the key pool")
+	}
+}
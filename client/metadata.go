@@ -0,0 +1,84 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	apierrors "github.com/marcusziade/stability-go/errors"
+)
+
+// Metadata describes how the Stability API produced a response.
+// Keep the seed to reproduce a result and the request ID to report problems to Stability AI support.
+type Metadata struct {
+	// The seed used to produce the result (0 if the API didn't report one)
+	Seed int64
+	// The finish reason reported by the API (SUCCESS or CONTENT_FILTERED)
+	FinishReason string
+	// The request ID reported in the response headers
+	RequestID string
+	// The ID of the error reported in the response body (only set for failed requests)
+	ErrorID string
+	// How long the request took, including retries and, for asynchronous jobs waited for, polling
+	Duration time.Duration
+	// How many attempts were made to send the request (more than 1 when the retry middleware retried it)
+	Attempts int
+	// Credits charged for the request according to UpscaleCredits (0 for failed requests)
+	Credits float64
+}
+
+// MarshalJSON encodes the metadata with the duration in milliseconds
+func (m Metadata) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Seed         int64   `json:"seed,omitempty"`
+		FinishReason string  `json:"finish_reason,omitempty"`
+		RequestID    string  `json:"request_id,omitempty"`
+		ErrorID      string  `json:"error_id,omitempty"`
+		DurationMS   int64   `json:"duration_ms"`
+		Attempts     int     `json:"attempts,omitempty"`
+		Credits      float64 `json:"credits"`
+	}{
+		Seed:         m.Seed,
+		FinishReason: m.FinishReason,
+		RequestID:    m.RequestID,
+		ErrorID:      m.ErrorID,
+		DurationMS:   m.Duration.Milliseconds(),
+		Attempts:     m.Attempts,
+		Credits:      m.Credits,
+	})
+}
+
+// ErrorMetadata returns the metadata of a request that failed with an API error or a result blurred by the
+// content filter, or nil if err is neither
+func ErrorMetadata(err error) *Metadata {
+	var filteredErr *apierrors.ContentFilteredError
+	if errors.As(err, &filteredErr) {
+		return &Metadata{
+			Seed:         filteredErr.Seed,
+			FinishReason: FinishReasonContentFiltered,
+			RequestID:    filteredErr.RequestID,
+		}
+	}
+
+	apiErr, ok := apierrors.AsAPIError(err)
+	if !ok {
+		return nil
+	}
+	return &Metadata{
+		RequestID: apiErr.RequestID,
+		ErrorID:   apiErr.ID,
+		Attempts:  max(apiErr.Attempts, 1),
+	}
+}
+
+// responseMetadata returns the metadata reported in the headers of a response to a request sent at start
+func responseMetadata(resp *http.Response, start time.Time) *Metadata {
+	attempts, _ := strconv.Atoi(resp.Header.Get(RetryAttemptsHeader))
+	return &Metadata{
+		RequestID: apierrors.ParseRequestID(resp.Header),
+		Duration:  time.Since(start),
+		Attempts:  max(attempts, 1),
+	}
+}
@@ -2,46 +2,119 @@ package client
 
 import (
 	"bytes"
-	"context"
 	"io"
 	"math/rand"
 	"net/http"
-	"sync"
+	"strconv"
 	"time"
+
+	apierrors "github.com/marcusziade/stability-go/errors"
+	"github.com/marcusziade/stability-go/internal/utils"
+	"github.com/marcusziade/stability-go/telemetry"
 )
 
-// RateLimitMiddleware is a middleware for handling rate limiting
+// Middleware decorates an http.RoundTripper with additional behaviour.
+// Implementations must call next to send the request on.
+type Middleware func(next http.RoundTripper) http.RoundTripper
+
+// Chain wraps base with the given middleware. The first middleware is the outermost,
+// so it sees each request first and each response last. A nil base uses http.DefaultTransport.
+func Chain(base http.RoundTripper, middleware ...Middleware) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	transport := base
+	for i := len(middleware) - 1; i >= 0; i-- {
+		transport = middleware[i](transport)
+	}
+	return transport
+}
+
+// RateLimit returns a middleware that enforces a minimum interval between requests
+func RateLimit(minInterval time.Duration) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return NewRateLimitMiddleware(minInterval, next)
+	}
+}
+
+// RateLimitWithConfig returns a middleware that enforces token bucket budgets with bursts and per-endpoint limits
+func RateLimitWithConfig(config RateLimitConfig) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return NewRateLimitMiddlewareWithConfig(config, next)
+	}
+}
+
+// Retry returns a middleware that retries failed requests with exponential backoff
+func Retry(maxRetries int, baseDelay, maxDelay time.Duration) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return NewRetryMiddleware(maxRetries, baseDelay, maxDelay, next)
+	}
+}
+
+// RetryWithConfig returns a middleware that retries failed requests according to a retry policy and budget
+func RetryWithConfig(config RetryConfig) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return NewRetryMiddlewareWithConfig(config, next)
+	}
+}
+
+// RateLimitMiddleware is a middleware for handling rate limiting with token buckets.
+// Each endpoint class with its own budget gets its own bucket, so polling isn't queued behind slow submissions.
 type RateLimitMiddleware struct {
-	mutex       sync.Mutex
-	lastRequest time.Time
-	minInterval time.Duration
+	fallback *TokenBucket
+	buckets  map[EndpointClass]*TokenBucket
+	next     http.RoundTripper
+}
+
+// NewRateLimitMiddleware creates a new rate limit middleware that allows one request per minInterval across all endpoints
+func NewRateLimitMiddleware(minInterval time.Duration, next http.RoundTripper) *RateLimitMiddleware {
+	return NewRateLimitMiddlewareWithConfig(RateLimitConfig{
+		Default: RateLimitBudget{Interval: minInterval, Burst: 1},
+	}, next)
 }
 
-// NewRateLimitMiddleware creates a new rate limit middleware
-func NewRateLimitMiddleware(minInterval time.Duration) *RateLimitMiddleware {
+// NewRateLimitMiddlewareWithConfig creates a new rate limit middleware with burst sizes and per-endpoint budgets
+func NewRateLimitMiddlewareWithConfig(config RateLimitConfig, next http.RoundTripper) *RateLimitMiddleware {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	buckets := make(map[EndpointClass]*TokenBucket, len(config.Endpoints))
+	for class, budget := range config.Endpoints {
+		buckets[class] = NewTokenBucket(budget.Interval, budget.Burst)
+	}
+
 	return &RateLimitMiddleware{
-		minInterval: minInterval,
+		fallback: NewTokenBucket(config.Default.Interval, config.Default.Burst),
+		buckets:  buckets,
+		next:     next,
 	}
 }
 
 // RoundTrip implements the http.RoundTripper interface
 func (m *RateLimitMiddleware) RoundTrip(req *http.Request) (*http.Response, error) {
-	m.mutex.Lock()
-
-	// Calculate time since last request
-	elapsed := time.Since(m.lastRequest)
+	ctx, span := telemetry.StartSpan(req.Context(), "stability.middleware.rate_limit")
 
-	// If we need to wait, do so
-	if elapsed < m.minInterval {
-		time.Sleep(m.minInterval - elapsed)
+	bucket, ok := m.buckets[ClassifyEndpoint(req.URL.Path)]
+	if !ok {
+		bucket = m.fallback
 	}
 
-	// Update last request time
-	m.lastRequest = time.Now()
-	m.mutex.Unlock()
+	// Wait for a token, giving up if the request is cancelled
+	start := time.Now()
+	if err := bucket.Wait(ctx); err != nil {
+		closeBody(req)
+		endSpan(span, nil, err)
+		return nil, err
+	}
+	span.SetAttributes(telemetry.Int64(telemetry.AttrRateLimitWait, time.Since(start).Milliseconds()))
+	telemetry.RecordDuration(ctx, telemetry.MetricRateLimitWait, start)
 
 	// Continue with the request
-	return http.DefaultTransport.RoundTrip(req)
+	resp, err := m.next.RoundTrip(req.WithContext(ctx))
+	endSpan(span, resp, err)
+	return resp, err
 }
 
 // RetryMiddleware is a middleware for handling retries
@@ -49,188 +122,186 @@ type RetryMiddleware struct {
 	maxRetries int
 	baseDelay  time.Duration
 	maxDelay   time.Duration
-	transport  http.RoundTripper
+	policy     RetryPolicy
+	budget     *RetryBudget
+	next       http.RoundTripper
+}
+
+// RetryConfig configures the retry middleware
+type RetryConfig struct {
+	// Maximum number of retries after the first attempt
+	MaxRetries int
+	// Delay before the first retry, doubled for every further retry
+	BaseDelay time.Duration
+	// Upper bound for the backoff delay (a longer Retry-After from the API is still honoured)
+	MaxDelay time.Duration
+	// Which failures are retried (defaults to DefaultRetryPolicy)
+	Policy *RetryPolicy
+	// Optional budget shared across requests that caps the overall retry rate
+	Budget *RetryBudget
 }
 
-// NewRetryMiddleware creates a new retry middleware
-func NewRetryMiddleware(maxRetries int, baseDelay, maxDelay time.Duration, transport http.RoundTripper) *RetryMiddleware {
-	if transport == nil {
-		transport = http.DefaultTransport
+// NewRetryMiddleware creates a new retry middleware using the default retry policy
+func NewRetryMiddleware(maxRetries int, baseDelay, maxDelay time.Duration, next http.RoundTripper) *RetryMiddleware {
+	return NewRetryMiddlewareWithConfig(RetryConfig{
+		MaxRetries: maxRetries,
+		BaseDelay:  baseDelay,
+		MaxDelay:   maxDelay,
+	}, next)
+}
+
+// NewRetryMiddlewareWithConfig creates a new retry middleware with a custom policy and retry budget
+func NewRetryMiddlewareWithConfig(config RetryConfig, next http.RoundTripper) *RetryMiddleware {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	policy := DefaultRetryPolicy()
+	if config.Policy != nil {
+		policy = *config.Policy
 	}
 
 	return &RetryMiddleware{
-		maxRetries: maxRetries,
-		baseDelay:  baseDelay,
-		maxDelay:   maxDelay,
-		transport:  transport,
+		maxRetries: config.MaxRetries,
+		baseDelay:  config.BaseDelay,
+		maxDelay:   config.MaxDelay,
+		policy:     policy,
+		budget:     config.Budget,
+		next:       next,
 	}
 }
 
-// RoundTrip implements the http.RoundTripper interface
+// RoundTrip implements the http.RoundTripper interface.
+// The final response carries the number of attempts in the RetryAttemptsHeader header;
+// a final transport error is returned as an *errors.RetryError.
 func (m *RetryMiddleware) RoundTrip(req *http.Request) (*http.Response, error) {
-	var resp *http.Response
-	var err error
+	ctx, span := telemetry.StartSpan(req.Context(), "stability.middleware.retry")
+	resp, err := m.roundTrip(req.WithContext(ctx))
+	endSpan(span, resp, err)
+	return resp, err
+}
 
-	// Make a copy of the request body
-	var bodyBytes []byte
-	if req.Body != nil {
-		bodyBytes, err = readAndReplaceBody(req)
+// roundTrip sends the request, retrying failures allowed by the policy and the budget
+func (m *RetryMiddleware) roundTrip(req *http.Request) (*http.Response, error) {
+	// Every attempt needs a fresh body. Requests built by the client reopen theirs with GetBody,
+	// so only bodies that can't be reopened are copied into memory.
+	getBody := req.GetBody
+	if getBody == nil && req.Body != nil && req.Body != http.NoBody {
+		bodyBytes, err := readAndReplaceBody(req)
 		if err != nil {
 			return nil, err
 		}
+		getBody = func() (io.ReadCloser, error) {
+			return createReadCloser(bodyBytes), nil
+		}
 	}
 
-	// Try the request up to maxRetries times
-	for attempt := 0; attempt <= m.maxRetries; attempt++ {
-		// If this is a retry, restore the request body
-		if attempt > 0 && bodyBytes != nil {
-			req.Body = createReadCloser(bodyBytes)
-		}
+	if m.budget != nil {
+		m.budget.deposit()
+	}
+
+	body := req.Body
+	for attempt := 1; ; attempt++ {
+		// RoundTrippers must not modify the caller's request, so send a copy with a fresh body
+		attemptReq := req.Clone(req.Context())
+		attemptReq.Body = body
 
 		// Make the request
-		resp, err = m.transport.RoundTrip(req)
+		resp, err := m.next.RoundTrip(attemptReq)
 
-		// If there's no error and response is successful, return it
-		if err == nil && (resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests) {
-			return resp, nil
+		retry := attempt <= m.maxRetries && !failsOverKey(req, resp) && m.policy.ShouldRetry(req, resp, err)
+		if retry && m.budget != nil {
+			retry = m.budget.withdraw()
 		}
-
-		// If this was the last attempt, return the error
-		if attempt == m.maxRetries {
+		if retry && getBody != nil {
+			// A body that can't be sent again ends the retries with the last result
+			var bodyErr error
+			if body, bodyErr = getBody(); bodyErr != nil {
+				retry = false
+			}
+		}
+		if !retry {
 			if err != nil {
-				return nil, err
+				return nil, &apierrors.RetryError{Attempts: attempt, Err: err}
 			}
+			resp.Header.Set(RetryAttemptsHeader, strconv.Itoa(attempt))
 			return resp, nil
 		}
 
-		// Close the response body if we're going to retry
-		if resp != nil && resp.Body != nil {
-			resp.Body.Close()
+		// Calculate delay using exponential backoff (2^(attempt-1) * baseDelay) with ±20% jitter,
+		// but never retry sooner than the API asked us to
+		delay := m.backoff(attempt)
+		if resp != nil {
+			if retryAfter := utils.ParseRetryAfter(resp.Header.Get("Retry-After")); retryAfter > delay {
+				delay = retryAfter
+			}
 		}
 
-		// Calculate delay using exponential backoff (2^attempt * baseDelay)
-		delay := m.baseDelay * (1 << uint(attempt))
-		if delay > m.maxDelay {
-			delay = m.maxDelay
+		// Close the response body since we're going to retry
+		if resp != nil && resp.Body != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+			resp.Body.Close()
 		}
 
-		// Add jitter (±20%)
-		delay = addJitter(delay, 0.2)
+		telemetry.Add(req.Context(), telemetry.MetricClientRetries, 1)
 
-		// Create a new context for the sleep
 		timer := time.NewTimer(delay)
 		select {
 		case <-req.Context().Done():
 			timer.Stop()
-			return nil, req.Context().Err()
+			// The body reopened for the next attempt is never sent
+			if body != nil {
+				body.Close()
+			}
+			return nil, &apierrors.RetryError{Attempts: attempt, Err: req.Context().Err()}
 		case <-timer.C:
 			// Continue to the next attempt
 		}
 	}
-
-	// We should never reach here because of the return in the last attempt
-	return resp, err
-}
-
-// ProxyMiddleware is a middleware that proxies requests through a different URL
-type ProxyMiddleware struct {
-	proxyURL  string
-	transport http.RoundTripper
 }
 
-// NewProxyMiddleware creates a new proxy middleware
-func NewProxyMiddleware(proxyURL string, transport http.RoundTripper) *ProxyMiddleware {
-	if transport == nil {
-		transport = http.DefaultTransport
-	}
-
-	return &ProxyMiddleware{
-		proxyURL:  proxyURL,
-		transport: transport,
+// backoff returns the jittered exponential backoff delay after the given attempt
+func (m *RetryMiddleware) backoff(attempt int) time.Duration {
+	delay := m.baseDelay * (1 << uint(attempt-1))
+	if delay > m.maxDelay || delay <= 0 {
+		delay = m.maxDelay
 	}
+	return addJitter(delay, 0.2)
 }
 
-// RoundTrip implements the http.RoundTripper interface
-func (m *ProxyMiddleware) RoundTrip(req *http.Request) (*http.Response, error) {
-	// Replace the host with the proxy host
-	originalURL := req.URL.String()
-	req.URL.Scheme = "https"
-	req.URL.Host = m.proxyURL
-	req.URL.Path = "/proxy" + req.URL.Path
-
-	// Add the original URL as a query parameter
-	q := req.URL.Query()
-	q.Add("target", originalURL)
-	req.URL.RawQuery = q.Encode()
-
-	// Continue with the request
-	return m.transport.RoundTrip(req)
-}
-
-// MiddlewareClient is a client that uses middleware
+// MiddlewareClient is a client whose transport is wrapped in a middleware chain
 type MiddlewareClient struct {
 	*Client
-	middleware []http.RoundTripper
 }
 
-// NewMiddlewareClient creates a new middleware client
-func NewMiddlewareClient(apiKey string, middleware ...http.RoundTripper) *MiddlewareClient {
+// NewMiddlewareClient creates a new middleware client. The middleware chain is built once here;
+// the first middleware is the outermost and sees each request first.
+func NewMiddlewareClient(apiKey string, middleware ...Middleware) *MiddlewareClient {
 	return &MiddlewareClient{
-		Client:     NewClient(apiKey),
-		middleware: middleware,
-	}
-}
-
-// Upscale upscales an image using the middleware chain
-func (c *MiddlewareClient) Upscale(ctx context.Context, request UpscaleRequest) (*UpscaleResponse, error) {
-	// Create a chain of middleware
-	var transport http.RoundTripper = http.DefaultTransport
-	for i := len(c.middleware) - 1; i >= 0; i-- {
-		transport = c.middleware[i]
-	}
-
-	// Replace the HTTPClient's transport with our middleware chain
-	c.Client.HTTPClient.Transport = transport
-
-	// Call the regular Upscale method
-	return c.Client.Upscale(ctx, request)
-}
-
-// PollCreativeResult polls for the result of a creative upscale job using the middleware chain
-func (c *MiddlewareClient) PollCreativeResult(ctx context.Context, id string) (*UpscaleResponse, bool, error) {
-	// Create a chain of middleware
-	var transport http.RoundTripper = http.DefaultTransport
-	for i := len(c.middleware) - 1; i >= 0; i-- {
-		transport = c.middleware[i]
+		Client: NewClient(apiKey).WithMiddleware(middleware...),
 	}
-
-	// Replace the HTTPClient's transport with our middleware chain
-	c.Client.HTTPClient.Transport = transport
-
-	// Call the regular PollCreativeResult method
-	return c.Client.PollCreativeResult(ctx, id)
 }
 
 // GetClient returns the underlying Client
 func (c *MiddlewareClient) GetClient() *Client {
-	// Create a chain of middleware
-	var transport http.RoundTripper = http.DefaultTransport
-	for i := len(c.middleware) - 1; i >= 0; i-- {
-		transport = c.middleware[i]
-	}
-
-	// Replace the HTTPClient's transport with our middleware chain
-	c.Client.HTTPClient.Transport = transport
-
 	return c.Client
 }
 
 // Helper functions for middlewares
 
+// closeBody closes the body of a request rejected before it reaches the next RoundTripper, as the
+// http.RoundTripper contract requires, so the writer of a streamed body isn't left blocked
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
+
 // readAndReplaceBody reads the request body and replaces it
 func readAndReplaceBody(req *http.Request) ([]byte, error) {
 	bodyBytes, err := io.ReadAll(req.Body)
 	if err != nil {
+		req.Body.Close()
 		return nil, err
 	}
 
@@ -253,4 +324,3 @@ func addJitter(d time.Duration, factor float64) time.Duration {
 	jitter := float64(d) * (1 - factor + 2*factor*rand.Float64())
 	return time.Duration(jitter)
 }
-
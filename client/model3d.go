@@ -0,0 +1,207 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// 3D API endpoints
+const (
+	StableFast3DPath       = "/v2beta/3d/stable-fast-3d"
+	StablePointAware3DPath = "/v2beta/3d/stable-point-aware-3d"
+)
+
+// MimeTypeGLB is the mime type of the binary glTF models returned by the 3D endpoints
+const MimeTypeGLB = "model/gltf-binary"
+
+// RemeshAlgorithm defines the available remeshing algorithms for 3D models
+type RemeshAlgorithm string
+
+const (
+	RemeshNone     RemeshAlgorithm = "none"
+	RemeshTriangle RemeshAlgorithm = "triangle"
+	RemeshQuad     RemeshAlgorithm = "quad"
+)
+
+// TargetType defines how the target count of a SPAR3D model is interpreted
+type TargetType string
+
+const (
+	TargetTypeNone   TargetType = "none"
+	TargetTypeVertex TargetType = "vertex"
+	TargetTypeFace   TargetType = "face"
+)
+
+// StableFast3DRequest represents the parameters for a Stable Fast 3D request
+type StableFast3DRequest struct {
+	// The image to generate a model from (binary data)
+	Image []byte
+	// The filename of the image
+	Filename string
+	// Texture resolution in pixels (512, 1024 or 2048, 0 uses the API default)
+	TextureResolution int
+	// Amount of the image the subject fills (0.1-1, 0 uses the API default)
+	ForegroundRatio float64
+	// Optional remeshing algorithm
+	Remesh RemeshAlgorithm
+	// Optional target vertex count (0 leaves the mesh unsimplified)
+	VertexCount int
+}
+
+// StablePointAware3DRequest represents the parameters for a Stable Point Aware 3D (SPAR3D) request
+type StablePointAware3DRequest struct {
+	// The image to generate a model from (binary data)
+	Image []byte
+	// The filename of the image
+	Filename string
+	// Texture resolution in pixels (512, 1024 or 2048, 0 uses the API default)
+	TextureResolution int
+	// Amount of the image the subject fills (1-2, 0 uses the API default)
+	ForegroundRatio float64
+	// Optional remeshing algorithm
+	Remesh RemeshAlgorithm
+	// How TargetCount is interpreted
+	TargetType TargetType
+	// Target vertex or face count (100-20000, 0 uses the API default)
+	TargetCount int
+	// Guidance scale for point cloud generation (1-10, 0 uses the API default)
+	GuidanceScale float64
+	// Optional seed value
+	Seed int64
+}
+
+// AssetResponse represents a binary asset returned by the API, such as a 3D model
+type AssetResponse struct {
+	// The asset data
+	Data []byte
+	// The mime type of the asset (e.g., "model/gltf-binary")
+	MimeType string
+}
+
+// Validate checks the request against the documented limits of the stable fast 3d endpoint
+func (r StableFast3DRequest) Validate() error {
+	var v validator
+	v.image("image", r.Image, Model3DImageLimits, true)
+	v.textureResolution(r.TextureResolution)
+	v.between("foreground_ratio", r.ForegroundRatio, 0.1, 1)
+	if r.VertexCount < 0 || r.VertexCount > 20000 {
+		v.add("vertex_count", "must be between 0 and 20000")
+	}
+	return v.err("stable fast 3d")
+}
+
+// StableFast3D generates a textured 3D model from a single image
+func (c *Client) StableFast3D(ctx context.Context, request StableFast3DRequest) (*AssetResponse, error) {
+	if err := request.Validate(); err != nil {
+		return nil, err
+	}
+
+	fields := map[string]string{}
+	addTextureResolution(fields, request.TextureResolution)
+
+	if request.ForegroundRatio != 0 {
+		fields["foreground_ratio"] = strconv.FormatFloat(request.ForegroundRatio, 'f', 2, 64)
+	}
+
+	if request.Remesh != "" {
+		fields["remesh"] = string(request.Remesh)
+	}
+
+	if request.VertexCount != 0 {
+		fields["vertex_count"] = strconv.Itoa(request.VertexCount)
+	}
+
+	return c.generate3D(ctx, StableFast3DPath, request.Image, request.Filename, fields)
+}
+
+// Validate checks the request against the documented limits of the stable point aware 3d endpoint
+func (r StablePointAware3DRequest) Validate() error {
+	var v validator
+	v.image("image", r.Image, Model3DImageLimits, true)
+	v.textureResolution(r.TextureResolution)
+	v.between("foreground_ratio", r.ForegroundRatio, 1, 2)
+	if r.TargetCount != 0 && (r.TargetCount < 100 || r.TargetCount > 20000) {
+		v.add("target_count", "must be between 100 and 20000")
+	}
+	v.between("guidance_scale", r.GuidanceScale, 1, 10)
+	v.seed(r.Seed)
+	return v.err("stable point aware 3d")
+}
+
+// StablePointAware3D generates a 3D model from a single image using SPAR3D
+func (c *Client) StablePointAware3D(ctx context.Context, request StablePointAware3DRequest) (*AssetResponse, error) {
+	if err := request.Validate(); err != nil {
+		return nil, err
+	}
+
+	fields := map[string]string{}
+	addTextureResolution(fields, request.TextureResolution)
+
+	if request.ForegroundRatio != 0 {
+		fields["foreground_ratio"] = strconv.FormatFloat(request.ForegroundRatio, 'f', 2, 64)
+	}
+
+	if request.Remesh != "" {
+		fields["remesh"] = string(request.Remesh)
+	}
+
+	if request.TargetType != "" {
+		fields["target_type"] = string(request.TargetType)
+	}
+
+	if request.TargetCount != 0 {
+		fields["target_count"] = strconv.Itoa(request.TargetCount)
+	}
+
+	if request.GuidanceScale != 0 {
+		fields["guidance_scale"] = strconv.FormatFloat(request.GuidanceScale, 'f', 2, 64)
+	}
+
+	if request.Seed > 0 {
+		fields["seed"] = strconv.FormatInt(request.Seed, 10)
+	}
+
+	return c.generate3D(ctx, StablePointAware3DPath, request.Image, request.Filename, fields)
+}
+
+// generate3D sends a 3D generation request and returns the binary model
+func (c *Client) generate3D(ctx context.Context, endpoint string, image []byte, filename string, fields map[string]string) (*AssetResponse, error) {
+	resp, err := c.send(ctx, Operation{
+		Path:   endpoint,
+		Accept: MimeTypeGLB,
+		Fields: fields,
+		Files:  []FormFile{{Field: "image", Filename: filename, Data: image}},
+		Name:   "3d",
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 100*1024*1024)) // 100MB limit
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("no model data received in response")
+	}
+
+	mimeType := resp.Header.Get("Content-Type")
+	if mimeType == "" {
+		mimeType = MimeTypeGLB
+	}
+
+	return &AssetResponse{
+		Data:     data,
+		MimeType: mimeType,
+	}, nil
+}
+
+// addTextureResolution adds the texture_resolution field if it is set
+func addTextureResolution(fields map[string]string, resolution int) {
+	if resolution != 0 {
+		fields["texture_resolution"] = strconv.Itoa(resolution)
+	}
+}
@@ -0,0 +1,158 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math"
+
+	"github.com/marcusziade/stability-go/internal/utils"
+)
+
+// JPEG qualities tried, in order, until a preprocessed image fits the maximum file size
+var preprocessJPEGQualities = []int{95, 90, 80, 70}
+
+// PreprocessResult reports what PreprocessImage changed to fit an image within an endpoint's limits
+type PreprocessResult struct {
+	// Size of the image as it was given, before the EXIF orientation was applied
+	OriginalWidth  int `json:"original_width"`
+	OriginalHeight int `json:"original_height"`
+	// Size of the image that was sent
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// Format of the image as it was given and as it was sent (jpeg, png or webp)
+	OriginalFormat string `json:"original_format"`
+	Format         string `json:"format"`
+	// EXIF orientation that was applied to the pixels (0 if the image had none)
+	Orientation int `json:"orientation,omitempty"`
+	// Whether the image was downsized
+	Resized bool `json:"resized"`
+	// Whether the image was flattened onto a white background to be encoded as jpeg
+	AlphaRemoved bool `json:"alpha_removed"`
+	// Whether the image was re-encoded; false means the original bytes were sent unchanged
+	Reencoded bool `json:"reencoded"`
+}
+
+// PreprocessImage fits an image within an endpoint's maximum side, pixel count and file size.
+// The image is downsized preserving its aspect ratio, the EXIF orientation is applied to the pixels,
+// and the result is re-encoded as png, or as jpeg with the alpha channel removed if the png is too large.
+// Images that already fit and need no rotation are returned unchanged.
+func PreprocessImage(data []byte, limits ImageLimits) ([]byte, *PreprocessResult, error) {
+	width, height, format, err := utils.ImageDimensions(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result := &PreprocessResult{
+		OriginalWidth:  width,
+		OriginalHeight: height,
+		Width:          width,
+		Height:         height,
+		OriginalFormat: format,
+		Format:         format,
+	}
+
+	orientation := utils.ExifOrientation(data)
+	if orientation > 1 {
+		result.Orientation = orientation
+	}
+	// Orientations 5-8 swap the width and height
+	displayWidth, displayHeight := width, height
+	if orientation >= 5 {
+		displayWidth, displayHeight = height, width
+	}
+
+	fitWidth, fitHeight := fitImage(displayWidth, displayHeight, limits)
+	result.Resized = fitWidth != displayWidth || fitHeight != displayHeight
+
+	if result.Orientation == 0 && !result.Resized && len(data) <= limits.maxBytes() {
+		return data, result, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	// Resize before orienting, so fewer pixels have to be moved
+	if result.Resized {
+		if orientation >= 5 {
+			img = utils.Resize(img, fitHeight, fitWidth)
+		} else {
+			img = utils.Resize(img, fitWidth, fitHeight)
+		}
+	}
+	img = utils.Orient(img, orientation)
+
+	encoded, err := encodeFitted(img, format, limits.maxBytes(), result)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result.Width, result.Height = fitWidth, fitHeight
+	result.Reencoded = true
+	return encoded, result, nil
+}
+
+// fitImage returns the largest size with the same aspect ratio that fits within the maximum side and pixel count
+func fitImage(width, height int, limits ImageLimits) (int, int) {
+	scale := 1.0
+	if longer := math.Max(float64(width), float64(height)); limits.MaxSide > 0 && longer > float64(limits.MaxSide) {
+		scale = float64(limits.MaxSide) / longer
+	}
+	if pixels := float64(width) * float64(height); limits.MaxPixels > 0 && pixels*scale*scale > float64(limits.MaxPixels) {
+		scale = math.Sqrt(float64(limits.MaxPixels) / pixels)
+	}
+	if scale >= 1 {
+		return width, height
+	}
+
+	fitWidth := int(math.Max(1, math.Floor(float64(width)*scale)))
+	fitHeight := int(math.Max(1, math.Floor(float64(height)*scale)))
+	// Rounding can still leave the pixel count just above the limit
+	for limits.MaxPixels > 0 && fitWidth*fitHeight > limits.MaxPixels {
+		if fitWidth >= fitHeight {
+			fitWidth--
+		} else {
+			fitHeight--
+		}
+	}
+	return fitWidth, fitHeight
+}
+
+// encodeFitted encodes a preprocessed image in the original format if possible, falling back to jpeg to fit maxBytes
+func encodeFitted(img image.Image, format string, maxBytes int, result *PreprocessResult) ([]byte, error) {
+	var b bytes.Buffer
+
+	if format != "jpeg" {
+		// There is no webp encoder, so webp images are sent as png
+		if err := png.Encode(&b, img); err != nil {
+			return nil, fmt.Errorf("failed to encode image: %w", err)
+		}
+		result.Format = "png"
+		if b.Len() <= maxBytes {
+			return b.Bytes(), nil
+		}
+
+		// The png is too large, so send a jpeg, which has no alpha channel
+		if !utils.IsOpaque(img) {
+			img = utils.Flatten(img, color.White)
+			result.AlphaRemoved = true
+		}
+	}
+
+	result.Format = "jpeg"
+	for _, quality := range preprocessJPEGQualities {
+		b.Reset()
+		if err := jpeg.Encode(&b, img, &jpeg.Options{Quality: quality}); err != nil {
+			return nil, fmt.Errorf("failed to encode image: %w", err)
+		}
+		if b.Len() <= maxBytes {
+			return b.Bytes(), nil
+		}
+	}
+	return nil, fmt.Errorf("preprocessed image is %d bytes, the maximum is %d", b.Len(), maxBytes)
+}
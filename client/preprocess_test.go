@@ -0,0 +1,263 @@
+package client
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// halvesImage returns an image whose left half is black and right half white, to tell how it was turned
+func halvesImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 0, width/2, height), image.Black, image.Point{}, draw.Src)
+	return img
+}
+
+// exifJPEG encodes an image as a jpeg with an Exif segment carrying the given orientation
+func exifJPEG(t *testing.T, img image.Image, orientation int, order binary.ByteOrder) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+
+	// A TIFF header with a single IFD holding the orientation tag
+	tiff := make([]byte, 26)
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 1)
+	order.PutUint16(tiff[10:], 0x0112)
+	order.PutUint16(tiff[12:], 3)
+	order.PutUint32(tiff[14:], 1)
+	order.PutUint16(tiff[18:], uint16(orientation))
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(2+len(segment)))
+
+	// Insert the APP1 segment right after the start of image marker
+	data := b.Bytes()
+	return append(append(append([]byte{}, data[:2]...), append(app1, segment...)...), data[2:]...)
+}
+
+// decode decodes an image, failing the test if it can't be
+func decode(t *testing.T, data []byte) image.Image {
+	t.Helper()
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to decode preprocessed image: %v", err)
+	}
+	return img
+}
+
+// isDark reports whether the pixel at x, y is closer to black than to white
+func isDark(img image.Image, x, y int) bool {
+	r, g, b, _ := img.At(x, y).RGBA()
+	return (r+g+b)/3 < 0x8000
+}
+
+func TestPreprocessImageUnchanged(t *testing.T) {
+	var b bytes.Buffer
+	png.Encode(&b, halvesImage(200, 100))
+
+	data, result, err := PreprocessImage(b.Bytes(), FastUpscaleImageLimits)
+	if err != nil {
+		t.Fatalf("PreprocessImage failed: %v", err)
+	}
+	if !bytes.Equal(data, b.Bytes()) || result.Reencoded || result.Resized {
+		t.Errorf("result = %+v, want an image that fits returned unchanged", result)
+	}
+	if result.Format != "png" || result.OriginalFormat != "png" || result.Width != 200 || result.Height != 100 {
+		t.Errorf("result = %+v, want a 200x100 png", result)
+	}
+}
+
+func TestPreprocessImageOrientation(t *testing.T) {
+	tests := []struct {
+		name          string
+		orientation   int
+		order         binary.ByteOrder
+		width, height int
+		// A pixel of the black half of the oriented image and one of the white half
+		dark, light image.Point
+	}{
+		{"upright", 1, binary.BigEndian, 200, 100, image.Pt(20, 50), image.Pt(180, 50)},
+		{"rotated 180 degrees", 3, binary.BigEndian, 200, 100, image.Pt(180, 50), image.Pt(20, 50)},
+		{"rotated clockwise", 6, binary.BigEndian, 100, 200, image.Pt(50, 20), image.Pt(50, 180)},
+		{"rotated counter-clockwise", 8, binary.BigEndian, 100, 200, image.Pt(50, 180), image.Pt(50, 20)},
+		{"little-endian Exif", 6, binary.LittleEndian, 100, 200, image.Pt(50, 20), image.Pt(50, 180)},
+		{"mirrored", 2, binary.BigEndian, 200, 100, image.Pt(180, 50), image.Pt(20, 50)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, result, err := PreprocessImage(exifJPEG(t, halvesImage(200, 100), tt.orientation, tt.order), FastUpscaleImageLimits)
+			if err != nil {
+				t.Fatalf("PreprocessImage failed: %v", err)
+			}
+
+			// The orientation is applied to the pixels, so the image is sent the way it is displayed
+			wantOrientation := tt.orientation
+			if wantOrientation == 1 {
+				wantOrientation = 0
+			}
+			if result.Orientation != wantOrientation || result.Reencoded != (wantOrientation != 0) {
+				t.Errorf("result = %+v, want orientation %d applied", result, wantOrientation)
+			}
+			if result.OriginalWidth != 200 || result.OriginalHeight != 100 || result.Width != tt.width || result.Height != tt.height {
+				t.Errorf("result = %+v, want 200x100 sent as %dx%d", result, tt.width, tt.height)
+			}
+
+			img := decode(t, data)
+			if bounds := img.Bounds(); bounds.Dx() != tt.width || bounds.Dy() != tt.height {
+				t.Fatalf("image is %dx%d, want %dx%d", bounds.Dx(), bounds.Dy(), tt.width, tt.height)
+			}
+			if !isDark(img, tt.dark.X, tt.dark.Y) || isDark(img, tt.light.X, tt.light.Y) {
+				t.Errorf("pixels at %v and %v aren't black and white", tt.dark, tt.light)
+			}
+		})
+	}
+}
+
+func TestPreprocessImageResize(t *testing.T) {
+	var b bytes.Buffer
+	png.Encode(&b, halvesImage(3000, 1500))
+
+	tests := []struct {
+		name          string
+		data          []byte
+		limits        ImageLimits
+		width, height int
+	}{
+		// The pixel count is the tighter limit: 3000x1500 scaled by sqrt(1048576/4500000)
+		{"pixel count", b.Bytes(), FastUpscaleImageLimits, 1448, 724},
+		{"longest side", b.Bytes(), ImageLimits{MaxSide: 1000}, 1000, 500},
+		// The limits apply to the image as displayed
+		{"rotated", exifJPEG(t, halvesImage(3000, 1500), 6, binary.BigEndian), FastUpscaleImageLimits, 724, 1448},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, result, err := PreprocessImage(tt.data, tt.limits)
+			if err != nil {
+				t.Fatalf("PreprocessImage failed: %v", err)
+			}
+			if !result.Resized || result.Width != tt.width || result.Height != tt.height {
+				t.Errorf("result = %+v, want resized to %dx%d", result, tt.width, tt.height)
+			}
+			if bounds := decode(t, data).Bounds(); bounds.Dx() != tt.width || bounds.Dy() != tt.height {
+				t.Errorf("image is %dx%d, want %dx%d", bounds.Dx(), bounds.Dy(), tt.width, tt.height)
+			}
+		})
+	}
+}
+
+func TestFitImage(t *testing.T) {
+	tests := []struct {
+		name                  string
+		width, height         int
+		limits                ImageLimits
+		wantWidth, wantHeight int
+	}{
+		{"fits", 1024, 1024, FastUpscaleImageLimits, 1024, 1024},
+		{"no limits", 5000, 5000, ImageLimits{}, 5000, 5000},
+		{"side above the maximum", 2000, 500, FastUpscaleImageLimits, 1536, 384},
+		{"pixels above the maximum", 1025, 1025, FastUpscaleImageLimits, 1024, 1024},
+		{"portrait", 1500, 3000, FastUpscaleImageLimits, 724, 1448},
+		{"tiny result keeps a pixel", 10000, 1, ImageLimits{MaxSide: 100}, 100, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			width, height := fitImage(tt.width, tt.height, tt.limits)
+			if width != tt.wantWidth || height != tt.wantHeight {
+				t.Errorf("fitImage(%d, %d) = %dx%d, want %dx%d", tt.width, tt.height, width, height, tt.wantWidth, tt.wantHeight)
+			}
+			if tt.limits.MaxPixels > 0 && width*height > tt.limits.MaxPixels {
+				t.Errorf("%dx%d has more than %d pixels", width, height, tt.limits.MaxPixels)
+			}
+		})
+	}
+}
+
+// noiseImage returns an image of random colors, which compresses poorly as png, with its left quarter
+// transparent if transparent is set
+func noiseImage(width, height int, transparent bool) *image.NRGBA {
+	random := rand.New(rand.NewSource(1))
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.NRGBA{uint8(random.Intn(256)), uint8(random.Intn(256)), uint8(random.Intn(256)), 255}
+			if transparent && x < width/4 {
+				c.A = 0
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestPreprocessImageFlattensAlpha(t *testing.T) {
+	tests := []struct {
+		name        string
+		transparent bool
+	}{
+		{"transparent", true},
+		{"opaque", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			png.Encode(&b, noiseImage(256, 256, tt.transparent))
+
+			// The image has to be re-encoded to fit, and the png is too large, so it is sent as jpeg
+			limits := ImageLimits{MaxSide: 200, MaxBytes: b.Len() / 3}
+			data, result, err := PreprocessImage(b.Bytes(), limits)
+			if err != nil {
+				t.Fatalf("PreprocessImage failed: %v", err)
+			}
+			if result.Format != "jpeg" || result.OriginalFormat != "png" || result.AlphaRemoved != tt.transparent {
+				t.Errorf("result = %+v, want a jpeg with the alpha removed: %v", result, tt.transparent)
+			}
+			if len(data) > limits.MaxBytes {
+				t.Errorf("image is %d bytes, want at most %d", len(data), limits.MaxBytes)
+			}
+
+			// Transparent pixels are flattened onto white
+			if tt.transparent {
+				img := decode(t, data)
+				if r, g, bl, _ := img.At(10, 100).RGBA(); r < 0xE000 || g < 0xE000 || bl < 0xE000 {
+					t.Errorf("transparent pixel = %v, want white", img.At(10, 100))
+				}
+			}
+		})
+	}
+}
+
+func TestPreprocessImageErrors(t *testing.T) {
+	if _, _, err := PreprocessImage([]byte("not an image at all"), FastUpscaleImageLimits); err == nil {
+		t.Error("PreprocessImage of invalid data succeeded")
+	}
+
+	// Even the lowest jpeg quality doesn't fit
+	var b bytes.Buffer
+	png.Encode(&b, noiseImage(256, 256, false))
+	_, _, err := PreprocessImage(b.Bytes(), ImageLimits{MaxBytes: 1000})
+	if err == nil || !strings.Contains(err.Error(), "the maximum is 1000") {
+		t.Errorf("err = %v, want the image reported as too large", err)
+	}
+}
@@ -0,0 +1,285 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/marcusziade/stability-go/telemetry"
+)
+
+// ProxyConfig configures the proxy middleware
+type ProxyConfig struct {
+	// Proxy URL: http://, https://, socks5:// or socks5h://, with optional user:password@ credentials.
+	// A URL without a scheme is treated as an HTTP proxy.
+	URL string
+	// Comma-separated hosts, domains, IP addresses and CIDR ranges that are reached directly,
+	// in the NO_PROXY format (e.g., "localhost,.internal.example.com,10.0.0.0/8"; "*" bypasses the proxy entirely)
+	NoProxy string
+}
+
+// Proxy returns a middleware that sends requests through a standard HTTP or SOCKS5 proxy.
+// Hosts listed in the NO_PROXY environment variable are reached directly.
+func Proxy(proxyURL string) Middleware {
+	return ProxyWithConfig(ProxyConfig{
+		URL:     proxyURL,
+		NoProxy: noProxyFromEnvironment(),
+	})
+}
+
+// ProxyWithConfig returns a middleware that sends requests through a standard HTTP or SOCKS5 proxy
+func ProxyWithConfig(config ProxyConfig) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return NewProxyMiddleware(config, next)
+	}
+}
+
+// Relay returns a middleware that routes requests through a relay server
+func Relay(relayURL string) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return NewRelayMiddleware(relayURL, next)
+	}
+}
+
+// ProxyMiddleware is a middleware that sends requests through an HTTP (CONNECT) or SOCKS5 proxy.
+// Proxying happens when connections are made, so the middleware replaces the transport it wraps with
+// a copy configured for the proxy. It must therefore be the last middleware in a chain, wrapping either
+// an *http.Transport or nothing (a copy of http.DefaultTransport is used then).
+type ProxyMiddleware struct {
+	// Host of the proxy, reported on spans
+	proxy string
+	next  http.RoundTripper
+	// Configuration error returned for every request
+	err error
+}
+
+// NewProxyMiddleware creates a new proxy middleware. An invalid proxy URL, or a next transport that isn't an
+// *http.Transport and therefore can't be proxied, is reported when the first request is sent.
+func NewProxyMiddleware(config ProxyConfig, next http.RoundTripper) *ProxyMiddleware {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	transport, ok := next.(*http.Transport)
+	if !ok {
+		return &ProxyMiddleware{
+			next: next,
+			err:  fmt.Errorf("proxy middleware must wrap an *http.Transport, not %T; make it the last middleware in the chain", next),
+		}
+	}
+	transport = transport.Clone()
+
+	proxyURL, err := parseProxyURL(config.URL)
+	noProxy := parseNoProxy(config.NoProxy)
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		if err != nil {
+			return nil, err
+		}
+		if noProxy.matches(req.URL) {
+			return nil, nil
+		}
+		return proxyURL, nil
+	}
+
+	var proxy string
+	if proxyURL != nil {
+		proxy = proxyURL.Host
+	}
+
+	return &ProxyMiddleware{
+		proxy: proxy,
+		next:  transport,
+	}
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (m *ProxyMiddleware) RoundTrip(req *http.Request) (*http.Response, error) {
+	if m.err != nil {
+		closeBody(req)
+		return nil, m.err
+	}
+
+	ctx, span := telemetry.StartSpan(req.Context(), "stability.middleware.proxy", telemetry.String(telemetry.AttrProxy, m.proxy))
+	resp, err := m.next.RoundTrip(req.WithContext(ctx))
+	endSpan(span, resp, err)
+	return resp, err
+}
+
+// parseProxyURL parses and validates a proxy URL
+func parseProxyURL(rawURL string) (*url.URL, error) {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "http://" + rawURL
+	}
+
+	proxyURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("invalid proxy URL: unsupported scheme %q", proxyURL.Scheme)
+	}
+	if proxyURL.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL: missing host")
+	}
+
+	return proxyURL, nil
+}
+
+// noProxyFromEnvironment returns the NO_PROXY environment variable
+func noProxyFromEnvironment() string {
+	if noProxy := os.Getenv("NO_PROXY"); noProxy != "" {
+		return noProxy
+	}
+	return os.Getenv("no_proxy")
+}
+
+// noProxyRule is one entry of a NO_PROXY list
+type noProxyRule struct {
+	// Host name or domain suffix; a leading dot matches subdomains only
+	domain string
+	// IP address or range
+	network *net.IPNet
+	// Optional port the rule is limited to
+	port string
+}
+
+// noProxyList is a parsed NO_PROXY list
+type noProxyList struct {
+	all   bool
+	rules []noProxyRule
+}
+
+// parseNoProxy parses a comma-separated NO_PROXY list
+func parseNoProxy(value string) noProxyList {
+	var list noProxyList
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			list.all = true
+			continue
+		}
+
+		// CIDR ranges
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			list.rules = append(list.rules, noProxyRule{network: network})
+			continue
+		}
+
+		var rule noProxyRule
+		host := entry
+		if h, port, err := net.SplitHostPort(entry); err == nil {
+			host, rule.port = h, port
+		}
+
+		// Single IP addresses
+		if ip := net.ParseIP(host); ip != nil {
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			rule.network = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		} else {
+			rule.domain = strings.TrimPrefix(host, "*")
+		}
+		list.rules = append(list.rules, rule)
+	}
+	return list
+}
+
+// matches reports whether requests to the URL bypass the proxy
+func (l noProxyList) matches(u *url.URL) bool {
+	if l.all {
+		return true
+	}
+
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	ip := net.ParseIP(host)
+
+	for _, rule := range l.rules {
+		if rule.port != "" && rule.port != port {
+			continue
+		}
+		switch {
+		case rule.network != nil:
+			if ip != nil && rule.network.Contains(ip) {
+				return true
+			}
+		case strings.HasPrefix(rule.domain, "."):
+			if strings.HasSuffix(host, rule.domain) {
+				return true
+			}
+		default:
+			if host == rule.domain || strings.HasSuffix(host, "."+rule.domain) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RelayMiddleware is a middleware that routes requests through a relay server, which receives each request
+// at /proxy/<path> with the original URL in the target query parameter
+type RelayMiddleware struct {
+	scheme string
+	host   string
+	next   http.RoundTripper
+}
+
+// NewRelayMiddleware creates a new relay middleware. A relay URL without a scheme uses https.
+func NewRelayMiddleware(relayURL string, next http.RoundTripper) *RelayMiddleware {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	scheme, host := "https", relayURL
+	if u, err := url.Parse(relayURL); err == nil && u.Scheme != "" && u.Host != "" {
+		scheme, host = u.Scheme, u.Host
+	}
+
+	return &RelayMiddleware{
+		scheme: scheme,
+		host:   host,
+		next:   next,
+	}
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (m *RelayMiddleware) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := telemetry.StartSpan(req.Context(), "stability.middleware.relay", telemetry.String(telemetry.AttrProxy, m.host))
+
+	// RoundTrippers must not modify the caller's request, so rewrite a copy
+	req = req.Clone(ctx)
+
+	// Replace the host with the relay host
+	originalURL := req.URL.String()
+	req.URL.Scheme = m.scheme
+	req.URL.Host = m.host
+	req.URL.Path = "/proxy" + req.URL.Path
+	req.URL.RawPath = ""
+	req.Host = ""
+
+	// Add the original URL as a query parameter
+	q := req.URL.Query()
+	q.Add("target", originalURL)
+	req.URL.RawQuery = q.Encode()
+
+	// Continue with the request
+	resp, err := m.next.RoundTrip(req)
+	endSpan(span, resp, err)
+	return resp, err
+}
@@ -0,0 +1,61 @@
+package client
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestNoProxyMatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		noProxy string
+		url     string
+		want    bool
+	}{
+		{"empty list", "", "https://api.stability.ai", false},
+		{"wildcard", "*", "https://api.stability.ai", true},
+		{"wildcard among other entries", "localhost, *", "https://api.stability.ai", true},
+
+		{"exact host", "api.stability.ai", "https://api.stability.ai", true},
+		{"domain matches subdomains", "stability.ai", "https://api.stability.ai", true},
+		{"domain matches itself", "stability.ai", "https://stability.ai", true},
+		{"domain doesn't match a longer name", "stability.ai", "https://notstability.ai", false},
+		{"leading-dot domain matches subdomains", ".stability.ai", "https://api.stability.ai", true},
+		{"leading-dot domain doesn't match itself", ".stability.ai", "https://stability.ai", false},
+		{"leading wildcard is a domain", "*.stability.ai", "https://api.stability.ai", true},
+		{"other domain", "example.com", "https://api.stability.ai", false},
+
+		{"host and port", "api.stability.ai:443", "https://api.stability.ai", true},
+		{"host and other port", "api.stability.ai:8443", "https://api.stability.ai", false},
+		{"host and explicit port", "api.stability.ai:8443", "https://api.stability.ai:8443", true},
+		{"http default port", "internal:80", "http://internal", true},
+		{"https default port", "internal:80", "https://internal", false},
+
+		{"single IPv4 address", "10.0.0.5", "http://10.0.0.5:8080", true},
+		{"other IPv4 address", "10.0.0.5", "http://10.0.0.6", false},
+		{"IPv4 address and port", "10.0.0.5:8080", "http://10.0.0.5:8080", true},
+		{"IPv4 address and other port", "10.0.0.5:8080", "http://10.0.0.5:9090", false},
+		{"IPv4 range", "10.0.0.0/8", "http://10.20.30.40", true},
+		{"outside IPv4 range", "10.0.0.0/8", "http://11.0.0.1", false},
+		{"single IPv6 address", "::1", "http://[::1]:8080", true},
+		{"IPv6 address and port", "[::1]:8080", "http://[::1]:8080", true},
+		{"IPv6 range", "fd00::/8", "http://[fd12::1]", true},
+		{"range doesn't match host names", "10.0.0.0/8", "http://api.stability.ai", false},
+
+		{"upper-case entry", "API.Stability.AI", "https://api.stability.ai", true},
+		{"upper-case host", "api.stability.ai", "https://API.STABILITY.AI", true},
+		{"whitespace and empty entries", " localhost , , stability.ai ", "https://api.stability.ai", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatalf("invalid URL %q: %v", tt.url, err)
+			}
+			if got := parseNoProxy(tt.noProxy).matches(u); got != tt.want {
+				t.Errorf("NO_PROXY %q matches %s = %v, want %v", tt.noProxy, tt.url, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package client
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EndpointClass groups API endpoints that share a rate limit budget
+type EndpointClass string
+
+const (
+	// EndpointUpscale covers the fast, conservative and creative upscale submissions
+	EndpointUpscale EndpointClass = "upscale"
+	// EndpointGenerate covers image generation, editing, control, video and 3D submissions
+	EndpointGenerate EndpointClass = "generate"
+	// EndpointPoll covers the result polling endpoints of asynchronous jobs
+	EndpointPoll EndpointClass = "poll"
+	// EndpointOther covers everything else (account, balance, engines, ...)
+	EndpointOther EndpointClass = "other"
+)
+
+// ClassifyEndpoint returns the endpoint class of a request path.
+// Paths rewritten by the proxy middleware are classified by the original path.
+func ClassifyEndpoint(path string) EndpointClass {
+	path = strings.TrimPrefix(path, "/proxy")
+
+	switch {
+	case strings.HasPrefix(path, ResultPath+"/"),
+		strings.HasPrefix(path, CreativeResultPath+"/"),
+		strings.HasPrefix(path, VideoResultPath+"/"):
+		return EndpointPoll
+	case strings.HasPrefix(path, "/v2beta/stable-image/upscale/"):
+		return EndpointUpscale
+	case strings.HasPrefix(path, "/v2beta/"):
+		return EndpointGenerate
+	default:
+		return EndpointOther
+	}
+}
+
+// RateLimitBudget is the token bucket settings for one class of endpoints
+type RateLimitBudget struct {
+	// Time it takes to refill one token (the sustained minimum interval between requests)
+	Interval time.Duration
+	// Maximum number of requests that can be sent back to back (defaults to 1)
+	Burst int
+}
+
+// RateLimitConfig configures the rate limit middleware
+type RateLimitConfig struct {
+	// Budget shared by every endpoint class without its own entry in Endpoints
+	Default RateLimitBudget
+	// Optional budgets for individual endpoint classes, each with its own bucket
+	Endpoints map[EndpointClass]RateLimitBudget
+}
+
+// TokenBucket is a context-aware token bucket rate limiter
+type TokenBucket struct {
+	mutex    sync.Mutex
+	interval time.Duration
+	burst    float64
+	tokens   float64
+	last     time.Time
+}
+
+// NewTokenBucket creates a token bucket that refills one token every interval and holds up to burst tokens.
+// The bucket starts full.
+func NewTokenBucket(interval time.Duration, burst int) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &TokenBucket{
+		interval: interval,
+		burst:    float64(burst),
+		tokens:   float64(burst),
+		last:     time.Now(),
+	}
+}
+
+// Wait blocks until a token is available or the context is done.
+// A cancelled wait hands its reserved token back so it doesn't delay later callers.
+func (b *TokenBucket) Wait(ctx context.Context) error {
+	delay := b.reserve()
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		b.cancel()
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// reserve takes a token, possibly going into debt, and returns how long the caller must wait for it
+func (b *TokenBucket) reserve() time.Duration {
+	if b.interval <= 0 {
+		return 0
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.refill(time.Now())
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens * float64(b.interval))
+}
+
+// cancel returns a token taken by a reservation that was abandoned
+func (b *TokenBucket) cancel() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.refill(time.Now())
+	b.tokens++
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// refill adds the tokens accrued since the last update. Must be called with the mutex held.
+func (b *TokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last)
+	if elapsed <= 0 {
+		return
+	}
+
+	b.last = now
+	b.tokens += float64(elapsed) / float64(b.interval)
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
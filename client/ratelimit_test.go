@@ -0,0 +1,103 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// approx reports whether d is within a second below want, leaving room for the time the test itself takes
+func approx(d, want time.Duration) bool {
+	return d <= want && d > want-time.Second
+}
+
+func TestTokenBucketBurst(t *testing.T) {
+	b := NewTokenBucket(time.Hour, 2)
+
+	for i := 1; i <= 2; i++ {
+		if delay := b.reserve(); delay != 0 {
+			t.Fatalf("reservation %d waits %v, want no wait within the burst", i, delay)
+		}
+	}
+	if delay := b.reserve(); !approx(delay, time.Hour) {
+		t.Errorf("third reservation waits %v, want an interval", delay)
+	}
+	if delay := b.reserve(); !approx(delay, 2*time.Hour) {
+		t.Errorf("fourth reservation waits %v, want two intervals", delay)
+	}
+}
+
+func TestTokenBucketRefill(t *testing.T) {
+	b := NewTokenBucket(time.Hour, 2)
+
+	// Half an interval refills half a token of an empty bucket
+	b.mutex.Lock()
+	b.tokens = 0
+	b.refill(b.last.Add(30 * time.Minute))
+	tokens := b.tokens
+	b.mutex.Unlock()
+	if tokens != 0.5 {
+		t.Errorf("tokens after half an interval = %v, want 0.5", tokens)
+	}
+
+	// The bucket never holds more than the burst
+	b.mutex.Lock()
+	b.refill(b.last.Add(10 * time.Hour))
+	tokens = b.tokens
+	b.mutex.Unlock()
+	if tokens != 2 {
+		t.Errorf("tokens after ten intervals = %v, want the burst of 2", tokens)
+	}
+
+	// Going back in time adds nothing
+	b.mutex.Lock()
+	b.refill(b.last.Add(-time.Hour))
+	tokens = b.tokens
+	b.mutex.Unlock()
+	if tokens != 2 {
+		t.Errorf("tokens after a clock step back = %v, want 2", tokens)
+	}
+}
+
+func TestTokenBucketWait(t *testing.T) {
+	b := NewTokenBucket(time.Hour, 1)
+
+	if err := b.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait with a full bucket failed: %v", err)
+	}
+
+	// An empty bucket blocks until the context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := b.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait with an empty bucket = %v, want context.DeadlineExceeded", err)
+	}
+
+	// The abandoned wait handed its token back, so the next caller waits one interval, not two
+	if delay := b.reserve(); !approx(delay, time.Hour) {
+		t.Errorf("reservation after a cancelled wait waits %v, want an interval", delay)
+	}
+}
+
+func TestTokenBucketWaitForToken(t *testing.T) {
+	b := NewTokenBucket(20*time.Millisecond, 1)
+	b.reserve()
+
+	start := time.Now()
+	if err := b.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 15*time.Millisecond {
+		t.Errorf("Wait returned after %v, want about one 20ms interval", elapsed)
+	}
+}
+
+func TestTokenBucketUnlimited(t *testing.T) {
+	b := NewTokenBucket(0, 1)
+	for i := 0; i < 100; i++ {
+		if delay := b.reserve(); delay != 0 {
+			t.Fatalf("reservation %d waits %v, want no limit with a zero interval", i, delay)
+		}
+	}
+}
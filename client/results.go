@@ -0,0 +1,128 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"time"
+
+	apierrors "github.com/marcusziade/stability-go/errors"
+	"github.com/marcusziade/stability-go/internal/utils"
+)
+
+// ResultPath is the endpoint for polling the result of any asynchronous v2beta job
+const ResultPath = "/v2beta/results"
+
+// AsyncResponse represents the ID returned by an asynchronous endpoint
+type AsyncResponse struct {
+	// The ID to use for polling the result
+	ID string `json:"id"`
+}
+
+// ResultResponse represents the response from the results polling endpoint
+type ResultResponse struct {
+	// Whether the job is finished
+	Finished bool `json:"finished"`
+	// The base64 encoded image data (only present when finished is true)
+	Image string `json:"image,omitempty"`
+	// The image type (only present when finished is true)
+	Type string `json:"mime_type,omitempty"`
+	// The finish reason reported by the API (SUCCESS or CONTENT_FILTERED)
+	FinishReason string `json:"finish_reason,omitempty"`
+	// The seed used to produce the result
+	Seed int64 `json:"seed,omitempty"`
+	// Any error that occurred during processing
+	Error string `json:"error,omitempty"`
+}
+
+// AsyncResult represents the output of a finished asynchronous job
+type AsyncResult struct {
+	// The result data
+	Data []byte
+	// The mime type of the result (e.g., "image/png")
+	MimeType string
+	// The seed used to produce the result
+	Seed int64
+	// The finish reason reported by the API (SUCCESS or CONTENT_FILTERED)
+	FinishReason string
+	// The seed, finish reason, request ID and timing of the poll that returned the result
+	Metadata *Metadata
+}
+
+// PollResult polls for the result of an asynchronous job, such as a creative upscale or a relight.
+// The returned bool reports whether the job has finished.
+func (c *Client) PollResult(ctx context.Context, id string) (*AsyncResult, bool, error) {
+	result, finished, _, err := c.pollResult(ctx, id)
+	return result, finished, err
+}
+
+// pollResult polls the results endpoint once, also returning the Retry-After delay requested while the job is pending
+func (c *Client) pollResult(ctx context.Context, id string) (result *AsyncResult, finished bool, retryAfter time.Duration, err error) {
+	ctx, span := c.startPollSpan(ctx, id)
+	defer func() { endPollSpan(span, finished, err) }()
+
+	if id == "" {
+		return nil, false, 0, fmt.Errorf("result ID is required")
+	}
+
+	start := time.Now()
+	resp, err := c.send(ctx, Operation{
+		Method: http.MethodGet,
+		Path:   ResultPath + "/" + id,
+		Name:   "poll",
+	})
+	if err != nil {
+		return nil, false, 0, err
+	}
+	defer resp.Body.Close()
+
+	// The API responds with 202 while the job is still in progress
+	if resp.StatusCode == http.StatusAccepted {
+		return nil, false, utils.ParseRetryAfter(resp.Header.Get("Retry-After")), nil
+	}
+
+	var resultResp ResultResponse
+	if err := decodeJSONResponse(resp, "poll", &resultResp); err != nil {
+		return nil, false, 0, err
+	}
+
+	// Check if there was an error during processing
+	if resultResp.Error != "" {
+		return nil, false, 0, &apierrors.APIError{
+			StatusCode: resp.StatusCode,
+			Operation:  "poll",
+			ID:         id,
+			Name:       "processing_error",
+			Message:    resultResp.Error,
+		}
+	}
+
+	// If not finished yet, return with the finished flag set to false
+	if !resultResp.Finished && resultResp.Image == "" {
+		return nil, false, utils.ParseRetryAfter(resp.Header.Get("Retry-After")), nil
+	}
+
+	// Decode the base64 image data
+	data, err := base64.StdEncoding.DecodeString(resultResp.Image)
+	if err != nil {
+		return nil, true, 0, fmt.Errorf("failed to decode base64 image: %w", err)
+	}
+
+	mimeType := resultResp.Type
+	if mimeType == "" {
+		mimeType = http.DetectContentType(data)
+	}
+
+	metadata := responseMetadata(resp, start)
+	metadata.Seed = resultResp.Seed
+	metadata.FinishReason = resultResp.FinishReason
+
+	return &AsyncResult{
+		Data:         data,
+		MimeType:     mimeType,
+		Seed:         resultResp.Seed,
+		FinishReason: resultResp.FinishReason,
+		Metadata:     metadata,
+	}, true, 0, nil
+}
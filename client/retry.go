@@ -0,0 +1,133 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// RetryAttemptsHeader is set by the retry middleware on the final response to the number of attempts made
+const RetryAttemptsHeader = "X-Retry-Attempts"
+
+// DefaultRetryStatusCodes are the response status codes retried by the default retry policy
+var DefaultRetryStatusCodes = []int{
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// RetryPolicy decides which failed attempts the retry middleware retries
+type RetryPolicy struct {
+	// Response status codes that are retried (defaults to DefaultRetryStatusCodes)
+	StatusCodes []int
+	// Optional function that classifies transport errors as retryable (defaults to IsRetryableError)
+	RetryableError func(error) bool
+	// Retry non-idempotent requests (such as POSTs without an Idempotency-Key header) after transport errors
+	// that may have happened after the request reached the API, and may therefore already have been billed
+	RetryNonIdempotent bool
+}
+
+// DefaultRetryPolicy returns the retry policy used when none is configured
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{StatusCodes: DefaultRetryStatusCodes}
+}
+
+// ShouldRetry reports whether an attempt that returned resp or err should be retried
+func (p RetryPolicy) ShouldRetry(req *http.Request, resp *http.Response, err error) bool {
+	if err != nil {
+		retryable := p.RetryableError
+		if retryable == nil {
+			retryable = IsRetryableError
+		}
+		if !retryable(err) {
+			return false
+		}
+
+		// Errors before the connection was made are always safe to retry
+		return p.RetryNonIdempotent || isIdempotent(req) || isDialError(err)
+	}
+
+	statusCodes := p.StatusCodes
+	if statusCodes == nil {
+		statusCodes = DefaultRetryStatusCodes
+	}
+	for _, code := range statusCodes {
+		if resp.StatusCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRetryableError reports whether a transport error is worth retrying.
+// Cancelled requests and expired deadlines are not; network errors are.
+func IsRetryableError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, net.ErrClosed)
+}
+
+// isIdempotent reports whether sending the request twice has the same effect as sending it once
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != "" || req.Header.Get("X-Idempotency-Key") != ""
+}
+
+// isDialError reports whether the error happened while connecting, before any of the request was sent
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// RetryBudget limits retries to a fraction of the requests sent, so an outage doesn't turn into a retry storm.
+// Every request deposits Ratio tokens and every retry withdraws one; a retry without a whole token left is skipped.
+// A RetryBudget can be shared by several retry middlewares.
+type RetryBudget struct {
+	mutex     sync.Mutex
+	ratio     float64
+	maxTokens float64
+	tokens    float64
+}
+
+// NewRetryBudget creates a retry budget that earns ratio retries per request and holds at most maxTokens.
+// The budget starts full.
+func NewRetryBudget(ratio float64, maxTokens int) *RetryBudget {
+	return &RetryBudget{
+		ratio:     ratio,
+		maxTokens: float64(maxTokens),
+		tokens:    float64(maxTokens),
+	}
+}
+
+// deposit credits the budget for a new request
+func (b *RetryBudget) deposit() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.tokens += b.ratio
+	if b.tokens > b.maxTokens {
+		b.tokens = b.maxTokens
+	}
+}
+
+// withdraw takes a token for a retry, reporting false if the budget is exhausted
+func (b *RetryBudget) withdraw() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
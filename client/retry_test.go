@@ -0,0 +1,269 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	apierrors "github.com/marcusziade/stability-go/errors"
+)
+
+// trackedBody is a request body that records whether it was closed
+type trackedBody struct {
+	io.Reader
+	mutex  sync.Mutex
+	closed bool
+}
+
+func (b *trackedBody) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.closed = true
+	return nil
+}
+
+func (b *trackedBody) isClosed() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.closed
+}
+
+// response returns a response with the given status and headers
+func response(req *http.Request, status int, header ...string) *http.Response {
+	resp := &http.Response{StatusCode: status, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("")), Request: req}
+	for i := 0; i+1 < len(header); i += 2 {
+		resp.Header.Set(header[i], header[i+1])
+	}
+	return resp
+}
+
+// sequenceTransport answers the requests it receives with the given results in turn, repeating the last one
+func sequenceTransport(calls *int, results ...func(*http.Request) (*http.Response, error)) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		result := results[min(*calls, len(results)-1)]
+		*calls++
+		if req.Body != nil {
+			io.Copy(io.Discard, req.Body)
+			req.Body.Close()
+		}
+		return result(req)
+	})
+}
+
+func withStatus(status int, header ...string) func(*http.Request) (*http.Response, error) {
+	return func(req *http.Request) (*http.Response, error) { return response(req, status, header...), nil }
+}
+
+func withError(err error) func(*http.Request) (*http.Response, error) {
+	return func(*http.Request) (*http.Response, error) { return nil, err }
+}
+
+func TestRetryPolicyShouldRetry(t *testing.T) {
+	readErr := &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+	tests := []struct {
+		name   string
+		policy RetryPolicy
+		method string
+		header string
+		status int
+		err    error
+		want   bool
+	}{
+		{"429", RetryPolicy{}, http.MethodPost, "", http.StatusTooManyRequests, nil, true},
+		{"500", RetryPolicy{}, http.MethodPost, "", http.StatusInternalServerError, nil, true},
+		{"502", RetryPolicy{}, http.MethodPost, "", http.StatusBadGateway, nil, true},
+		{"503", RetryPolicy{}, http.MethodPost, "", http.StatusServiceUnavailable, nil, true},
+		{"504", RetryPolicy{}, http.MethodPost, "", http.StatusGatewayTimeout, nil, true},
+		{"200", RetryPolicy{}, http.MethodPost, "", http.StatusOK, nil, false},
+		{"400", RetryPolicy{}, http.MethodPost, "", http.StatusBadRequest, nil, false},
+		{"401", RetryPolicy{}, http.MethodPost, "", http.StatusUnauthorized, nil, false},
+		{"402", RetryPolicy{}, http.MethodPost, "", http.StatusPaymentRequired, nil, false},
+		{"custom status codes", RetryPolicy{StatusCodes: []int{http.StatusConflict}}, http.MethodPost, "", http.StatusConflict, nil, true},
+		{"status outside custom codes", RetryPolicy{StatusCodes: []int{http.StatusConflict}}, http.MethodPost, "", http.StatusInternalServerError, nil, false},
+		{"cancelled", RetryPolicy{}, http.MethodGet, "", 0, context.Canceled, false},
+		{"deadline exceeded", RetryPolicy{}, http.MethodGet, "", 0, context.DeadlineExceeded, false},
+		{"not a network error", RetryPolicy{}, http.MethodGet, "", 0, errors.New("invalid request"), false},
+		{"closed connection", RetryPolicy{}, http.MethodGet, "", 0, net.ErrClosed, true},
+		{"network error on GET", RetryPolicy{}, http.MethodGet, "", 0, readErr, true},
+		{"network error on POST", RetryPolicy{}, http.MethodPost, "", 0, readErr, false},
+		{"network error on POST with Idempotency-Key", RetryPolicy{}, http.MethodPost, "Idempotency-Key", 0, readErr, true},
+		{"network error on POST with X-Idempotency-Key", RetryPolicy{}, http.MethodPost, "X-Idempotency-Key", 0, readErr, true},
+		{"network error on POST allowed by the policy", RetryPolicy{RetryNonIdempotent: true}, http.MethodPost, "", 0, readErr, true},
+		{"dial error on POST", RetryPolicy{}, http.MethodPost, "", 0, dialErr, true},
+		{"custom error classification", RetryPolicy{RetryableError: func(error) bool { return true }}, http.MethodGet, "", 0, errors.New("invalid request"), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, "https://api.stability.ai/v2beta/stable-image/upscale/fast", nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, "key-1")
+			}
+			var resp *http.Response
+			if tt.err == nil {
+				resp = response(req, tt.status)
+			}
+
+			if got := tt.policy.ShouldRetry(req, resp, tt.err); got != tt.want {
+				t.Errorf("ShouldRetry = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	b := NewRetryBudget(0.5, 2)
+
+	// The budget starts full
+	if !b.withdraw() || !b.withdraw() {
+		t.Fatal("withdraw from a full budget failed")
+	}
+	if b.withdraw() {
+		t.Fatal("withdraw from an empty budget succeeded")
+	}
+
+	// Two requests earn one retry
+	b.deposit()
+	if b.withdraw() {
+		t.Fatal("withdraw with half a token succeeded")
+	}
+	b.deposit()
+	b.deposit()
+	if !b.withdraw() {
+		t.Fatal("withdraw after two deposits failed")
+	}
+
+	// Deposits never raise the budget above its maximum
+	for i := 0; i < 100; i++ {
+		b.deposit()
+	}
+	withdrawn := 0
+	for b.withdraw() {
+		withdrawn++
+	}
+	if withdrawn != 2 {
+		t.Errorf("withdrew %d retries from a refilled budget, want the maximum of 2", withdrawn)
+	}
+}
+
+func TestRetryBudgetStopsRetries(t *testing.T) {
+	calls := 0
+	m := NewRetryMiddlewareWithConfig(RetryConfig{
+		MaxRetries: 3,
+		BaseDelay:  time.Millisecond,
+		MaxDelay:   time.Millisecond,
+		Budget:     NewRetryBudget(0, 1),
+	}, sequenceTransport(&calls, withStatus(http.StatusServiceUnavailable)))
+
+	req, _ := http.NewRequest(http.MethodGet, "https://api.stability.ai/v1/user/balance", nil)
+	resp, err := m.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip failed: %v", err)
+	}
+	if calls != 2 || resp.Header.Get(RetryAttemptsHeader) != "2" {
+		t.Errorf("sent %d attempts (header %q), want 2: one retry from the budget", calls, resp.Header.Get(RetryAttemptsHeader))
+	}
+}
+
+func TestRetryNonIdempotentRequests(t *testing.T) {
+	readErr := &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+	tests := []struct {
+		name  string
+		err   error
+		calls int
+	}{
+		{"error after sending", readErr, 1},
+		{"error while connecting", dialErr, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			m := NewRetryMiddleware(1, time.Millisecond, time.Millisecond,
+				sequenceTransport(&calls, withError(tt.err), withStatus(http.StatusOK)))
+
+			req, _ := http.NewRequest(http.MethodPost, "https://api.stability.ai/v2beta/stable-image/upscale/fast", strings.NewReader("body"))
+			_, err := m.RoundTrip(req)
+			if calls != tt.calls {
+				t.Errorf("sent %d attempts, want %d", calls, tt.calls)
+			}
+			if tt.calls == 1 && !errors.Is(err, readErr) {
+				t.Errorf("err = %v, want the unretried network error", err)
+			}
+		})
+	}
+}
+
+func TestRetryAfterOutranksBackoff(t *testing.T) {
+	calls := 0
+	m := NewRetryMiddleware(1, time.Millisecond, time.Millisecond,
+		sequenceTransport(&calls, withStatus(http.StatusTooManyRequests, "Retry-After", "1"), withStatus(http.StatusOK)))
+
+	req, _ := http.NewRequest(http.MethodGet, "https://api.stability.ai/v1/user/balance", nil)
+	start := time.Now()
+	resp, err := m.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %v, want the 1s Retry-After instead of the 1ms backoff", elapsed)
+	}
+	if resp.StatusCode != http.StatusOK || calls != 2 {
+		t.Errorf("status = %d after %d attempts, want 200 after 2", resp.StatusCode, calls)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	m := NewRetryMiddleware(10, 100*time.Millisecond, time.Second, nil)
+
+	for _, tt := range []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{5, time.Second},
+		{64, time.Second},
+	} {
+		// Delays are jittered by ±20%
+		delay := m.backoff(tt.attempt)
+		if delay < tt.want*8/10 || delay > tt.want*12/10 {
+			t.Errorf("backoff(%d) = %v, want %v ±20%%", tt.attempt, delay, tt.want)
+		}
+	}
+}
+
+func TestRetryCancelledBackoffClosesBody(t *testing.T) {
+	calls := 0
+	m := NewRetryMiddleware(1, time.Hour, time.Hour, sequenceTransport(&calls, withStatus(http.StatusServiceUnavailable)))
+
+	var reopened []*trackedBody
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.stability.ai/v2beta/stable-image/upscale/fast", strings.NewReader("body"))
+	req.GetBody = func() (io.ReadCloser, error) {
+		body := &trackedBody{Reader: strings.NewReader("body")}
+		reopened = append(reopened, body)
+		cancel()
+		return body, nil
+	}
+
+	_, err := m.RoundTrip(req)
+	var retryErr *apierrors.RetryError
+	if !errors.As(err, &retryErr) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want a RetryError for the cancelled backoff", err)
+	}
+	if len(reopened) != 1 || !reopened[0].isClosed() {
+		t.Errorf("the body reopened for the cancelled retry wasn't closed")
+	}
+}
@@ -0,0 +1,69 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+
+	apierrors "github.com/marcusziade/stability-go/errors"
+	"github.com/marcusziade/stability-go/telemetry"
+)
+
+// endSpan finishes the span of a request, recording its status code and attempts, or the error it failed with
+func endSpan(span telemetry.Span, resp *http.Response, err error) {
+	if resp != nil {
+		span.SetAttributes(telemetry.Int(telemetry.AttrStatusCode, resp.StatusCode))
+		if attempts, convErr := strconv.Atoi(resp.Header.Get(RetryAttemptsHeader)); convErr == nil {
+			span.SetAttributes(telemetry.Int(telemetry.AttrAttempts, attempts))
+		}
+	}
+	recordError(span, err)
+	span.End()
+}
+
+// startPollSpan starts the span of a poll for the result of an asynchronous job
+func (c *Client) startPollSpan(ctx context.Context, id string) (context.Context, telemetry.Span) {
+	return telemetry.StartSpan(c.withTelemetry(ctx), "stability.poll", telemetry.String(telemetry.AttrJobID, id))
+}
+
+// endPollSpan finishes the span of a poll, recording whether the job had finished
+func endPollSpan(span telemetry.Span, finished bool, err error) {
+	span.SetAttributes(telemetry.Bool(telemetry.AttrJobFinished, finished))
+	recordError(span, err)
+	span.End()
+}
+
+// recordError marks the span as failed with err, recording the status code and attempts of API errors
+func recordError(span telemetry.Span, err error) {
+	if err == nil {
+		return
+	}
+	if apiErr, ok := apierrors.AsAPIError(err); ok {
+		span.SetAttributes(telemetry.Int(telemetry.AttrStatusCode, apiErr.StatusCode))
+	}
+	if attempts := apierrors.Attempts(err); attempts > 0 {
+		span.SetAttributes(telemetry.Int(telemetry.AttrAttempts, attempts))
+	}
+	span.RecordError(err)
+}
+
+// readerSize returns the number of bytes left in a reader that implements io.Seeker, or -1 for other readers
+func readerSize(r io.Reader) int64 {
+	seeker, ok := r.(io.Seeker)
+	if !ok {
+		return -1
+	}
+	current, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return -1
+	}
+	end, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return -1
+	}
+	if _, err := seeker.Seek(current, io.SeekStart); err != nil {
+		return -1
+	}
+	return end - current
+}
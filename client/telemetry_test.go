@@ -0,0 +1,168 @@
+package client_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/marcusziade/stability-go/client"
+	apierrors "github.com/marcusziade/stability-go/errors"
+	"github.com/marcusziade/stability-go/stabilitytest"
+	"github.com/marcusziade/stability-go/telemetry"
+)
+
+// onlySpan returns the single recorded span with the given name
+func onlySpan(t *testing.T, tel *stabilitytest.Telemetry, name string) stabilitytest.SpanRecord {
+	t.Helper()
+	spans := tel.SpansNamed(name)
+	if len(spans) != 1 {
+		t.Fatalf("recorded %d %s spans, want 1", len(spans), name)
+	}
+	if !spans[0].Ended {
+		t.Errorf("%s span wasn't ended", name)
+	}
+	return spans[0]
+}
+
+// checkAttributes checks the attributes of a span
+func checkAttributes(t *testing.T, span stabilitytest.SpanRecord, want map[string]interface{}) {
+	t.Helper()
+	for key, value := range want {
+		if got := span.Attributes[key]; got != value {
+			t.Errorf("%s: %s = %v, want %v", span.Name, key, got, value)
+		}
+	}
+}
+
+// fastUpscale is a fast upscale of a 100x100 image
+func fastUpscale(t *testing.T) client.UpscaleRequest {
+	return client.UpscaleRequest{Image: testImage(t, 100, 100), Filename: "input.png", Type: client.UpscaleTypeFast}
+}
+
+func TestTelemetrySuccess(t *testing.T) {
+	server := stabilitytest.NewServer(stabilitytest.ServerConfig{})
+	defer server.Close()
+
+	tel := stabilitytest.NewTelemetry()
+	stClient := server.NewClient().WithTracer(tel).WithMeter(tel).
+		WithMiddleware(client.Retry(2, time.Millisecond, time.Millisecond))
+
+	request := fastUpscale(t)
+	if _, err := stClient.Upscale(context.Background(), request); err != nil {
+		t.Fatalf("Upscale failed: %v", err)
+	}
+
+	upscale := onlySpan(t, tel, "stability.upscale")
+	checkAttributes(t, upscale, map[string]interface{}{
+		telemetry.AttrUpscaleType:  "fast",
+		telemetry.AttrImageBytes:   int64(len(request.Image)),
+		telemetry.AttrStatusCode:   200,
+		telemetry.AttrAttempts:     1,
+		telemetry.AttrRequestID:    "req-1",
+		telemetry.AttrFinishReason: client.FinishReasonSuccess,
+	})
+	if upscale.ParentID != 0 || len(upscale.Errors) != 0 {
+		t.Errorf("upscale span = %+v, want a root span without errors", upscale)
+	}
+
+	// The middleware reports as a child of the upscale
+	retry := onlySpan(t, tel, "stability.middleware.retry")
+	checkAttributes(t, retry, map[string]interface{}{telemetry.AttrStatusCode: 200, telemetry.AttrAttempts: 1})
+	if retry.ParentID != upscale.ID {
+		t.Errorf("retry span parent = %d, want the upscale span %d", retry.ParentID, upscale.ID)
+	}
+
+	for name, want := range map[string]float64{
+		telemetry.MetricClientRequests: 1,
+		telemetry.MetricClientRetries:  0,
+		telemetry.MetricUpscaleCredits: client.UpscaleCredits[client.UpscaleTypeFast],
+	} {
+		if got := tel.Sum(name); got != want {
+			t.Errorf("%s = %v, want %v", name, got, want)
+		}
+	}
+	for _, m := range tel.Measurements() {
+		if m.Name == telemetry.MetricClientRequests &&
+			(m.Attributes[telemetry.AttrOperation] != "upscale" || m.Attributes[telemetry.AttrStatusCode] != 200) {
+			t.Errorf("%s attributes = %v, want operation upscale and status 200", m.Name, m.Attributes)
+		}
+	}
+}
+
+func TestTelemetryRetry(t *testing.T) {
+	server := stabilitytest.NewServer(stabilitytest.ServerConfig{})
+	defer server.Close()
+	server.InjectFault(stabilitytest.ServerErrorFault(client.UpscaleFastPath))
+
+	tel := stabilitytest.NewTelemetry()
+	stClient := server.NewClient().WithTracer(tel).WithMeter(tel).
+		WithMiddleware(client.Retry(2, time.Millisecond, time.Millisecond))
+
+	if _, err := stClient.Upscale(context.Background(), fastUpscale(t)); err != nil {
+		t.Fatalf("Upscale failed: %v", err)
+	}
+
+	// Both spans report the attempts it took and the request ID of the one that succeeded
+	checkAttributes(t, onlySpan(t, tel, "stability.upscale"), map[string]interface{}{
+		telemetry.AttrStatusCode: 200,
+		telemetry.AttrAttempts:   2,
+		telemetry.AttrRequestID:  "req-2",
+	})
+	checkAttributes(t, onlySpan(t, tel, "stability.middleware.retry"), map[string]interface{}{
+		telemetry.AttrStatusCode: 200,
+		telemetry.AttrAttempts:   2,
+	})
+	if retries := tel.Sum(telemetry.MetricClientRetries); retries != 1 {
+		t.Errorf("%s = %v, want 1", telemetry.MetricClientRetries, retries)
+	}
+}
+
+func TestTelemetryCircuitOpen(t *testing.T) {
+	server := stabilitytest.NewServer(stabilitytest.ServerConfig{})
+	defer server.Close()
+	server.InjectFault(stabilitytest.ServerErrorFault(client.UpscaleFastPath))
+
+	// A single failure trips the breaker for an hour
+	breaker := client.NewCircuitBreaker(client.CircuitBreakerConfig{MinRequests: 1, FailureRate: 0.5, Cooldown: time.Hour})
+	tel := stabilitytest.NewTelemetry()
+	stClient := server.NewClient().WithTracer(tel).WithMeter(tel).WithMiddleware(client.CircuitBreak(breaker))
+
+	if _, err := stClient.Upscale(context.Background(), fastUpscale(t)); err == nil {
+		t.Fatal("Upscale succeeded, want the injected 500")
+	}
+	checkAttributes(t, onlySpan(t, tel, "stability.middleware.circuit_breaker"), map[string]interface{}{
+		telemetry.AttrCircuitState: "closed",
+		telemetry.AttrStatusCode:   500,
+	})
+	tel.Reset()
+
+	// The next request is rejected without reaching the server
+	_, err := stClient.Upscale(context.Background(), fastUpscale(t))
+	if !apierrors.IsCircuitOpen(err) {
+		t.Fatalf("err = %v, want a CircuitOpenError", err)
+	}
+
+	rejected := onlySpan(t, tel, "stability.middleware.circuit_breaker")
+	checkAttributes(t, rejected, map[string]interface{}{telemetry.AttrCircuitState: "open"})
+	if len(rejected.Errors) != 1 || !apierrors.IsCircuitOpen(rejected.Errors[0]) {
+		t.Errorf("circuit breaker span errors = %v, want the CircuitOpenError", rejected.Errors)
+	}
+	if _, ok := rejected.Attributes[telemetry.AttrStatusCode]; ok {
+		t.Errorf("circuit breaker span has status code %v, want none for a request never sent", rejected.Attributes[telemetry.AttrStatusCode])
+	}
+
+	upscale := onlySpan(t, tel, "stability.upscale")
+	if len(upscale.Errors) != 1 || rejected.ParentID != upscale.ID {
+		t.Errorf("upscale span = %+v, want the rejection recorded on the parent of the circuit breaker span", upscale)
+	}
+
+	if rejections := tel.Sum(telemetry.MetricCircuitRejected); rejections != 1 {
+		t.Errorf("%s = %v, want 1", telemetry.MetricCircuitRejected, rejections)
+	}
+	if credits := tel.Sum(telemetry.MetricUpscaleCredits); credits != 0 {
+		t.Errorf("%s = %v, want no credits for a rejected request", telemetry.MetricUpscaleCredits, credits)
+	}
+	if requests := len(server.Requests()); requests != 1 {
+		t.Errorf("server received %d requests, want only the one that tripped the breaker", requests)
+	}
+}
@@ -0,0 +1,367 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/marcusziade/stability-go/internal/utils"
+)
+
+// Default tiled upscale settings
+const (
+	DefaultTileSize        = 512
+	DefaultTileOverlap     = 64
+	DefaultTileConcurrency = 4
+)
+
+// TiledUpscaleRequest represents the parameters for upscaling an image too large for the API in overlapping tiles
+type TiledUpscaleRequest struct {
+	// The image to upscale (binary data); it may exceed the upscaler's input limits
+	Image []byte
+	// The upscale type used for each tile: fast or conservative (defaults to conservative).
+	// Creative upscales reinvent the details of each tile, so neighbouring tiles would not line up.
+	Type UpscaleType
+	// The prompt to guide each tile (required for conservative)
+	Prompt string
+	// Optional negative prompt
+	NegativePrompt string
+	// Optional seed value, shared by every tile
+	Seed int64
+	// Creativity level (0.2-0.5 for conservative)
+	Creativity float64
+	// Output format of the stitched image (png or jpeg, defaults to png)
+	OutputFormat OutputFormat
+	// Width and height of the tiles in input pixels (defaults to DefaultTileSize)
+	TileSize int
+	// Number of input pixels neighbouring tiles share, blended across in the output (defaults to DefaultTileOverlap)
+	Overlap int
+	// Maximum number of tiles upscaled at once (defaults to DefaultTileConcurrency)
+	Concurrency int
+	// Whether to stitch tiles blurred by the content filter into the image, setting ContentFiltered on the response,
+	// instead of failing with an *errors.ContentFilteredError
+	AllowContentFiltered bool
+}
+
+// tiledImageLimits are the limits of the whole image of a tiled upscale; only the tiles have to fit the upscaler
+var tiledImageLimits = ImageLimits{MinSide: 64, MaxBytes: -1}
+
+// withDefaults returns the request with zero values replaced by the defaults
+func (r TiledUpscaleRequest) withDefaults() TiledUpscaleRequest {
+	if r.Type == "" {
+		r.Type = UpscaleTypeConservative
+	}
+	if r.OutputFormat == "" {
+		r.OutputFormat = OutputFormatPNG
+	}
+	if r.TileSize == 0 {
+		r.TileSize = DefaultTileSize
+	}
+	if r.Overlap == 0 {
+		r.Overlap = DefaultTileOverlap
+	}
+	if r.Concurrency == 0 {
+		r.Concurrency = DefaultTileConcurrency
+	}
+	return r
+}
+
+// Validate checks the request against the documented limits of the upscaler used for the tiles
+func (r TiledUpscaleRequest) Validate() error {
+	r = r.withDefaults()
+
+	var v validator
+	v.image("image", r.Image, tiledImageLimits, true)
+
+	var limits ImageLimits
+	switch r.Type {
+	case UpscaleTypeFast:
+		limits = FastUpscaleImageLimits
+	case UpscaleTypeConservative:
+		limits = ConservativeUpscaleImageLimits
+		v.prompt("prompt", r.Prompt, true)
+		v.prompt("negative_prompt", r.NegativePrompt, false)
+		v.between("creativity", r.Creativity, 0.2, 0.5)
+	default:
+		v.add("type", "tiled upscale supports fast and conservative, not %q", r.Type)
+	}
+
+	// The largest square tile the upscaler accepts
+	maxTile := int(math.Sqrt(float64(limits.MaxPixels)))
+	if limits.MaxSide > 0 && limits.MaxSide < maxTile {
+		maxTile = limits.MaxSide
+	}
+	if maxTile > 0 && (r.TileSize < limits.MinSide || r.TileSize > maxTile) {
+		v.add("tile_size", "must be between %d and %d for %s upscale", limits.MinSide, maxTile, r.Type)
+	}
+	// Tiles are cut at the tile size, or the image size along shorter sides, so every tile has the same
+	// dimensions; check them against the upscaler before a single tile is sent
+	if width, height, _, err := utils.ImageDimensions(r.Image); err == nil && maxTile > 0 {
+		// Orientations 5-8 swap the width and height
+		if utils.ExifOrientation(r.Image) >= 5 {
+			width, height = height, width
+		}
+		v.dimensions("tile", min(r.TileSize, width), min(r.TileSize, height), limits)
+	}
+	if r.Overlap < 0 || r.Overlap >= r.TileSize/2 {
+		v.add("tile_overlap", "must be at least 0 and less than half the tile size")
+	}
+	if r.Concurrency < 0 {
+		v.add("concurrency", "must not be negative")
+	}
+
+	v.seed(r.Seed)
+	v.outputFormat(r.OutputFormat, OutputFormatPNG, OutputFormatJPEG)
+
+	return v.err("tiled upscale")
+}
+
+// tile is the part of the input image upscaled by one request
+type tile struct {
+	// Position of the tile in the grid
+	column, row int
+	// Area of the tile in the input image
+	bounds image.Rectangle
+}
+
+// UpscaleTiled upscales an image larger than the upscaler accepts by splitting it into overlapping tiles,
+// upscaling the tiles concurrently and stitching the results back together, blending across the overlaps.
+// Every tile is an ordinary Upscale request, so it goes through the client's middleware, including rate limiting.
+func (c *Client) UpscaleTiled(ctx context.Context, request TiledUpscaleRequest) (*UpscaleResponse, error) {
+	if err := request.Validate(); err != nil {
+		return nil, err
+	}
+	request = request.withDefaults()
+	start := time.Now()
+
+	decoded, _, err := image.Decode(bytes.NewReader(request.Image))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	img := toRGBA(utils.Orient(decoded, utils.ExifOrientation(request.Image)))
+
+	bounds := img.Bounds()
+	columns := tileOffsets(bounds.Dx(), request.TileSize, request.Overlap)
+	rows := tileOffsets(bounds.Dy(), request.TileSize, request.Overlap)
+	tileWidth, tileHeight := min(request.TileSize, bounds.Dx()), min(request.TileSize, bounds.Dy())
+
+	tiles := make([]tile, 0, len(columns)*len(rows))
+	for row, y := range rows {
+		for column, x := range columns {
+			tiles = append(tiles, tile{
+				column: column,
+				row:    row,
+				bounds: image.Rect(x, y, x+tileWidth, y+tileHeight).Add(bounds.Min),
+			})
+		}
+	}
+
+	results, metadata, err := c.upscaleTiles(ctx, request, img, tiles)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every tile has the same size, so the first one tells the scale of the upscaler
+	scaleX := float64(results[0].Bounds().Dx()) / float64(tileWidth)
+	scaleY := float64(results[0].Bounds().Dy()) / float64(tileHeight)
+
+	output := stitchTiles(bounds, tiles, results, columns, rows, tileWidth, tileHeight, scaleX, scaleY)
+
+	var b bytes.Buffer
+	mimeType := "image/png"
+	if request.OutputFormat == OutputFormatJPEG {
+		mimeType = "image/jpeg"
+		err = jpeg.Encode(&b, output, &jpeg.Options{Quality: 95})
+	} else {
+		err = png.Encode(&b, output)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode upscaled image: %w", err)
+	}
+
+	metadata.Duration = time.Since(start)
+	return &UpscaleResponse{
+		ImageData: b.Bytes(),
+		MimeType:  mimeType,
+		Metadata:  metadata,
+		// Only set when AllowContentFiltered let filtered tiles through
+		ContentFiltered: metadata.FinishReason == FinishReasonContentFiltered,
+	}, nil
+}
+
+// upscaleTiles upscales the tiles with at most request.Concurrency requests at once, returning the results with
+// the attempts and credits of every tile added up. The first failure cancels the tiles that are still running.
+func (c *Client) upscaleTiles(ctx context.Context, request TiledUpscaleRequest, img *image.RGBA, tiles []tile) ([]image.Image, *Metadata, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+		results  = make([]image.Image, len(tiles))
+		metadata = make([]*Metadata, len(tiles))
+		slots    = make(chan struct{}, request.Concurrency)
+	)
+	for i := range tiles {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			case <-ctx.Done():
+				return
+			}
+
+			result, tileMetadata, err := c.upscaleTile(ctx, request, img, tiles[i])
+			if err != nil {
+				once.Do(func() {
+					firstErr = fmt.Errorf("failed to upscale tile %d,%d: %w", tiles[i].column, tiles[i].row, err)
+					cancel()
+				})
+				return
+			}
+			results[i], metadata[i] = result, tileMetadata
+		}(i)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	// Every tile shares the seed of the request, but has its own request ID
+	total := &Metadata{Seed: request.Seed, FinishReason: FinishReasonSuccess}
+	for _, m := range metadata {
+		total.Attempts += m.Attempts
+		total.Credits += m.Credits
+		if m.FinishReason == FinishReasonContentFiltered {
+			total.FinishReason = FinishReasonContentFiltered
+		}
+	}
+	return results, total, nil
+}
+
+// upscaleTile sends one tile to the upscaler and decodes the result
+func (c *Client) upscaleTile(ctx context.Context, request TiledUpscaleRequest, img *image.RGBA, t tile) (image.Image, *Metadata, error) {
+	var b bytes.Buffer
+	if err := png.Encode(&b, img.SubImage(t.bounds)); err != nil {
+		return nil, nil, fmt.Errorf("failed to encode tile: %w", err)
+	}
+
+	response, err := c.Upscale(ctx, UpscaleRequest{
+		Image:          b.Bytes(),
+		Filename:       fmt.Sprintf("tile-%d-%d.png", t.column, t.row),
+		Type:           request.Type,
+		Prompt:         request.Prompt,
+		NegativePrompt: request.NegativePrompt,
+		Seed:           request.Seed,
+		Creativity:     request.Creativity,
+		// Tiles are blended and re-encoded, so request them lossless
+		OutputFormat:         OutputFormatPNG,
+		AllowContentFiltered: request.AllowContentFiltered,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result, _, err := image.Decode(bytes.NewReader(response.ImageData))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode upscaled tile: %w", err)
+	}
+	return result, response.Metadata, nil
+}
+
+// tileOffsets returns where the tiles start along an axis of the given length.
+// Tiles overlap by at least overlap pixels, and the last tile is moved back to end at the edge.
+func tileOffsets(length, size, overlap int) []int {
+	if length <= size {
+		return []int{0}
+	}
+
+	var offsets []int
+	for offset := 0; ; offset += size - overlap {
+		if offset+size >= length {
+			return append(offsets, length-size)
+		}
+		offsets = append(offsets, offset)
+	}
+}
+
+// stitchTiles draws the upscaled tiles in rows, feathering each tile into the tiles above and to its left
+// across the pixels they share
+func stitchTiles(bounds image.Rectangle, tiles []tile, results []image.Image, columns, rows []int,
+	tileWidth, tileHeight int, scaleX, scaleY float64) *image.RGBA {
+	output := image.NewRGBA(image.Rect(0, 0, scaled(bounds.Dx(), scaleX), scaled(bounds.Dy(), scaleY)))
+
+	for i, t := range tiles {
+		x0, y0 := scaled(columns[t.column], scaleX), scaled(rows[t.row], scaleY)
+		x1, y1 := scaled(columns[t.column]+tileWidth, scaleX), scaled(rows[t.row]+tileHeight, scaleY)
+		dst := image.Rect(x0, y0, x1, y1)
+
+		// Upscalers may round the output size differently, so make every tile fit its place exactly
+		result := results[i]
+		if result.Bounds().Dx() != dst.Dx() || result.Bounds().Dy() != dst.Dy() {
+			result = utils.Resize(result, dst.Dx(), dst.Dy())
+		}
+
+		// Number of output pixels shared with the previous tile in the row and column
+		var overlapX, overlapY int
+		if t.column > 0 {
+			overlapX = scaled(columns[t.column-1]+tileWidth, scaleX) - x0
+		}
+		if t.row > 0 {
+			overlapY = scaled(rows[t.row-1]+tileHeight, scaleY) - y0
+		}
+
+		draw.DrawMask(output, dst, result, result.Bounds().Min, featherMask(dst.Dx(), dst.Dy(), overlapX, overlapY), image.Point{}, draw.Over)
+	}
+	return output
+}
+
+// featherMask returns a mask that fades a tile in from transparent across its left and top overlaps
+func featherMask(width, height, overlapX, overlapY int) *image.Alpha {
+	mask := image.NewAlpha(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		weightY := 1.0
+		if y < overlapY {
+			weightY = (float64(y) + 0.5) / float64(overlapY)
+		}
+		for x := 0; x < width; x++ {
+			weight := weightY
+			if x < overlapX {
+				weight *= (float64(x) + 0.5) / float64(overlapX)
+			}
+			mask.Pix[y*mask.Stride+x] = uint8(math.Round(weight * 255))
+		}
+	}
+	return mask
+}
+
+// scaled returns a length in input pixels in output pixels
+func scaled(length int, scale float64) int {
+	return int(math.Round(float64(length) * scale))
+}
+
+// toRGBA returns the image as an *image.RGBA, converting it if necessary
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba
+	}
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+	return rgba
+}
@@ -0,0 +1,194 @@
+package client
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"testing"
+)
+
+func TestTileOffsets(t *testing.T) {
+	tests := []struct {
+		name                  string
+		length, size, overlap int
+		want                  []int
+	}{
+		{"shorter than a tile", 100, 512, 64, []int{0}},
+		{"exactly one tile", 512, 512, 64, []int{0}},
+		{"multiple of the stride", 960, 512, 64, []int{0, 448}},
+		{"last tile moved back to the edge", 1000, 512, 64, []int{0, 448, 488}},
+		{"several tiles", 1500, 512, 64, []int{0, 448, 896, 988}},
+		{"one pixel past a tile", 513, 512, 64, []int{0, 1}},
+		{"no overlap", 1024, 512, 0, []int{0, 512}},
+		{"no overlap, last tile moved back", 1100, 512, 0, []int{0, 512, 588}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tileOffsets(tt.length, tt.size, tt.overlap)
+			if len(got) != len(tt.want) {
+				t.Fatalf("tileOffsets(%d, %d, %d) = %v, want %v", tt.length, tt.size, tt.overlap, got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("tileOffsets(%d, %d, %d) = %v, want %v", tt.length, tt.size, tt.overlap, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestTileOffsetsCoverAxis(t *testing.T) {
+	const size, overlap = 512, 64
+	for length := size + 1; length <= 4*size; length += 37 {
+		offsets := tileOffsets(length, size, overlap)
+
+		// The tiles start at the first pixel, end at the last and share at least overlap pixels with each other
+		if offsets[0] != 0 || offsets[len(offsets)-1]+size != length {
+			t.Errorf("length %d: offsets %v don't span the axis", length, offsets)
+		}
+		for i := 1; i < len(offsets); i++ {
+			if step := offsets[i] - offsets[i-1]; step <= 0 || step > size-overlap {
+				t.Errorf("length %d: offsets %v overlap by %d pixels, want at least %d", length, offsets, size-step, overlap)
+			}
+		}
+	}
+}
+
+func TestFeatherMask(t *testing.T) {
+	const width, height, overlapX, overlapY = 40, 30, 8, 6
+	mask := featherMask(width, height, overlapX, overlapY)
+
+	if bounds := mask.Bounds(); bounds.Dx() != width || bounds.Dy() != height {
+		t.Fatalf("mask is %v, want %dx%d", bounds, width, height)
+	}
+
+	// Outside the overlaps the tile is drawn as is
+	for y := overlapY; y < height; y++ {
+		for x := overlapX; x < width; x++ {
+			if a := mask.AlphaAt(x, y).A; a != 255 {
+				t.Fatalf("alpha at %d,%d = %d, want 255", x, y, a)
+			}
+		}
+	}
+
+	// Across an overlap the tile fades in as the previous one fades out, so the ramp is symmetric:
+	// the weights of the two tiles sum to 1 at every pixel
+	for x := 0; x < overlapX; x++ {
+		a, mirrored := int(mask.AlphaAt(x, height-1).A), int(mask.AlphaAt(overlapX-1-x, height-1).A)
+		if a+mirrored < 254 || a+mirrored > 256 {
+			t.Errorf("alphas at x=%d and x=%d = %d and %d, want them to sum to 255", x, overlapX-1-x, a, mirrored)
+		}
+		if x > 0 && a <= int(mask.AlphaAt(x-1, height-1).A) {
+			t.Errorf("alpha at x=%d = %d, want it to increase across the overlap", x, a)
+		}
+	}
+	for y := 0; y < overlapY; y++ {
+		a, mirrored := int(mask.AlphaAt(width-1, y).A), int(mask.AlphaAt(width-1, overlapY-1-y).A)
+		if a+mirrored < 254 || a+mirrored > 256 {
+			t.Errorf("alphas at y=%d and y=%d = %d and %d, want them to sum to 255", y, overlapY-1-y, a, mirrored)
+		}
+	}
+
+	// Where both overlaps meet the weights multiply
+	want := uint8(math.Round(255 * (0.5 / overlapX) * (0.5 / overlapY)))
+	if a := mask.AlphaAt(0, 0).A; a != want {
+		t.Errorf("alpha at the corner = %d, want %d", a, want)
+	}
+
+	// Tiles without neighbours are drawn as is
+	for _, a := range featherMask(4, 4, 0, 0).Pix {
+		if a != 255 {
+			t.Fatalf("mask without overlaps has alpha %d, want 255", a)
+		}
+	}
+}
+
+// tileGrid cuts an image of the given size into tiles like UpscaleTiled does
+func tileGrid(width, height, size, overlap int) (tiles []tile, columns, rows []int, tileWidth, tileHeight int) {
+	columns, rows = tileOffsets(width, size, overlap), tileOffsets(height, size, overlap)
+	tileWidth, tileHeight = min(size, width), min(size, height)
+	for row, y := range rows {
+		for column, x := range columns {
+			tiles = append(tiles, tile{column: column, row: row, bounds: image.Rect(x, y, x+tileWidth, y+tileHeight)})
+		}
+	}
+	return tiles, columns, rows, tileWidth, tileHeight
+}
+
+// uniformImage returns an opaque image of a single gray level
+func uniformImage(width, height int, gray uint8) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{gray, gray, gray, 255}), image.Point{}, draw.Src)
+	return img
+}
+
+func TestStitchTiles(t *testing.T) {
+	tests := []struct {
+		name                  string
+		width, height         int
+		size, overlap         int
+		resultW, resultH      int
+		wantWidth, wantHeight int
+		// Whether the tiles after the first come back a pixel short
+		short bool
+	}{
+		{"single tile", 300, 200, 512, 64, 1200, 800, 1200, 800, false},
+		{"grid", 1000, 700, 512, 64, 1024, 1024, 2000, 1400, false},
+		// Upscalers may round the size of a tile differently; the tiles are fitted to the grid
+		{"results a pixel short", 1000, 700, 512, 64, 1024, 1024, 2000, 1400, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tiles, columns, rows, tileWidth, tileHeight := tileGrid(tt.width, tt.height, tt.size, tt.overlap)
+			results := make([]image.Image, len(tiles))
+			for i := range results {
+				if i > 0 && tt.short {
+					results[i] = uniformImage(tt.resultW-1, tt.resultH-1, 200)
+					continue
+				}
+				results[i] = uniformImage(tt.resultW, tt.resultH, 200)
+			}
+			scaleX := float64(tt.resultW) / float64(tileWidth)
+			scaleY := float64(tt.resultH) / float64(tileHeight)
+
+			output := stitchTiles(image.Rect(0, 0, tt.width, tt.height), tiles, results, columns, rows, tileWidth, tileHeight, scaleX, scaleY)
+			if bounds := output.Bounds(); bounds.Dx() != tt.wantWidth || bounds.Dy() != tt.wantHeight {
+				t.Fatalf("output is %dx%d, want %dx%d", bounds.Dx(), bounds.Dy(), tt.wantWidth, tt.wantHeight)
+			}
+
+			// Every pixel is covered with a total weight of 1, so identical tiles stitch without seams
+			for y := 0; y < tt.wantHeight; y++ {
+				for x := 0; x < tt.wantWidth; x++ {
+					if c := output.RGBAAt(x, y); c.A != 255 || c.R < 199 || c.R > 201 {
+						t.Fatalf("pixel %d,%d = %v, want opaque gray 200", x, y, c)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestStitchTilesBlendsOverlap(t *testing.T) {
+	// Two tiles share 64 input pixels: a black one on the left and a white one on the right
+	tiles, columns, rows, tileWidth, tileHeight := tileGrid(960, 100, 512, 64)
+	results := []image.Image{uniformImage(512, 100, 0), uniformImage(512, 100, 255)}
+
+	output := stitchTiles(image.Rect(0, 0, 960, 100), tiles, results, columns, rows, tileWidth, tileHeight, 1, 1)
+
+	// Outside the overlap each tile is drawn as is; across it the left tile fades into the right one
+	for x := 0; x < 960; x++ {
+		c := output.RGBAAt(x, 50)
+		switch {
+		case x < 448 && c.R != 0, x >= 512 && c.R != 255:
+			t.Fatalf("pixel %d = %v, want the color of its tile", x, c)
+		case x > 448 && x < 512 && c.R < output.RGBAAt(x-1, 50).R:
+			t.Fatalf("pixel %d = %v, darker than the pixel before it in the overlap", x, c)
+		}
+	}
+	if mid := output.RGBAAt(480, 50).R; mid < 120 || mid > 135 {
+		t.Errorf("middle of the overlap = %d, want an even blend", mid)
+	}
+}
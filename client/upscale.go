@@ -1,15 +1,17 @@
 package client
 
 import (
-	"bytes"
 	"context"
-	"encoding/base64"
-	"encoding/json"
 	"fmt"
 	"io"
-	"mime/multipart"
 	"net/http"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
+
+	apierrors "github.com/marcusziade/stability-go/errors"
+	"github.com/marcusziade/stability-go/telemetry"
 )
 
 // Upscale API endpoints
@@ -17,7 +19,8 @@ const (
 	UpscaleConservativePath = "/v2beta/stable-image/upscale/conservative"
 	UpscaleCreativePath     = "/v2beta/stable-image/upscale/creative"
 	UpscaleFastPath         = "/v2beta/stable-image/upscale/fast"
-	CreativeResultPath      = "/v2beta/stable-image/upscale/result" // For polling creative results
+	// Deprecated: creative results are polled through ResultPath like any other async job
+	CreativeResultPath = "/v2beta/stable-image/upscale/result"
 )
 
 // UpscaleType represents the available upscaling methods
@@ -65,6 +68,10 @@ const (
 type UpscaleRequest struct {
 	// The image to upscale (binary data)
 	Image []byte
+	// Streams the image to upscale instead of Image, so large images are never held in memory (e.g., an *os.File
+	// or an fs.File). Readers that implement io.Seeker are validated and rewound for retries; other readers are
+	// sent unvalidated and can't be retried once sent. The caller closes the reader.
+	ImageReader io.Reader
 	// The filename of the image
 	Filename string
 	// The upscale type to use
@@ -83,6 +90,11 @@ type UpscaleRequest struct {
 	StylePreset StylePreset
 	// Whether to return image as base64 JSON instead of binary
 	ReturnAsJSON bool
+	// Whether to downsize, rotate and re-encode the image to fit the endpoint's limits (see PreprocessImage)
+	Preprocess bool
+	// Whether to return an image blurred by the content filter with ContentFiltered set on the response,
+	// instead of failing with an *errors.ContentFilteredError
+	AllowContentFiltered bool
 }
 
 // UpscaleResponse represents the response from the upscale API for fast and conservative modes
@@ -93,6 +105,13 @@ type UpscaleResponse struct {
 	MimeType string
 	// For creative upscale, this will contain the ID for polling
 	CreativeID string
+	// What was changed to fit the image within the endpoint's limits (only set when Preprocess is requested)
+	Preprocessing *PreprocessResult
+	// The seed, request ID, timing and credits of the request
+	Metadata *Metadata
+	// Whether the content filter blurred the image (finish reason CONTENT_FILTERED). Upscale only returns
+	// such images when AllowContentFiltered is set; PollCreativeResult and WaitForCreativeResult always do.
+	ContentFiltered bool
 }
 
 // CreativeAsyncResponse represents the ID returned by the creative upscale endpoint
@@ -102,30 +121,98 @@ type CreativeAsyncResponse struct {
 }
 
 // UpscaleResultResponse represents the final response from the creative upscale polling endpoint
-type UpscaleResultResponse struct {
-	// Whether the upscale is finished
-	Finished bool `json:"finished"`
-	// The base64 encoded image data (only present when finished is true)
-	Image string `json:"image,omitempty"`
-	// The image type (only present when finished is true)
-	Type string `json:"mime_type,omitempty"`
-	// Any error that occurred during processing
-	Error string `json:"error,omitempty"`
-}
+type UpscaleResultResponse = ResultResponse
 
 // ErrorResponse represents an error response from the API
-type ErrorResponse struct {
-	ID      string `json:"id"`
-	Name    string `json:"name"`
-	Message string `json:"message"`
-	Errors  []struct {
-		Code    string `json:"code"`
-		Message string `json:"message"`
-	} `json:"errors,omitempty"`
+type ErrorResponse = apierrors.APIError
+
+// imageLimits returns the image limits of the upscale endpoint for the request's type
+func (r UpscaleRequest) imageLimits() ImageLimits {
+	switch r.Type {
+	case UpscaleTypeFast:
+		return FastUpscaleImageLimits
+	case UpscaleTypeConservative:
+		return ConservativeUpscaleImageLimits
+	default:
+		return CreativeUpscaleImageLimits
+	}
+}
+
+// Validate checks the request against the documented limits of the upscale endpoint for its type.
+// With Preprocess set, images that are too large pass, since they are downsized before sending.
+func (r UpscaleRequest) Validate() error {
+	var v validator
+
+	limits := r.imageLimits()
+	if r.Preprocess {
+		limits = limits.fittable()
+	}
+
+	if r.ImageReader != nil {
+		v.imageReader("image", r.ImageReader, limits)
+	} else {
+		v.image("image", r.Image, limits, true)
+	}
+
+	switch r.Type {
+	case UpscaleTypeFast:
+	case UpscaleTypeConservative:
+		v.prompt("prompt", r.Prompt, true)
+		v.prompt("negative_prompt", r.NegativePrompt, false)
+		v.between("creativity", r.Creativity, 0.2, 0.5)
+	case UpscaleTypeCreative:
+		v.prompt("prompt", r.Prompt, true)
+		v.prompt("negative_prompt", r.NegativePrompt, false)
+		v.between("creativity", r.Creativity, 0.1, 0.5)
+	default:
+		v.add("type", "invalid upscale type %q", r.Type)
+	}
+
+	v.seed(r.Seed)
+	v.outputFormat(r.OutputFormat)
+
+	return v.err("upscale")
 }
 
 // Upscale upscales an image using the specified parameters
 func (c *Client) Upscale(ctx context.Context, request UpscaleRequest) (*UpscaleResponse, error) {
+	imageBytes := int64(len(request.Image))
+	if request.ImageReader != nil {
+		imageBytes = readerSize(request.ImageReader)
+	}
+
+	ctx = c.withTelemetry(ctx)
+	ctx, span := telemetry.StartSpan(ctx, "stability.upscale",
+		telemetry.String(telemetry.AttrUpscaleType, string(request.Type)),
+		telemetry.Int64(telemetry.AttrImageBytes, imageBytes))
+	defer span.End()
+
+	response, err := c.upscale(ctx, request)
+	if err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+
+	m := response.Metadata
+	span.SetAttributes(telemetry.Int(telemetry.AttrStatusCode, http.StatusOK), telemetry.Int(telemetry.AttrAttempts, m.Attempts))
+	if m.RequestID != "" {
+		span.SetAttributes(telemetry.String(telemetry.AttrRequestID, m.RequestID))
+	}
+	// Creative upscales only report a finish reason once the job has finished
+	if m.FinishReason != "" {
+		span.SetAttributes(telemetry.String(telemetry.AttrFinishReason, m.FinishReason))
+	}
+	// Creative upscales are charged once the job is accepted, so their credits are counted here, not when the result arrives
+	telemetry.Add(ctx, telemetry.MetricUpscaleCredits, m.Credits, telemetry.String(telemetry.AttrUpscaleType, string(request.Type)))
+	return response, nil
+}
+
+// upscale sends an upscale request
+func (c *Client) upscale(ctx context.Context, request UpscaleRequest) (*UpscaleResponse, error) {
+	if err := request.Validate(); err != nil {
+		return nil, err
+	}
+
 	var endpoint string
 
 	// Determine the endpoint based on upscale type
@@ -140,6 +227,29 @@ func (c *Client) Upscale(ctx context.Context, request UpscaleRequest) (*UpscaleR
 		return nil, fmt.Errorf("invalid upscale type: %s", request.Type)
 	}
 
+	var preprocessing *PreprocessResult
+	if request.Preprocess {
+		// Preprocessing decodes the whole image anyway, so streamed images are read into memory
+		if request.ImageReader != nil {
+			data, err := io.ReadAll(request.ImageReader)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read image: %w", err)
+			}
+			request.Image, request.ImageReader = data, nil
+		}
+
+		image, result, err := PreprocessImage(request.Image, request.imageLimits())
+		if err != nil {
+			return nil, fmt.Errorf("failed to preprocess image: %w", err)
+		}
+		if result.Format != result.OriginalFormat {
+			request.Filename = strings.TrimSuffix(request.Filename, filepath.Ext(request.Filename)) +
+				ExtensionForMimeType("image/"+result.Format)
+		}
+		request.Image = image
+		preprocessing = result
+	}
+
 	// Create form fields based on the upscale type
 	fields := map[string]string{}
 
@@ -150,10 +260,6 @@ func (c *Client) Upscale(ctx context.Context, request UpscaleRequest) (*UpscaleR
 
 	// Add type-specific fields
 	if request.Type != UpscaleTypeFast {
-		// Conservative and Creative require prompt
-		if request.Prompt == "" {
-			return nil, fmt.Errorf("prompt is required for %s upscale", request.Type)
-		}
 		fields["prompt"] = request.Prompt
 
 		if request.NegativePrompt != "" {
@@ -165,13 +271,6 @@ func (c *Client) Upscale(ctx context.Context, request UpscaleRequest) (*UpscaleR
 		}
 
 		if request.Creativity > 0 {
-			// Validate creativity range
-			if request.Type == UpscaleTypeConservative && (request.Creativity < 0.2 || request.Creativity > 0.5) {
-				return nil, fmt.Errorf("creativity for conservative upscale must be between 0.2 and 0.5")
-			} else if request.Type == UpscaleTypeCreative && (request.Creativity < 0.1 || request.Creativity > 0.5) {
-				return nil, fmt.Errorf("creativity for creative upscale must be between 0.1 and 0.5")
-			}
-
 			fields["creativity"] = strconv.FormatFloat(request.Creativity, 'f', 2, 64)
 		}
 
@@ -181,171 +280,88 @@ func (c *Client) Upscale(ctx context.Context, request UpscaleRequest) (*UpscaleR
 		}
 	}
 
-	// Create multipart request body
-	body := new(bytes.Buffer)
-	writer := multipart.NewWriter(body)
-
-	// Add the file part
-	part, err := writer.CreateFormFile("image", request.Filename)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create form file: %w", err)
-	}
-	if _, err := part.Write(request.Image); err != nil {
-		return nil, fmt.Errorf("failed to write file data: %w", err)
-	}
-
-	// Add other form fields
-	for key, value := range fields {
-		if err := writer.WriteField(key, value); err != nil {
-			return nil, fmt.Errorf("failed to write form field %s: %w", key, err)
-		}
-	}
-
-	if err := writer.Close(); err != nil {
-		return nil, fmt.Errorf("failed to close multipart writer: %w", err)
-	}
-
-	// Create the HTTP request
-	url := c.BaseURL + endpoint
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
-	}
-
-	httpReq.Header.Set("Content-Type", writer.FormDataContentType())
-	httpReq.Header.Set("Authorization", "Bearer "+c.APIKey)
-
 	// Set the accept header based on whether we want JSON or binary response
-	if request.ReturnAsJSON {
-		httpReq.Header.Set("Accept", "application/json")
-	} else {
-		httpReq.Header.Set("Accept", "image/*")
+	accept := "image/*"
+	if request.ReturnAsJSON || request.Type == UpscaleTypeCreative {
+		accept = "application/json"
 	}
 
 	// Send the request
-	resp, err := c.HTTPClient.Do(httpReq)
+	start := time.Now()
+	resp, err := c.send(ctx, Operation{
+		Path:   endpoint,
+		Accept: accept,
+		Fields: fields,
+		Files:  []FormFile{{Field: "image", Filename: request.Filename, Data: request.Image, Reader: request.ImageReader}},
+		Name:   "upscale",
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to send upscale request: %w", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	// Handle non-200 responses
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		var errorResp ErrorResponse
-		if err := json.Unmarshal(body, &errorResp); err == nil {
-			// Check for content policy violation (HTTP 403)
-			if resp.StatusCode == http.StatusForbidden {
-				// Look for specific content policy error patterns
-				if errorResp.Name == "content_policy_violation" ||
-					errorResp.Name == "safety_violation" ||
-					errorResp.Message == "Your request has been rejected as a result of our safety system." {
-					return nil, fmt.Errorf("content policy violation: the image violates Stability AI's content policy - %s", errorResp.Message)
-				}
-				return nil, fmt.Errorf("forbidden: %s - %s", errorResp.Name, errorResp.Message)
-			}
-			return nil, fmt.Errorf("upscale API error (status %d): %s - %s", resp.StatusCode, errorResp.Name, errorResp.Message)
-		}
-		// Fallback for unparseable errors
-		if resp.StatusCode == http.StatusForbidden {
-			return nil, fmt.Errorf("content policy violation: the image appears to violate Stability AI's content policy")
-		}
-		return nil, fmt.Errorf("upscale API error (status %d): %s", resp.StatusCode, string(body))
-	}
-
 	// For Creative upscale, we get an ID for polling
 	if request.Type == UpscaleTypeCreative {
 		var creativeResp CreativeAsyncResponse
-		if err := json.NewDecoder(resp.Body).Decode(&creativeResp); err != nil {
-			return nil, fmt.Errorf("failed to decode creative upscale response: %w", err)
+		if err := decodeJSONResponse(resp, "creative upscale", &creativeResp); err != nil {
+			return nil, err
 		}
+		metadata := responseMetadata(resp, start)
+		metadata.Credits = UpscaleCredits[request.Type]
 		return &UpscaleResponse{
-			CreativeID: creativeResp.ID,
+			CreativeID:    creativeResp.ID,
+			Preprocessing: preprocessing,
+			Metadata:      metadata,
 		}, nil
 	}
 
 	// For Conservative and Fast upscale, we get the image directly
-	// Add a buffer size limit to prevent excessive memory usage
-	bodyData, err := io.ReadAll(io.LimitReader(resp.Body, 100*1024*1024)) // 100MB limit
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
-	}
-
-	// If no data was received but no error, it might be a silent failure or content policy violation
-	if len(bodyData) == 0 {
-		return nil, fmt.Errorf("no data received in response; this may indicate a content policy violation")
-	}
-
-	return &UpscaleResponse{
-		ImageData: bodyData,
-		MimeType:  resp.Header.Get("Content-Type"),
-	}, nil
-}
-
-// PollCreativeResult polls for the result of a creative upscale job
-func (c *Client) PollCreativeResult(ctx context.Context, id string) (*UpscaleResponse, bool, error) {
-	url := fmt.Sprintf("%s%s/%s", c.BaseURL, CreativeResultPath, id)
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return nil, false, fmt.Errorf("failed to create poll request: %w", err)
-	}
-
-	req.Header.Set("Authorization", "Bearer "+c.APIKey)
-	req.Header.Set("Accept", "application/json")
-
-	resp, err := c.HTTPClient.Do(req)
+	result, err := readImageResult(resp)
 	if err != nil {
-		return nil, false, fmt.Errorf("failed to send poll request: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	// Handle non-200 responses
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		var errorResp ErrorResponse
-		if err := json.Unmarshal(body, &errorResp); err == nil {
-			// Check for content policy violation (HTTP 403)
-			if resp.StatusCode == http.StatusForbidden {
-				// Look for specific content policy error patterns
-				if errorResp.Name == "content_policy_violation" ||
-					errorResp.Name == "safety_violation" ||
-					errorResp.Message == "Your request has been rejected as a result of our safety system." {
-					return nil, false, fmt.Errorf("content policy violation: the image violates Stability AI's content policy - %s", errorResp.Message)
-				}
-				return nil, false, fmt.Errorf("forbidden: %s - %s", errorResp.Name, errorResp.Message)
-			}
-			return nil, false, fmt.Errorf("poll API error (status %d): %s - %s", resp.StatusCode, errorResp.Name, errorResp.Message)
-		}
-		// Fallback for unparseable errors
-		if resp.StatusCode == http.StatusForbidden {
-			return nil, false, fmt.Errorf("content policy violation: the image appears to violate Stability AI's content policy")
-		}
-		return nil, false, fmt.Errorf("poll API error (status %d): %s", resp.StatusCode, string(body))
+	metadata := responseMetadata(resp, start)
+	metadata.Seed = result.Seed
+	metadata.FinishReason = result.FinishReason
+	metadata.Credits = UpscaleCredits[request.Type]
+
+	response := &UpscaleResponse{
+		ImageData:       result.Data,
+		MimeType:        result.MimeType,
+		Preprocessing:   preprocessing,
+		Metadata:        metadata,
+		ContentFiltered: result.FinishReason == FinishReasonContentFiltered,
 	}
-
-	var resultResp UpscaleResultResponse
-	if err := json.NewDecoder(resp.Body).Decode(&resultResp); err != nil {
-		return nil, false, fmt.Errorf("failed to decode poll response: %w", err)
+	if err := request.checkContentFiltered(response); err != nil {
+		return nil, err
 	}
+	return response, nil
+}
 
-	// Check if there was an error during processing
-	if resultResp.Error != "" {
-		return nil, false, fmt.Errorf("upscale processing error: %s", resultResp.Error)
+// checkContentFiltered fails a response blurred by the content filter unless the request allows it
+func (r UpscaleRequest) checkContentFiltered(response *UpscaleResponse) error {
+	if !response.ContentFiltered || r.AllowContentFiltered {
+		return nil
 	}
-
-	// If not finished yet, return with the finished flag set to false
-	if !resultResp.Finished {
-		return nil, false, nil
+	return &apierrors.ContentFilteredError{
+		Operation: "upscale",
+		RequestID: response.Metadata.RequestID,
+		Seed:      response.Metadata.Seed,
 	}
+}
 
-	// Decode the base64 image data
-	imageData, err := base64.StdEncoding.DecodeString(resultResp.Image)
-	if err != nil {
-		return nil, true, fmt.Errorf("failed to decode base64 image: %w", err)
+// PollCreativeResult polls for the result of a creative upscale job
+func (c *Client) PollCreativeResult(ctx context.Context, id string) (*UpscaleResponse, bool, error) {
+	result, finished, err := c.PollResult(ctx, id)
+	if err != nil || !finished {
+		return nil, finished, err
 	}
 
 	return &UpscaleResponse{
-		ImageData: imageData,
-		MimeType:  resultResp.Type,
+		ImageData:       result.Data,
+		MimeType:        result.MimeType,
+		Metadata:        result.Metadata,
+		ContentFiltered: result.FinishReason == FinishReasonContentFiltered,
 	}, true, nil
 }
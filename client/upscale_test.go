@@ -0,0 +1,366 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcusziade/stability-go/client"
+	apierrors "github.com/marcusziade/stability-go/errors"
+	"github.com/marcusziade/stability-go/stabilitytest"
+	"github.com/marcusziade/stability-go/telemetry"
+)
+
+// testImage returns a PNG of the given size
+func testImage(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	if err := png.Encode(&b, image.NewNRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	return b.Bytes()
+}
+
+// fastWait polls without the production delays
+var fastWait = client.WaitOptions{InitialInterval: time.Millisecond, MaxInterval: time.Millisecond}
+
+func TestUpscaleFast(t *testing.T) {
+	server := stabilitytest.NewServer(stabilitytest.ServerConfig{Seed: 42})
+	defer server.Close()
+
+	response, err := server.NewClient().Upscale(context.Background(), client.UpscaleRequest{
+		Image:    testImage(t, 100, 100),
+		Filename: "input.png",
+		Type:     client.UpscaleTypeFast,
+	})
+	if err != nil {
+		t.Fatalf("Upscale failed: %v", err)
+	}
+
+	if !bytes.Equal(response.ImageData, stabilitytest.DefaultImage) {
+		t.Errorf("ImageData = %d bytes, want the server's image", len(response.ImageData))
+	}
+	if response.MimeType != "image/png" {
+		t.Errorf("MimeType = %q, want image/png", response.MimeType)
+	}
+	if response.Metadata.Seed != 42 || response.Metadata.RequestID != "req-1" || response.Metadata.Attempts != 1 {
+		t.Errorf("Metadata = %+v, want seed 42, request ID req-1 and 1 attempt", response.Metadata)
+	}
+
+	requests := server.Requests()
+	if len(requests) != 1 || requests[0].Path != client.UpscaleFastPath {
+		t.Fatalf("requests = %+v, want a single request to %s", requests, client.UpscaleFastPath)
+	}
+	if got := requests[0].Header.Get("Authorization"); got != "Bearer sk-test" {
+		t.Errorf("Authorization = %q, want Bearer sk-test", got)
+	}
+}
+
+func TestUpscaleConservative(t *testing.T) {
+	server := stabilitytest.NewServer(stabilitytest.ServerConfig{})
+	defer server.Close()
+
+	_, err := server.NewClient().Upscale(context.Background(), client.UpscaleRequest{
+		Image:        testImage(t, 100, 100),
+		Filename:     "input.png",
+		Type:         client.UpscaleTypeConservative,
+		Prompt:       "a lighthouse at dusk",
+		Creativity:   0.3,
+		OutputFormat: client.OutputFormatPNG,
+	})
+	if err != nil {
+		t.Fatalf("Upscale failed: %v", err)
+	}
+
+	request := server.Requests()[0]
+	if request.Path != client.UpscaleConservativePath {
+		t.Errorf("path = %s, want %s", request.Path, client.UpscaleConservativePath)
+	}
+	if request.Fields["prompt"] != "a lighthouse at dusk" || request.Fields["output_format"] != "png" {
+		t.Errorf("fields = %v, want the prompt and output format", request.Fields)
+	}
+}
+
+func TestUpscaleCreativeWaitsForPendingPolls(t *testing.T) {
+	server := stabilitytest.NewServer(stabilitytest.ServerConfig{PendingPolls: 2, Seed: 7})
+	defer server.Close()
+
+	var progress []int
+	opts := fastWait
+	opts.OnProgress = func(p client.WaitProgress) { progress = append(progress, p.Attempt) }
+
+	response, err := server.NewClient().UpscaleAndWait(context.Background(), client.UpscaleRequest{
+		Image:    testImage(t, 100, 100),
+		Filename: "input.png",
+		Type:     client.UpscaleTypeCreative,
+		Prompt:   "a lighthouse at dusk",
+	}, opts)
+	if err != nil {
+		t.Fatalf("UpscaleAndWait failed: %v", err)
+	}
+
+	if !bytes.Equal(response.ImageData, stabilitytest.DefaultImage) {
+		t.Errorf("ImageData = %d bytes, want the server's image", len(response.ImageData))
+	}
+	if response.Metadata.Seed != 7 || response.Metadata.FinishReason != client.FinishReasonSuccess {
+		t.Errorf("Metadata = %+v, want seed 7 and finish reason SUCCESS", response.Metadata)
+	}
+	if len(progress) != 2 {
+		t.Errorf("OnProgress called for attempts %v, want 2 pending polls", progress)
+	}
+
+	// One request starts the job, two polls find it pending and the third gets the result
+	if requests := server.Requests(); len(requests) != 4 {
+		t.Errorf("server received %d requests, want 4", len(requests))
+	}
+}
+
+func TestUpscaleRateLimited(t *testing.T) {
+	server := stabilitytest.NewServer(stabilitytest.ServerConfig{})
+	defer server.Close()
+	server.InjectFault(stabilitytest.RateLimitFault(client.UpscaleFastPath, 3*time.Second))
+
+	_, err := server.NewClient().Upscale(context.Background(), client.UpscaleRequest{
+		Image:    testImage(t, 100, 100),
+		Filename: "input.png",
+		Type:     client.UpscaleTypeFast,
+	})
+	if !apierrors.IsRateLimitError(err) {
+		t.Fatalf("err = %v, want a rate limit error", err)
+	}
+
+	apiErr, _ := apierrors.AsAPIError(err)
+	if apiErr.StatusCode != http.StatusTooManyRequests || apiErr.RetryAfter != 3*time.Second || apiErr.RequestID != "req-1" {
+		t.Errorf("APIError = %+v, want a 429 with Retry-After 3s and request ID req-1", apiErr)
+	}
+}
+
+func TestUpscaleContentPolicyViolation(t *testing.T) {
+	server := stabilitytest.NewServer(stabilitytest.ServerConfig{})
+	defer server.Close()
+	server.InjectFault(stabilitytest.ContentPolicyFault(client.UpscaleFastPath))
+
+	_, err := server.NewClient().Upscale(context.Background(), client.UpscaleRequest{
+		Image:    testImage(t, 100, 100),
+		Filename: "input.png",
+		Type:     client.UpscaleTypeFast,
+	})
+	if !apierrors.IsContentPolicyViolation(err) {
+		t.Fatalf("err = %v, want a content policy violation", err)
+	}
+	if apiErr, _ := apierrors.AsAPIError(err); apiErr.StatusCode != http.StatusForbidden {
+		t.Errorf("StatusCode = %d, want 403", apiErr.StatusCode)
+	}
+}
+
+func TestUpscaleContentFiltered(t *testing.T) {
+	tests := []struct {
+		name    string
+		request client.UpscaleRequest
+	}{
+		{"fast", client.UpscaleRequest{Type: client.UpscaleTypeFast}},
+		{"creative", client.UpscaleRequest{Type: client.UpscaleTypeCreative, Prompt: "a lighthouse at dusk"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := stabilitytest.NewServer(stabilitytest.ServerConfig{Seed: 5, FinishReason: client.FinishReasonContentFiltered})
+			defer server.Close()
+
+			request := tt.request
+			request.Image = testImage(t, 100, 100)
+			request.Filename = "input.png"
+
+			// A blurred result fails unless the request allows it
+			_, err := server.NewClient().UpscaleAndWait(context.Background(), request, fastWait)
+			var filteredErr *apierrors.ContentFilteredError
+			if !errors.As(err, &filteredErr) || !errors.Is(err, apierrors.ErrContentFiltered) {
+				t.Fatalf("err = %v, want a ContentFilteredError", err)
+			}
+			if filteredErr.Seed != 5 || filteredErr.RequestID != "req-1" {
+				t.Errorf("ContentFilteredError = %+v, want seed 5 and the request ID of the upscale, req-1", filteredErr)
+			}
+
+			request.AllowContentFiltered = true
+			response, err := server.NewClient().UpscaleAndWait(context.Background(), request, fastWait)
+			if err != nil {
+				t.Fatalf("UpscaleAndWait with AllowContentFiltered failed: %v", err)
+			}
+			if !response.ContentFiltered || response.Metadata.FinishReason != client.FinishReasonContentFiltered || len(response.ImageData) == 0 {
+				t.Errorf("response = %+v, want the blurred image flagged as content filtered", response)
+			}
+		})
+	}
+}
+
+func TestUpscaleServerError(t *testing.T) {
+	server := stabilitytest.NewServer(stabilitytest.ServerConfig{})
+	defer server.Close()
+	server.InjectFault(stabilitytest.ServerErrorFault(client.UpscaleFastPath))
+
+	_, err := server.NewClient().Upscale(context.Background(), client.UpscaleRequest{
+		Image:    testImage(t, 100, 100),
+		Filename: "input.png",
+		Type:     client.UpscaleTypeFast,
+	})
+	apiErr, ok := apierrors.AsAPIError(err)
+	if !ok || apiErr.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("err = %v, want a 500 API error", err)
+	}
+	if apierrors.IsRateLimitError(err) || apierrors.IsContentPolicyViolation(err) {
+		t.Errorf("err = %v, classified as a rate limit or content policy error", err)
+	}
+}
+
+func TestUpscaleRetriesServerError(t *testing.T) {
+	server := stabilitytest.NewServer(stabilitytest.ServerConfig{})
+	defer server.Close()
+	server.InjectFault(stabilitytest.ServerErrorFault(client.UpscaleFastPath))
+
+	stClient := server.NewClient().WithMiddleware(client.Retry(2, time.Millisecond, time.Millisecond))
+	response, err := stClient.Upscale(context.Background(), client.UpscaleRequest{
+		Image:    testImage(t, 100, 100),
+		Filename: "input.png",
+		Type:     client.UpscaleTypeFast,
+	})
+	if err != nil {
+		t.Fatalf("Upscale failed: %v", err)
+	}
+
+	if response.Metadata.Attempts != 2 || response.Metadata.RequestID != "req-2" {
+		t.Errorf("Metadata = %+v, want 2 attempts and request ID req-2", response.Metadata)
+	}
+}
+
+func TestRecorderRoundTrip(t *testing.T) {
+	golden := filepath.Join(t.TempDir(), "upscale.json")
+	request := client.UpscaleRequest{
+		Image:    testImage(t, 100, 100),
+		Filename: "input.png",
+		Type:     client.UpscaleTypeFast,
+	}
+
+	// Record an interaction with the fake server
+	server := stabilitytest.NewServer(stabilitytest.ServerConfig{APIKey: "sk-secret", Seed: 3})
+	baseURL := server.URL
+
+	recorder, err := stabilitytest.NewRecorder(golden, stabilitytest.ModeRecord, nil)
+	if err != nil {
+		t.Fatalf("NewRecorder failed: %v", err)
+	}
+	recorded, err := server.NewClient().WithMiddleware(recorder.Middleware()).Upscale(context.Background(), request)
+	if err != nil {
+		t.Fatalf("recorded Upscale failed: %v", err)
+	}
+	if err := recorder.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	server.Close()
+
+	data, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("failed to read golden file: %v", err)
+	}
+	if strings.Contains(string(data), "Authorization") || strings.Contains(string(data), "sk-secret") {
+		t.Errorf("golden file contains credentials:\n%s", data)
+	}
+
+	// Replay it with the server gone
+	replayer, err := stabilitytest.NewRecorder(golden, stabilitytest.ModeReplay, nil)
+	if err != nil {
+		t.Fatalf("NewRecorder failed: %v", err)
+	}
+	replayed, err := client.NewClient("sk-other").WithBaseURL(baseURL).WithMiddleware(replayer.Middleware()).
+		Upscale(context.Background(), request)
+	if err != nil {
+		t.Fatalf("replayed Upscale failed: %v", err)
+	}
+
+	if !bytes.Equal(replayed.ImageData, recorded.ImageData) || replayed.Metadata.Seed != 3 {
+		t.Errorf("replayed response = %d bytes with seed %d, want the recorded image with seed 3",
+			len(replayed.ImageData), replayed.Metadata.Seed)
+	}
+}
+
+func TestUpscaleFailsOverRateLimitedKey(t *testing.T) {
+	server := stabilitytest.NewServer(stabilitytest.ServerConfig{})
+	defer server.Close()
+	server.InjectFault(stabilitytest.RateLimitFault(client.UpscaleCreativePath, time.Minute))
+
+	// The retry middleware would wait out the minute; the pool resends with the other key at once
+	stClient := server.NewClient().
+		WithKeys([]string{"sk-a", "sk-b"}, client.KeyPoolConfig{}).
+		WithMiddleware(client.Retry(2, time.Millisecond, time.Millisecond))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	response, err := stClient.UpscaleAndWait(ctx, client.UpscaleRequest{
+		Image:    testImage(t, 100, 100),
+		Filename: "input.png",
+		Type:     client.UpscaleTypeCreative,
+		Prompt:   "a lighthouse at dusk",
+	}, fastWait)
+	if err != nil {
+		t.Fatalf("UpscaleAndWait failed: %v", err)
+	}
+	if response.Metadata.Attempts != 1 {
+		t.Errorf("Attempts = %d, want 1: the rate limited key must not be retried", response.Metadata.Attempts)
+	}
+
+	// The job is polled with the key that started it
+	var keys []string
+	for _, request := range server.Requests() {
+		keys = append(keys, request.Header.Get("Authorization"))
+	}
+	want := []string{"Bearer sk-a", "Bearer sk-b", "Bearer sk-b"}
+	if strings.Join(keys, ",") != strings.Join(want, ",") {
+		t.Errorf("keys = %v, want %v", keys, want)
+	}
+
+	stats := stClient.Keys.Stats()
+	if stats[0].Healthy || stats[0].RateLimits != 1 || !stats[1].Healthy {
+		t.Errorf("stats = %+v, want the first key benched for its rate limit", stats)
+	}
+}
+
+func TestBatchCountsCreditsOfAcceptedCreativeUpscales(t *testing.T) {
+	// Every result is blurred by the content filter, so waiting for the creative result fails
+	server := stabilitytest.NewServer(stabilitytest.ServerConfig{FinishReason: client.FinishReasonContentFiltered})
+	defer server.Close()
+
+	tel := stabilitytest.NewTelemetry()
+	stClient := server.NewClient().WithTracer(tel).WithMeter(tel)
+
+	batch := stClient.BatchUpscale(context.Background(), []client.UpscaleRequest{
+		{Image: testImage(t, 100, 100), Filename: "creative.png", Type: client.UpscaleTypeCreative, Prompt: "a lighthouse at dusk"},
+		{Image: testImage(t, 100, 100), Filename: "fast.png", Type: client.UpscaleTypeFast},
+	}, client.BatchOptions{Wait: fastWait})
+
+	credits := map[int]float64{}
+	for result := range batch.Results() {
+		if !apierrors.IsContentFiltered(result.Err) {
+			t.Errorf("request %d: err = %v, want a ContentFilteredError", result.Index, result.Err)
+		}
+		credits[result.Index] = result.Credits
+	}
+
+	// The creative job was charged when it was accepted; the fast upscale failed with its response
+	if credits[0] != client.UpscaleCredits[client.UpscaleTypeCreative] || credits[1] != 0 {
+		t.Errorf("credits = %v, want the creative upscale's credits only", credits)
+	}
+	summary := batch.Wait()
+	if summary.Failed != 2 || summary.Credits != client.UpscaleCredits[client.UpscaleTypeCreative] {
+		t.Errorf("summary = %+v, want 2 failures and the creative upscale's credits", summary)
+	}
+	if sum := tel.Sum(telemetry.MetricUpscaleCredits); sum != client.UpscaleCredits[client.UpscaleTypeCreative] {
+		t.Errorf("%s = %v, want the creative upscale's credits", telemetry.MetricUpscaleCredits, sum)
+	}
+}
@@ -0,0 +1,246 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"unicode/utf8"
+
+	apierrors "github.com/marcusziade/stability-go/errors"
+	"github.com/marcusziade/stability-go/internal/utils"
+)
+
+// Documented limits shared by the Stability endpoints
+const (
+	// MaxPromptLength is the maximum number of characters in a prompt
+	MaxPromptLength = 10000
+	// MaxSeed is the largest seed accepted by the API
+	MaxSeed = 4294967294
+	// MaxImageBytes is the maximum size of an uploaded image
+	MaxImageBytes = 10 * 1024 * 1024
+)
+
+// ImageLimits describes the images an endpoint accepts
+type ImageLimits struct {
+	// Minimum and maximum length of each side in pixels (0 for no limit)
+	MinSide int
+	MaxSide int
+	// Minimum and maximum total pixel count (0 for no limit)
+	MinPixels int
+	MaxPixels int
+	// Maximum ratio between the longer and the shorter side (0 for no limit)
+	MaxAspectRatio float64
+	// Exact sizes the image must have (empty for any size)
+	Sizes [][2]int
+	// Maximum size of the encoded image in bytes (0 for MaxImageBytes, negative for no limit)
+	MaxBytes int
+}
+
+// maxBytes returns the maximum size of the encoded image in bytes
+func (l ImageLimits) maxBytes() int {
+	switch {
+	case l.MaxBytes == 0:
+		return MaxImageBytes
+	case l.MaxBytes < 0:
+		return math.MaxInt
+	}
+	return l.MaxBytes
+}
+
+// fittable returns the limits an image must meet before PreprocessImage fits it within l
+func (l ImageLimits) fittable() ImageLimits {
+	l.MaxSide = 0
+	l.MaxPixels = 0
+	l.MaxBytes = -1
+	return l
+}
+
+// Image limits of the endpoints, as documented by Stability AI
+var (
+	FastUpscaleImageLimits         = ImageLimits{MinSide: 32, MaxSide: 1536, MinPixels: 1024, MaxPixels: 1048576, MaxAspectRatio: 2.5}
+	ConservativeUpscaleImageLimits = ImageLimits{MinSide: 64, MinPixels: 4096, MaxPixels: 9437184, MaxAspectRatio: 2.5}
+	CreativeUpscaleImageLimits     = ImageLimits{MinSide: 64, MinPixels: 4096, MaxPixels: 1048576, MaxAspectRatio: 2.5}
+	EditImageLimits                = ImageLimits{MinSide: 64, MinPixels: 4096, MaxPixels: 9437184, MaxAspectRatio: 2.5}
+	RemoveBackgroundImageLimits    = ImageLimits{MinSide: 64, MinPixels: 4096, MaxPixels: 4194304, MaxAspectRatio: 2.5}
+	ControlImageLimits             = ImageLimits{MinSide: 64, MinPixels: 4096, MaxPixels: 9437184, MaxAspectRatio: 2.5}
+	Model3DImageLimits             = ImageLimits{MinSide: 64, MinPixels: 4096, MaxPixels: 4194304}
+	VideoImageLimits               = ImageLimits{Sizes: [][2]int{{1024, 576}, {576, 1024}, {768, 768}}}
+)
+
+// validator collects the field errors of a request
+type validator struct {
+	errors []apierrors.FieldError
+}
+
+// add records a field error
+func (v *validator) add(field, format string, args ...interface{}) {
+	v.errors = append(v.errors, apierrors.FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// err returns the collected errors as a *errors.ValidationError, or nil if there are none
+func (v *validator) err(operation string) error {
+	if len(v.errors) == 0 {
+		return nil
+	}
+	return &apierrors.ValidationError{Operation: operation, Errors: v.errors}
+}
+
+// prompt checks the length of a prompt, and that it is set if required
+func (v *validator) prompt(field, prompt string, required bool) {
+	if prompt == "" {
+		if required {
+			v.add(field, "is required")
+		}
+		return
+	}
+	if length := utf8.RuneCountInString(prompt); length > MaxPromptLength {
+		v.add(field, "is %d characters long, the maximum is %d", length, MaxPromptLength)
+	}
+}
+
+// seed checks that a seed is within the accepted range
+func (v *validator) seed(seed int64) {
+	if seed < 0 || seed > MaxSeed {
+		v.add("seed", "must be between 0 and %d", int64(MaxSeed))
+	}
+}
+
+// between checks that an optional value is within a range; zero means the value is unset
+func (v *validator) between(field string, value, min, max float64) {
+	if value != 0 && (value < min || value > max) {
+		v.add(field, "must be between %g and %g", min, max)
+	}
+}
+
+// aspectRatio checks that an aspect ratio is one of the supported ratios
+func (v *validator) aspectRatio(ratio AspectRatio) {
+	switch ratio {
+	case "", AspectRatio16x9, AspectRatio1x1, AspectRatio21x9, AspectRatio2x3, AspectRatio3x2,
+		AspectRatio4x5, AspectRatio5x4, AspectRatio9x16, AspectRatio9x21:
+	default:
+		v.add("aspect_ratio", "invalid aspect ratio %q", ratio)
+	}
+}
+
+// textureResolution checks the texture_resolution field of the 3d endpoints
+func (v *validator) textureResolution(resolution int) {
+	switch resolution {
+	case 0, 512, 1024, 2048:
+	default:
+		v.add("texture_resolution", "must be 512, 1024 or 2048")
+	}
+}
+
+// growMask checks the grow_mask field of the edit endpoints
+func (v *validator) growMask(growMask, max int) {
+	if growMask < 0 || growMask > max {
+		v.add("grow_mask", "must be between 0 and %d", max)
+	}
+}
+
+// outputFormat checks that an output format is one of the given formats
+func (v *validator) outputFormat(format OutputFormat, allowed ...OutputFormat) {
+	if format == "" {
+		return
+	}
+	if len(allowed) == 0 {
+		allowed = []OutputFormat{OutputFormatJPEG, OutputFormatPNG, OutputFormatWEBP}
+	}
+	for _, a := range allowed {
+		if format == a {
+			return
+		}
+	}
+	v.add("output_format", "%s is not supported, use one of %v", format, allowed)
+}
+
+// image checks the size and dimensions of an image against the endpoint's limits
+func (v *validator) image(field string, data []byte, limits ImageLimits, required bool) {
+	if len(data) == 0 {
+		if required {
+			v.add(field, "is required")
+		}
+		return
+	}
+	if maxBytes := limits.maxBytes(); len(data) > maxBytes {
+		v.add(field, "is %d bytes, the maximum is %d", len(data), maxBytes)
+		return
+	}
+
+	width, height, _, err := utils.ImageDimensions(data)
+	if err != nil {
+		v.add(field, "must be a jpeg, png or webp image")
+		return
+	}
+	v.dimensions(field, width, height, limits)
+}
+
+// imageReader checks an image streamed from a reader like image does for an in-memory image.
+// Only readers that implement io.Seeker can be checked, since checking them means reading the size and header;
+// they are rewound afterwards. Other readers are left for the API to check.
+func (v *validator) imageReader(field string, r io.Reader, limits ImageLimits) {
+	seeker, ok := r.(io.Seeker)
+	if !ok {
+		return
+	}
+
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		v.add(field, "can't be read: %v", err)
+		return
+	}
+	defer seeker.Seek(start, io.SeekStart)
+
+	end, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		v.add(field, "can't be read: %v", err)
+		return
+	}
+	if size := end - start; size > int64(limits.maxBytes()) {
+		v.add(field, "is %d bytes, the maximum is %d", size, limits.maxBytes())
+		return
+	}
+	if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+		v.add(field, "can't be read: %v", err)
+		return
+	}
+
+	width, height, _, err := utils.ReadImageDimensions(r)
+	if err != nil {
+		v.add(field, "must be a jpeg, png or webp image")
+		return
+	}
+	v.dimensions(field, width, height, limits)
+}
+
+// dimensions checks the size of an image against the endpoint's limits
+func (v *validator) dimensions(field string, width, height int, limits ImageLimits) {
+	if len(limits.Sizes) > 0 {
+		for _, size := range limits.Sizes {
+			if width == size[0] && height == size[1] {
+				return
+			}
+		}
+		v.add(field, "is %dx%d, it must be one of %v", width, height, limits.Sizes)
+		return
+	}
+
+	shorter, longer := width, height
+	if shorter > longer {
+		shorter, longer = longer, shorter
+	}
+	pixels := width * height
+
+	switch {
+	case limits.MinSide > 0 && shorter < limits.MinSide:
+		v.add(field, "is %dx%d, every side must be at least %dpx", width, height, limits.MinSide)
+	case limits.MaxSide > 0 && longer > limits.MaxSide:
+		v.add(field, "is %dx%d, every side must be at most %dpx", width, height, limits.MaxSide)
+	case limits.MinPixels > 0 && pixels < limits.MinPixels:
+		v.add(field, "has %d pixels, the minimum is %d", pixels, limits.MinPixels)
+	case limits.MaxPixels > 0 && pixels > limits.MaxPixels:
+		v.add(field, "has %d pixels, the maximum is %d", pixels, limits.MaxPixels)
+	case limits.MaxAspectRatio > 0 && float64(longer) > limits.MaxAspectRatio*float64(shorter):
+		v.add(field, "is %dx%d, the aspect ratio must be between 1:%g and %g:1", width, height, limits.MaxAspectRatio, limits.MaxAspectRatio)
+	}
+}
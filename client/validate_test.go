@@ -0,0 +1,249 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+
+	apierrors "github.com/marcusziade/stability-go/errors"
+)
+
+// pngImage returns a PNG of the given size
+func pngImage(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	if err := png.Encode(&b, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	return b.Bytes()
+}
+
+// fields returns the fields of field errors
+func fields(errs []apierrors.FieldError) []string {
+	var fields []string
+	for _, err := range errs {
+		fields = append(fields, err.Field)
+	}
+	return fields
+}
+
+func TestValidatorDimensions(t *testing.T) {
+	tests := []struct {
+		name          string
+		limits        ImageLimits
+		width, height int
+		// Substring of the error message, or empty if the dimensions are valid
+		want string
+	}{
+		{"smallest fast image", FastUpscaleImageLimits, 32, 32, ""},
+		{"side below the minimum", FastUpscaleImageLimits, 31, 64, "at least 32px"},
+		{"longest side at the maximum", FastUpscaleImageLimits, 1536, 682, ""},
+		{"side above the maximum", FastUpscaleImageLimits, 1537, 640, "at most 1536px"},
+		{"pixels at the maximum", FastUpscaleImageLimits, 1024, 1024, ""},
+		{"pixels above the maximum", FastUpscaleImageLimits, 1024, 1025, "maximum is 1048576"},
+		{"aspect ratio at the maximum", FastUpscaleImageLimits, 80, 200, ""},
+		{"aspect ratio above the maximum", FastUpscaleImageLimits, 80, 201, "aspect ratio"},
+		{"portrait aspect ratio above the maximum", FastUpscaleImageLimits, 201, 80, "aspect ratio"},
+		{"conservative minimum side", ConservativeUpscaleImageLimits, 63, 100, "at least 64px"},
+		{"conservative pixels at the maximum", ConservativeUpscaleImageLimits, 3072, 3072, ""},
+		{"conservative pixels above the maximum", ConservativeUpscaleImageLimits, 3072, 3073, "maximum is 9437184"},
+		{"pixels below the minimum", ImageLimits{MinPixels: 4096}, 63, 64, "minimum is 4096"},
+		{"no limits", ImageLimits{}, 1, 100000, ""},
+		{"exact size", VideoImageLimits, 576, 1024, ""},
+		{"other size", VideoImageLimits, 1024, 1024, "must be one of"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v validator
+			v.dimensions("image", tt.width, tt.height, tt.limits)
+
+			if tt.want == "" {
+				if len(v.errors) != 0 {
+					t.Errorf("%dx%d rejected: %v", tt.width, tt.height, v.errors)
+				}
+				return
+			}
+			if len(v.errors) != 1 || v.errors[0].Field != "image" || !strings.Contains(v.errors[0].Message, tt.want) {
+				t.Errorf("errors = %v, want one image error containing %q", v.errors, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatorPrompt(t *testing.T) {
+	tests := []struct {
+		name     string
+		prompt   string
+		required bool
+		valid    bool
+	}{
+		{"missing required prompt", "", true, false},
+		{"missing optional prompt", "", false, true},
+		{"prompt at the maximum length", strings.Repeat("a", MaxPromptLength), true, true},
+		{"prompt above the maximum length", strings.Repeat("a", MaxPromptLength+1), true, false},
+		// The limit is in characters, not bytes
+		{"multibyte prompt at the maximum length", strings.Repeat("é", MaxPromptLength), true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v validator
+			v.prompt("prompt", tt.prompt, tt.required)
+			if valid := len(v.errors) == 0; valid != tt.valid {
+				t.Errorf("valid = %v, want %v (errors: %v)", valid, tt.valid, v.errors)
+			}
+		})
+	}
+}
+
+func TestValidatorRanges(t *testing.T) {
+	tests := []struct {
+		name  string
+		check func(*validator)
+		valid bool
+	}{
+		{"seed 0", func(v *validator) { v.seed(0) }, true},
+		{"maximum seed", func(v *validator) { v.seed(MaxSeed) }, true},
+		{"seed above the maximum", func(v *validator) { v.seed(MaxSeed + 1) }, false},
+		{"negative seed", func(v *validator) { v.seed(-1) }, false},
+		{"unset value", func(v *validator) { v.between("creativity", 0, 0.2, 0.5) }, true},
+		{"value at the minimum", func(v *validator) { v.between("creativity", 0.2, 0.2, 0.5) }, true},
+		{"value at the maximum", func(v *validator) { v.between("creativity", 0.5, 0.2, 0.5) }, true},
+		{"value below the minimum", func(v *validator) { v.between("creativity", 0.19, 0.2, 0.5) }, false},
+		{"value above the maximum", func(v *validator) { v.between("creativity", 0.51, 0.2, 0.5) }, false},
+		{"negative value", func(v *validator) { v.between("creativity", -0.3, 0.2, 0.5) }, false},
+		{"grow mask at the maximum", func(v *validator) { v.growMask(20, 20) }, true},
+		{"grow mask above the maximum", func(v *validator) { v.growMask(21, 20) }, false},
+		{"texture resolution", func(v *validator) { v.textureResolution(1024) }, true},
+		{"unsupported texture resolution", func(v *validator) { v.textureResolution(768) }, false},
+		{"aspect ratio", func(v *validator) { v.aspectRatio(AspectRatio21x9) }, true},
+		{"unsupported aspect ratio", func(v *validator) { v.aspectRatio("4:3") }, false},
+		{"default output format", func(v *validator) { v.outputFormat("") }, true},
+		{"output format", func(v *validator) { v.outputFormat(OutputFormatWEBP) }, true},
+		{"unsupported output format", func(v *validator) { v.outputFormat("gif") }, false},
+		{"output format outside the allowed", func(v *validator) { v.outputFormat(OutputFormatWEBP, OutputFormatPNG, OutputFormatJPEG) }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v validator
+			tt.check(&v)
+			if valid := len(v.errors) == 0; valid != tt.valid {
+				t.Errorf("valid = %v, want %v (errors: %v)", valid, tt.valid, v.errors)
+			}
+		})
+	}
+}
+
+func TestValidatorImage(t *testing.T) {
+	data := pngImage(t, 100, 100)
+
+	tests := []struct {
+		name     string
+		data     []byte
+		limits   ImageLimits
+		required bool
+		want     string
+	}{
+		{"valid image", data, FastUpscaleImageLimits, true, ""},
+		{"missing required image", nil, FastUpscaleImageLimits, true, "is required"},
+		{"missing optional image", nil, FastUpscaleImageLimits, false, ""},
+		{"image at the size limit", data, ImageLimits{MaxBytes: len(data)}, true, ""},
+		{"image above the size limit", data, ImageLimits{MaxBytes: len(data) - 1}, true, "the maximum is"},
+		{"not an image", []byte("not an image at all"), FastUpscaleImageLimits, true, "must be a jpeg, png or webp image"},
+		{"image too small", pngImage(t, 16, 16), FastUpscaleImageLimits, true, "at least 32px"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v validator
+			v.image("image", tt.data, tt.limits, tt.required)
+
+			if tt.want == "" {
+				if len(v.errors) != 0 {
+					t.Errorf("image rejected: %v", v.errors)
+				}
+				return
+			}
+			if len(v.errors) != 1 || !strings.Contains(v.errors[0].Message, tt.want) {
+				t.Errorf("errors = %v, want one error containing %q", v.errors, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatorImageReader(t *testing.T) {
+	data := pngImage(t, 16, 16)
+
+	// Seekable readers are checked and rewound to where they were
+	r := bytes.NewReader(append([]byte("header"), data...))
+	r.Seek(int64(len("header")), io.SeekStart)
+	var v validator
+	v.imageReader("image", r, FastUpscaleImageLimits)
+	if len(v.errors) != 1 || !strings.Contains(v.errors[0].Message, "at least 32px") {
+		t.Errorf("errors = %v, want the image rejected as too small", v.errors)
+	}
+	if offset, _ := r.Seek(0, io.SeekCurrent); offset != int64(len("header")) {
+		t.Errorf("reader left at %d, want it rewound to %d", offset, len("header"))
+	}
+
+	// Other readers are left for the API to check
+	v = validator{}
+	v.imageReader("image", nonSeeker{bytes.NewReader(data)}, FastUpscaleImageLimits)
+	if len(v.errors) != 0 {
+		t.Errorf("errors = %v, want a reader that can't be rewound left unchecked", v.errors)
+	}
+}
+
+func TestUpscaleRequestValidate(t *testing.T) {
+	valid := pngImage(t, 100, 100)
+
+	tests := []struct {
+		name    string
+		request UpscaleRequest
+		// Fields of the expected errors, in order
+		want []string
+	}{
+		{"fast", UpscaleRequest{Image: valid, Type: UpscaleTypeFast}, nil},
+		{"conservative", UpscaleRequest{Image: valid, Type: UpscaleTypeConservative, Prompt: "a lighthouse", Creativity: 0.35}, nil},
+		{"creative at the lowest creativity", UpscaleRequest{Image: valid, Type: UpscaleTypeCreative, Prompt: "a lighthouse", Creativity: 0.1}, nil},
+		{"missing image", UpscaleRequest{Type: UpscaleTypeFast}, []string{"image"}},
+		{"unknown type", UpscaleRequest{Image: valid, Type: "huge"}, []string{"type"}},
+		{"conservative without a prompt", UpscaleRequest{Image: valid, Type: UpscaleTypeConservative}, []string{"prompt"}},
+		{"conservative creativity below the minimum", UpscaleRequest{Image: valid, Type: UpscaleTypeConservative, Prompt: "a lighthouse", Creativity: 0.1}, []string{"creativity"}},
+		{"creative creativity above the maximum", UpscaleRequest{Image: valid, Type: UpscaleTypeCreative, Prompt: "a lighthouse", Creativity: 0.6}, []string{"creativity"}},
+		{"negative prompt too long", UpscaleRequest{Image: valid, Type: UpscaleTypeCreative, Prompt: "a lighthouse", NegativePrompt: strings.Repeat("a", MaxPromptLength+1)}, []string{"negative_prompt"}},
+		{"every error reported", UpscaleRequest{Image: pngImage(t, 16, 16), Type: UpscaleTypeCreative, Seed: -1, OutputFormat: "gif"},
+			[]string{"image", "prompt", "seed", "output_format"}},
+		// Preprocessing shrinks images above the maximum, so only the minimums apply
+		{"large image with preprocessing", UpscaleRequest{Image: pngImage(t, 2000, 1000), Type: UpscaleTypeFast, Preprocess: true}, nil},
+		{"large image without preprocessing", UpscaleRequest{Image: pngImage(t, 2000, 1000), Type: UpscaleTypeFast}, []string{"image"}},
+		{"small image with preprocessing", UpscaleRequest{Image: pngImage(t, 16, 16), Type: UpscaleTypeFast, Preprocess: true}, []string{"image"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.request.Validate()
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Errorf("Validate = %v, want no error", err)
+				}
+				return
+			}
+
+			var validationErr *apierrors.ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("Validate = %v, want a ValidationError", err)
+			}
+			got := fields(validationErr.Errors)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("invalid fields = %v, want %v (%v)", got, tt.want, err)
+			}
+		})
+	}
+}
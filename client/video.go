@@ -0,0 +1,151 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/marcusziade/stability-go/internal/utils"
+)
+
+// Video API endpoints
+const (
+	ImageToVideoPath = "/v2beta/image-to-video"
+	VideoResultPath  = "/v2beta/image-to-video/result" // For polling video results
+)
+
+// ImageToVideoRequest represents the parameters for an image-to-video request
+type ImageToVideoRequest struct {
+	// The image to animate (1024x576, 576x1024 or 768x768)
+	Image []byte
+	// The filename of the image
+	Filename string
+	// Optional seed value
+	Seed int64
+	// How strongly the video sticks to the original image (0-10, 0 uses the API default)
+	CfgScale float64
+	// Amount of motion in the video (1-255, 0 uses the API default)
+	MotionBucketID int
+}
+
+// VideoResponse represents the finished result of an image-to-video job
+type VideoResponse struct {
+	// The video data
+	VideoData []byte
+	// The mime type of the video (e.g., "video/mp4")
+	MimeType string
+	// The seed used to generate the video
+	Seed int64
+	// The finish reason reported by the API (SUCCESS or CONTENT_FILTERED)
+	FinishReason string
+}
+
+// Validate checks the request against the documented limits of the image-to-video endpoint
+func (r ImageToVideoRequest) Validate() error {
+	var v validator
+	v.image("image", r.Image, VideoImageLimits, true)
+	v.seed(r.Seed)
+	v.between("cfg_scale", r.CfgScale, 0, 10)
+	if r.MotionBucketID != 0 && (r.MotionBucketID < 1 || r.MotionBucketID > 255) {
+		v.add("motion_bucket_id", "must be between 1 and 255")
+	}
+	return v.err("image-to-video")
+}
+
+// ImageToVideo starts generating a short video from an image.
+// The job runs asynchronously; poll for the result with PollVideoResult using the returned ID.
+func (c *Client) ImageToVideo(ctx context.Context, request ImageToVideoRequest) (*AsyncResponse, error) {
+	if err := request.Validate(); err != nil {
+		return nil, err
+	}
+
+	fields := map[string]string{}
+
+	if request.Seed > 0 {
+		fields["seed"] = strconv.FormatInt(request.Seed, 10)
+	}
+
+	if request.CfgScale != 0 {
+		fields["cfg_scale"] = strconv.FormatFloat(request.CfgScale, 'f', 2, 64)
+	}
+
+	if request.MotionBucketID != 0 {
+		fields["motion_bucket_id"] = strconv.Itoa(request.MotionBucketID)
+	}
+
+	resp, err := c.send(ctx, Operation{
+		Path:   ImageToVideoPath,
+		Fields: fields,
+		Files:  []FormFile{{Field: "image", Filename: request.Filename, Data: request.Image}},
+		Name:   "image-to-video",
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var asyncResp AsyncResponse
+	if err := decodeJSONResponse(resp, "image-to-video", &asyncResp); err != nil {
+		return nil, err
+	}
+
+	return &asyncResp, nil
+}
+
+// PollVideoResult polls for the result of an image-to-video job.
+// The returned bool reports whether the video has finished generating.
+func (c *Client) PollVideoResult(ctx context.Context, id string) (*VideoResponse, bool, error) {
+	result, finished, _, err := c.pollVideoResult(ctx, id)
+	return result, finished, err
+}
+
+// pollVideoResult polls the video result endpoint once, also returning the Retry-After delay requested while the video is pending
+func (c *Client) pollVideoResult(ctx context.Context, id string) (result *VideoResponse, finished bool, retryAfter time.Duration, err error) {
+	ctx, span := c.startPollSpan(ctx, id)
+	defer func() { endPollSpan(span, finished, err) }()
+
+	if id == "" {
+		return nil, false, 0, fmt.Errorf("video ID is required")
+	}
+
+	resp, err := c.send(ctx, Operation{
+		Method: http.MethodGet,
+		Path:   VideoResultPath + "/" + id,
+		Accept: "video/*",
+		Name:   "poll",
+	})
+	if err != nil {
+		return nil, false, 0, err
+	}
+	defer resp.Body.Close()
+
+	// The API responds with 202 while the video is still generating
+	if resp.StatusCode == http.StatusAccepted {
+		return nil, false, utils.ParseRetryAfter(resp.Header.Get("Retry-After")), nil
+	}
+
+	// Videos are returned as raw mp4 bytes
+	videoData, err := io.ReadAll(io.LimitReader(resp.Body, 100*1024*1024)) // 100MB limit
+	if err != nil {
+		return nil, true, 0, fmt.Errorf("failed to read video data: %w", err)
+	}
+	if len(videoData) == 0 {
+		return nil, true, 0, fmt.Errorf("no video data received in response")
+	}
+
+	mimeType := resp.Header.Get("Content-Type")
+	if mimeType == "" {
+		mimeType = "video/mp4"
+	}
+	seed, _ := strconv.ParseInt(resp.Header.Get("seed"), 10, 64)
+
+	return &VideoResponse{
+		VideoData:    videoData,
+		MimeType:     mimeType,
+		Seed:         seed,
+		FinishReason: resp.Header.Get("finish-reason"),
+	}, true, 0, nil
+}
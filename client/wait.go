@@ -0,0 +1,212 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	apierrors "github.com/marcusziade/stability-go/errors"
+)
+
+// Default polling settings used by the WaitFor* helpers
+const (
+	DefaultWaitInitialInterval = 2 * time.Second
+	DefaultWaitBackoffFactor   = 1.5
+	DefaultWaitMaxInterval     = 15 * time.Second
+	DefaultWaitTimeout         = 10 * time.Minute
+)
+
+// WaitOptions configures how the WaitFor* helpers poll for the result of an asynchronous job.
+// Zero values are replaced by the defaults.
+type WaitOptions struct {
+	// Delay before the first poll and between the first polls
+	InitialInterval time.Duration
+	// Factor the interval is multiplied by after every pending poll
+	BackoffFactor float64
+	// Upper bound for the interval between polls
+	MaxInterval time.Duration
+	// Overall deadline for the job to finish (negative to rely on the context alone)
+	Timeout time.Duration
+	// Optional callback invoked after every poll that found the job still pending
+	OnProgress func(WaitProgress)
+}
+
+// WaitProgress describes the state of a job that is still pending
+type WaitProgress struct {
+	// The ID of the job
+	ID string
+	// The number of polls made so far
+	Attempt int
+	// Time elapsed since waiting started
+	Elapsed time.Duration
+	// Delay until the next poll
+	NextPoll time.Duration
+}
+
+// withDefaults returns the options with zero values replaced by the defaults
+func (o WaitOptions) withDefaults() WaitOptions {
+	if o.InitialInterval <= 0 {
+		o.InitialInterval = DefaultWaitInitialInterval
+	}
+	if o.BackoffFactor < 1 {
+		o.BackoffFactor = DefaultWaitBackoffFactor
+	}
+	if o.MaxInterval <= 0 {
+		o.MaxInterval = DefaultWaitMaxInterval
+	}
+	if o.MaxInterval < o.InitialInterval {
+		o.MaxInterval = o.InitialInterval
+	}
+	if o.Timeout == 0 {
+		o.Timeout = DefaultWaitTimeout
+	}
+	return o
+}
+
+// WaitForResult blocks until an asynchronous job, such as a creative upscale or a relight, has finished
+func (c *Client) WaitForResult(ctx context.Context, id string, opts WaitOptions) (*AsyncResult, error) {
+	var result *AsyncResult
+	err := wait(ctx, id, opts, func(ctx context.Context) (bool, time.Duration, error) {
+		var finished bool
+		var retryAfter time.Duration
+		var err error
+		result, finished, retryAfter, err = c.pollResult(ctx, id)
+		return finished, retryAfter, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// WaitForCreativeResult blocks until a creative upscale job has finished
+func (c *Client) WaitForCreativeResult(ctx context.Context, id string, opts WaitOptions) (*UpscaleResponse, error) {
+	result, err := c.WaitForResult(ctx, id, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &UpscaleResponse{
+		ImageData:       result.Data,
+		MimeType:        result.MimeType,
+		Metadata:        result.Metadata,
+		ContentFiltered: result.FinishReason == FinishReasonContentFiltered,
+	}, nil
+}
+
+// WaitForVideoResult blocks until an image-to-video job has finished
+func (c *Client) WaitForVideoResult(ctx context.Context, id string, opts WaitOptions) (*VideoResponse, error) {
+	var result *VideoResponse
+	err := wait(ctx, id, opts, func(ctx context.Context) (bool, time.Duration, error) {
+		var finished bool
+		var retryAfter time.Duration
+		var err error
+		result, finished, retryAfter, err = c.pollVideoResult(ctx, id)
+		return finished, retryAfter, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// UpscaleAndWait upscales an image and, for creative upscales, waits for the result.
+// Fast and conservative upscales return as soon as Upscale does. Results blurred by the content filter
+// fail with an *errors.ContentFilteredError unless the request sets AllowContentFiltered.
+func (c *Client) UpscaleAndWait(ctx context.Context, request UpscaleRequest, opts WaitOptions) (*UpscaleResponse, error) {
+	response, _, err := c.upscaleAndWait(ctx, request, opts)
+	return response, err
+}
+
+// upscaleAndWait is UpscaleAndWait, also returning the credits charged. Creative upscales are charged
+// once the job is accepted, so their credits are returned even if waiting for the result fails.
+func (c *Client) upscaleAndWait(ctx context.Context, request UpscaleRequest, opts WaitOptions) (*UpscaleResponse, float64, error) {
+	start := time.Now()
+	response, err := c.Upscale(ctx, request)
+	if err != nil {
+		return nil, 0, err
+	}
+	credits := response.Metadata.Credits
+
+	if request.Type != UpscaleTypeCreative {
+		return response, credits, nil
+	}
+
+	result, err := c.WaitForCreativeResult(ctx, response.CreativeID, opts)
+	if err != nil {
+		return nil, credits, err
+	}
+	result.Preprocessing = response.Preprocessing
+
+	// Report the request that started the job, with the seed of the result and the time spent waiting for it
+	metadata := *response.Metadata
+	metadata.Seed = result.Metadata.Seed
+	metadata.FinishReason = result.Metadata.FinishReason
+	metadata.Duration = time.Since(start)
+	result.Metadata = &metadata
+
+	if err := request.checkContentFiltered(result); err != nil {
+		return nil, credits, err
+	}
+	return result, credits, nil
+}
+
+// wait calls poll with exponential backoff until it reports the job as finished, fails, or the deadline passes.
+// Rate limit errors are not fatal: the next poll is delayed by the Retry-After the API asked for.
+func wait(ctx context.Context, id string, opts WaitOptions, poll func(context.Context) (bool, time.Duration, error)) error {
+	opts = opts.withDefaults()
+
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	interval := opts.InitialInterval
+	delay := interval
+
+	for attempt := 1; ; attempt++ {
+		// Wait before polling, since jobs never finish immediately
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("stopped waiting for result %s: %w", id, ctx.Err())
+		case <-timer.C:
+		}
+
+		finished, retryAfter, err := poll(ctx)
+		if err != nil {
+			apiErr, ok := apierrors.AsAPIError(err)
+			if !ok || !apierrors.IsRateLimitError(err) {
+				return err
+			}
+			retryAfter = apiErr.RetryAfter
+		}
+		if finished {
+			return nil
+		}
+
+		// Grow the interval, but never poll sooner than the API asked us to
+		if attempt > 1 {
+			interval = time.Duration(float64(interval) * opts.BackoffFactor)
+			if interval > opts.MaxInterval {
+				interval = opts.MaxInterval
+			}
+		}
+		delay = interval
+		if retryAfter > delay {
+			delay = retryAfter
+		}
+
+		if opts.OnProgress != nil {
+			opts.OnProgress(WaitProgress{
+				ID:       id,
+				Attempt:  attempt,
+				Elapsed:  time.Since(start),
+				NextPoll: delay,
+			})
+		}
+	}
+}
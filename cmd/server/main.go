@@ -8,6 +8,7 @@ import (
 
 	"github.com/marcusziade/stability-go"
 	"github.com/marcusziade/stability-go/api"
+	"github.com/marcusziade/stability-go/client"
 	"github.com/marcusziade/stability-go/config"
 	"github.com/marcusziade/stability-go/internal/logger"
 )
@@ -30,14 +31,46 @@ func main() {
 	log := logger.NewFromString(cfg.LogLevel)
 	log.Info("Starting Stability AI Upscale API Server")
 
+	// Create a circuit breaker so requests fail fast while Stability AI is down
+	breaker := client.NewCircuitBreaker(client.CircuitBreakerConfig{
+		FailureRate: cfg.CircuitBreakerFailureRate,
+		Cooldown:    cfg.CircuitBreakerCooldown,
+		OnStateChange: func(from, to client.CircuitState) {
+			log.Warn("Stability AI circuit breaker changed from %s to %s", from, to)
+		},
+	})
+
+	// Build the middleware chain; the proxy replaces the transport, so it must come last
+	middleware := []client.Middleware{client.CircuitBreak(breaker)}
+	if cfg.StabilityProxy != "" {
+		middleware = append(middleware, client.ProxyWithConfig(client.ProxyConfig{
+			URL:     cfg.StabilityProxy,
+			NoProxy: cfg.NoProxy,
+		}))
+		log.Info("Sending Stability AI requests through proxy")
+	} else if cfg.StabilityRelayURL != "" {
+		middleware = append(middleware, client.Relay(cfg.StabilityRelayURL))
+		log.Info("Sending Stability AI requests through relay %s", cfg.StabilityRelayURL)
+	}
+
 	// Create Stability AI client
-	client := stability.New(cfg.APIKey)
+	stClient := stability.New(cfg.APIKey).WithMiddleware(middleware...)
 	if cfg.StabilityBaseURL != "" {
-		client = client.WithBaseURL(cfg.StabilityBaseURL)
+		stClient = stClient.WithBaseURL(cfg.StabilityBaseURL)
+	}
+	if len(cfg.APIKeys) > 0 {
+		strategy, err := client.ParseKeyStrategy(cfg.KeyStrategy)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
+			os.Exit(1)
+		}
+		stClient = stClient.WithKeys(cfg.APIKeys, client.KeyPoolConfig{Strategy: strategy})
+		log.Info("Using %d Stability AI API keys (%s)", len(cfg.APIKeys), strategy)
 	}
 
 	// Create API server
-	server := api.New(client, log, cfg.CachePath, cfg.RateLimit, cfg.APIKey, cfg.ClientAPIKey, cfg.AllowedHosts, cfg.AllowedIPs, cfg.AllowedAppIDs)
+	server := api.New(stClient, log, cfg.CachePath, cfg.RateLimit, cfg.APIKey, cfg.ClientAPIKey, cfg.AllowedHosts, cfg.AllowedIPs, cfg.AllowedAppIDs).
+		WithCircuitBreaker(breaker)
 
 	// Handle graceful shutdown
 	go handleSignals(log)
@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -11,6 +12,10 @@ import (
 type Config struct {
 	// API key for Stability AI
 	APIKey string
+	// Pool of API keys for Stability AI, used instead of APIKey to spread requests over several accounts
+	APIKeys []string
+	// How requests are spread over APIKeys (round-robin, least-rate-limited or failover)
+	KeyStrategy string
 	// API key for client authentication (separate from Stability AI key)
 	ClientAPIKey string
 	// Server address (e.g., ":8080")
@@ -25,17 +30,44 @@ type Config struct {
 	LogLevel string
 	// Custom base URL for Stability API (optional)
 	StabilityBaseURL string
+	// HTTP or SOCKS5 proxy for requests to Stability API (optional)
+	StabilityProxy string
+	// Hosts that are reached without the proxy, in NO_PROXY format
+	NoProxy string
+	// Relay server for requests to Stability API, as an alternative to a proxy (optional)
+	StabilityRelayURL string
 	// List of allowed IP addresses (empty to allow all)
 	AllowedIPs []string
 	// List of allowed app IDs (empty to allow all)
 	AllowedAppIDs []string
+	// Failure rate (0-1) at which the circuit breaker stops forwarding requests to Stability AI
+	CircuitBreakerFailureRate float64
+	// How long the circuit breaker stays open before probing Stability AI again
+	CircuitBreakerCooldown time.Duration
 }
 
 // LoadFromEnv loads configuration from environment variables
 func LoadFromEnv() (*Config, error) {
+	// Parse the Stability AI API keys (a single key and/or a comma-separated pool)
 	apiKey := os.Getenv("STABILITY_API_KEY")
+	var apiKeys []string
+	if keys := os.Getenv("STABILITY_API_KEYS"); keys != "" {
+		for _, key := range strings.Split(keys, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				apiKeys = append(apiKeys, key)
+			}
+		}
+	}
+	if apiKey == "" && len(apiKeys) > 0 {
+		apiKey = apiKeys[0]
+	}
 	if apiKey == "" {
-		return nil, fmt.Errorf("STABILITY_API_KEY environment variable is required")
+		return nil, fmt.Errorf("STABILITY_API_KEY or STABILITY_API_KEYS environment variable is required")
+	}
+
+	keyStrategy := os.Getenv("STABILITY_KEY_STRATEGY")
+	if keyStrategy == "" {
+		keyStrategy = "round-robin"
 	}
 
 	// Get client API key for authentication
@@ -96,8 +128,40 @@ func LoadFromEnv() (*Config, error) {
 	// Get custom base URL
 	stabilityBaseURL := os.Getenv("STABILITY_BASE_URL")
 
+	// Get proxy settings
+	stabilityProxy := os.Getenv("STABILITY_PROXY")
+	noProxy := os.Getenv("NO_PROXY")
+	if noProxy == "" {
+		noProxy = os.Getenv("no_proxy")
+	}
+	stabilityRelayURL := os.Getenv("STABILITY_RELAY_URL")
+	if stabilityProxy != "" && stabilityRelayURL != "" {
+		return nil, fmt.Errorf("STABILITY_PROXY and STABILITY_RELAY_URL cannot both be set")
+	}
+
+	// Parse circuit breaker settings
+	circuitBreakerFailureRate := 0.5
+	if rateStr := os.Getenv("CIRCUIT_BREAKER_FAILURE_RATE"); rateStr != "" {
+		var err error
+		circuitBreakerFailureRate, err = strconv.ParseFloat(rateStr, 64)
+		if err != nil || circuitBreakerFailureRate <= 0 || circuitBreakerFailureRate > 1 {
+			return nil, fmt.Errorf("invalid CIRCUIT_BREAKER_FAILURE_RATE value: must be between 0 and 1")
+		}
+	}
+
+	circuitBreakerCooldown := 30 * time.Second
+	if cooldownStr := os.Getenv("CIRCUIT_BREAKER_COOLDOWN"); cooldownStr != "" {
+		var err error
+		circuitBreakerCooldown, err = time.ParseDuration(cooldownStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIRCUIT_BREAKER_COOLDOWN value: %w", err)
+		}
+	}
+
 	return &Config{
 		APIKey:           apiKey,
+		APIKeys:          apiKeys,
+		KeyStrategy:      keyStrategy,
 		ClientAPIKey:     clientAPIKey,
 		ServerAddr:       serverAddr,
 		CachePath:        cachePath,
@@ -105,8 +169,14 @@ func LoadFromEnv() (*Config, error) {
 		AllowedHosts:     allowedHosts,
 		LogLevel:         logLevel,
 		StabilityBaseURL: stabilityBaseURL,
+		StabilityProxy:   stabilityProxy,
+		NoProxy:          noProxy,
 		AllowedIPs:       allowedIPs,
 		AllowedAppIDs:    allowedAppIDs,
+
+		StabilityRelayURL:         stabilityRelayURL,
+		CircuitBreakerFailureRate: circuitBreakerFailureRate,
+		CircuitBreakerCooldown:    circuitBreakerCooldown,
 	}, nil
 }
 
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadFromEnvProxyAndRelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		proxy   string
+		relay   string
+		wantErr bool
+	}{
+		{"neither", "", "", false},
+		{"proxy", "http://proxy.internal:3128", "", false},
+		{"relay", "", "https://relay.internal", false},
+		{"both", "http://proxy.internal:3128", "https://relay.internal", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("STABILITY_API_KEY", "sk-test")
+			t.Setenv("CLIENT_API_KEY", "client-key")
+			t.Setenv("STABILITY_PROXY", tt.proxy)
+			t.Setenv("STABILITY_RELAY_URL", tt.relay)
+
+			cfg, err := LoadFromEnv()
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "STABILITY_PROXY and STABILITY_RELAY_URL") {
+					t.Fatalf("err = %v, want the proxy and relay rejected together", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadFromEnv failed: %v", err)
+			}
+			if cfg.StabilityProxy != tt.proxy || cfg.StabilityRelayURL != tt.relay {
+				t.Errorf("proxy = %q, relay = %q, want %q and %q", cfg.StabilityProxy, cfg.StabilityRelayURL, tt.proxy, tt.relay)
+			}
+		})
+	}
+}
@@ -0,0 +1,292 @@
+// Package errors defines the errors returned by the Stability AI client
+package errors
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/marcusziade/stability-go/internal/utils"
+)
+
+// ErrorDetail describes a single problem reported in an API error response
+type ErrorDetail struct {
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message"`
+}
+
+// UnmarshalJSON accepts both plain string entries and code/message objects
+func (d *ErrorDetail) UnmarshalJSON(data []byte) error {
+	var message string
+	if err := json.Unmarshal(data, &message); err == nil {
+		d.Message = message
+		return nil
+	}
+
+	type detail ErrorDetail
+	return json.Unmarshal(data, (*detail)(d))
+}
+
+// APIError represents an error returned by the Stability API
+type APIError struct {
+	StatusCode int               `json:"-"`
+	RequestID  string            `json:"-"`
+	Operation  string            `json:"-"`
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	Message    string            `json:"message"`
+	Errors     []ErrorDetail     `json:"errors,omitempty"`
+	Details    map[string]string `json:"details,omitempty"`
+	// How long the API asked us to wait before retrying (from the Retry-After header)
+	RetryAfter time.Duration `json:"-"`
+	// How many attempts the retry middleware made before giving up (zero without the retry middleware)
+	Attempts int `json:"-"`
+}
+
+func (e *APIError) Error() string {
+	prefix := "stability API error"
+	if e.Operation != "" {
+		prefix = e.Operation + " API error"
+	}
+	if e.isContentPolicyViolation() {
+		prefix = "content policy violation: " + prefix
+	}
+
+	message := e.Message
+	if message == "" {
+		message = strings.Join(e.Codes(), ", ")
+	}
+
+	if e.Name == "" {
+		return fmt.Sprintf("%s (status %d): %s", prefix, e.StatusCode, message)
+	}
+	return fmt.Sprintf("%s (status %d): %s - %s", prefix, e.StatusCode, e.Name, message)
+}
+
+// Codes returns the codes of the individual errors, falling back to their messages when no code is set
+func (e *APIError) Codes() []string {
+	codes := make([]string, 0, len(e.Errors))
+	for _, detail := range e.Errors {
+		if detail.Code != "" {
+			codes = append(codes, detail.Code)
+		} else {
+			codes = append(codes, detail.Message)
+		}
+	}
+	return codes
+}
+
+// isContentPolicyViolation reports whether the error was raised by the content moderation system
+func (e *APIError) isContentPolicyViolation() bool {
+	return e.StatusCode == http.StatusForbidden ||
+		e.Name == "content_policy_violation" ||
+		e.Name == "content_moderation" ||
+		e.Name == "safety_violation" ||
+		e.Message == "Your request has been rejected as a result of our safety system."
+}
+
+// RetryError is returned by the retry middleware when the last attempt of a request failed without a response
+type RetryError struct {
+	// The number of attempts made
+	Attempts int
+	// The error of the last attempt
+	Err error
+}
+
+func (e *RetryError) Error() string {
+	if e.Attempts == 1 {
+		return fmt.Sprintf("%v (after 1 attempt)", e.Err)
+	}
+	return fmt.Sprintf("%v (after %d attempts)", e.Err, e.Attempts)
+}
+
+// Unwrap returns the error of the last attempt
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// CircuitOpenError is returned by the circuit breaker middleware while the upstream is considered unhealthy
+type CircuitOpenError struct {
+	// How long until the breaker lets requests through again (zero if unknown)
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("circuit breaker is open: the Stability API is unavailable, retry in %s", e.RetryAfter.Truncate(time.Second)+time.Second)
+	}
+	return "circuit breaker is open: the Stability API is unavailable"
+}
+
+// ErrContentFiltered is matched by errors.Is for every ContentFilteredError
+var ErrContentFiltered = errors.New("result blurred by the content filter")
+
+// ContentFilteredError is returned when the API answers with the CONTENT_FILTERED finish reason:
+// the request succeeded, but the content moderation system blurred the result
+type ContentFilteredError struct {
+	Operation string
+	// The request ID reported in the response headers
+	RequestID string
+	// The seed used to produce the filtered result
+	Seed int64
+}
+
+func (e *ContentFilteredError) Error() string {
+	return fmt.Sprintf("content policy violation: %s result was blurred by the content filter (finish reason CONTENT_FILTERED)", e.Operation)
+}
+
+// Is reports whether target is ErrContentFiltered
+func (e *ContentFilteredError) Is(target error) bool {
+	return target == ErrContentFiltered
+}
+
+// FieldError describes a request field that failed client-side validation
+type FieldError struct {
+	// The API name of the field (e.g., "prompt" or "image")
+	Field string `json:"field"`
+	// What is wrong with the field
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// ValidationError is returned when a request breaks the documented API limits, before it is sent
+type ValidationError struct {
+	Operation string
+	Errors    []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, fieldErr := range e.Errors {
+		messages = append(messages, fieldErr.Error())
+	}
+	return fmt.Sprintf("invalid %s request: %s", e.Operation, strings.Join(messages, "; "))
+}
+
+// ParseAPIError attempts to parse an API error from an HTTP response
+func ParseAPIError(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	return ReadAPIError(resp, "")
+}
+
+// ReadAPIError reads the body of a failed response into an APIError for the given operation.
+// Bodies that aren't valid JSON are kept as the error message.
+func ReadAPIError(resp *http.Response, operation string) *APIError {
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		RequestID:  ParseRequestID(resp.Header),
+		Operation:  operation,
+		RetryAfter: utils.ParseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		apiErr.Message = fmt.Sprintf("failed to read error response: %v", err)
+		return apiErr
+	}
+
+	if err := json.Unmarshal(body, apiErr); err != nil {
+		// If we can't parse the JSON, keep the response body as the message
+		apiErr.Message = strings.TrimSpace(string(body))
+	}
+
+	if apiErr.Message == "" && apiErr.StatusCode == http.StatusForbidden && len(apiErr.Errors) == 0 {
+		apiErr.Message = "the image appears to violate Stability AI's content policy"
+	}
+
+	return apiErr
+}
+
+// ParseRequestID returns the request ID reported in the response headers, or an empty string if there is none
+func ParseRequestID(header http.Header) string {
+	for _, key := range []string{"X-Request-Id", "Request-Id", "X-Amzn-Requestid"} {
+		if id := header.Get(key); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// AsAPIError returns the APIError wrapped in err, if any
+func AsAPIError(err error) (*APIError, bool) {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr, true
+	}
+	return nil, false
+}
+
+// IsRateLimitError checks if the error is a rate limit error
+func IsRateLimitError(err error) bool {
+	if apiErr, ok := AsAPIError(err); ok {
+		return apiErr.StatusCode == http.StatusTooManyRequests
+	}
+	return false
+}
+
+// IsAuthError checks if the error is an authentication error
+func IsAuthError(err error) bool {
+	if apiErr, ok := AsAPIError(err); ok {
+		return apiErr.StatusCode == http.StatusUnauthorized
+	}
+	return false
+}
+
+// IsCreditError checks if the error is due to insufficient credits
+func IsCreditError(err error) bool {
+	if apiErr, ok := AsAPIError(err); ok {
+		return apiErr.StatusCode == http.StatusPaymentRequired ||
+			apiErr.Name == "insufficient_credits" ||
+			apiErr.Name == "payment_required"
+	}
+	return false
+}
+
+// IsContentPolicyViolation checks if the error is due to content policy violation,
+// including results blurred by the content filter
+func IsContentPolicyViolation(err error) bool {
+	if apiErr, ok := AsAPIError(err); ok {
+		return apiErr.isContentPolicyViolation()
+	}
+	return IsContentFiltered(err)
+}
+
+// IsContentFiltered checks if the request succeeded with a result blurred by the content filter
+func IsContentFiltered(err error) bool {
+	return errors.Is(err, ErrContentFiltered)
+}
+
+// Attempts returns how many attempts the retry middleware made for the request that failed with err,
+// or zero if the request didn't go through the retry middleware
+func Attempts(err error) int {
+	var retryErr *RetryError
+	if errors.As(err, &retryErr) {
+		return retryErr.Attempts
+	}
+	if apiErr, ok := AsAPIError(err); ok {
+		return apiErr.Attempts
+	}
+	return 0
+}
+
+// IsCircuitOpen checks if the request was rejected by an open circuit breaker
+func IsCircuitOpen(err error) bool {
+	var circuitErr *CircuitOpenError
+	return errors.As(err, &circuitErr)
+}
+
+// IsValidationError checks if the request was rejected by client-side validation
+func IsValidationError(err error) bool {
+	var validationErr *ValidationError
+	return errors.As(err, &validationErr)
+}
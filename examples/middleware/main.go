@@ -15,14 +15,14 @@ import (
 
 // Custom logging middleware implementation
 type LoggingMiddleware struct {
-	transport http.RoundTripper
+	next http.RoundTripper
 }
 
-func NewLoggingMiddleware(transport http.RoundTripper) *LoggingMiddleware {
-	if transport == nil {
-		transport = http.DefaultTransport
+// Logging returns a client.Middleware that logs every request and response
+func Logging() client.Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return &LoggingMiddleware{next: next}
 	}
-	return &LoggingMiddleware{transport: transport}
 }
 
 func (m *LoggingMiddleware) RoundTrip(req *http.Request) (*http.Response, error) {
@@ -30,7 +30,7 @@ func (m *LoggingMiddleware) RoundTrip(req *http.Request) (*http.Response, error)
 	
 	fmt.Printf("[%s] Request: %s %s\n", time.Now().Format(time.RFC3339), req.Method, req.URL.String())
 	
-	resp, err := m.transport.RoundTrip(req)
+	resp, err := m.next.RoundTrip(req)
 	
 	duration := time.Since(start)
 	
@@ -74,13 +74,13 @@ func main() {
 	}
 
 	// Create custom middleware chain
-	// The middleware is applied in reverse order (last first)
-	// 1. Logging (will be executed first)
-	// 2. Retry (will be executed second)
-	// 3. Rate limit (will be executed third)
+	// The first middleware is the outermost and sees each request first
+	// 1. Logging (logs each request once, including its retries)
+	// 2. Retry (retries failed requests)
+	// 3. Rate limit (spaces out every attempt, including retries)
 	stClient := stability.NewWithMiddleware(
 		apiKey,
-		NewLoggingMiddleware(nil),                            // Custom logging middleware
+		Logging(),                                             // Custom logging middleware
 		stability.WithRetry(3, 1*time.Second, 10*time.Second), // Retry middleware
 		stability.WithRateLimit(500*time.Millisecond),         // Rate limit middleware
 	)
@@ -11,6 +11,7 @@ import (
 
 	"github.com/marcusziade/stability-go"
 	"github.com/marcusziade/stability-go/client"
+	stabilityerrors "github.com/marcusziade/stability-go/errors"
 )
 
 func main() {
@@ -25,8 +26,8 @@ func main() {
 	creativity := flag.Float64("creativity", 0.0, "Creativity level (0.1-0.5 for creative, 0.2-0.5 for conservative)")
 	stylePreset := flag.String("style", "", "Style preset for creative upscale (3d-model, anime, etc.)")
 	outputFormat := flag.String("format", "png", "Output format (jpeg, png, webp)")
-	useProxy := flag.Bool("proxy", false, "Use proxy")
-	proxyURL := flag.String("proxy-url", "your-proxy-server.com", "Proxy URL")
+	useProxy := flag.Bool("proxy", false, "Route requests through a relay server")
+	proxyURL := flag.String("proxy-url", "your-proxy-server.com", "Relay server URL")
 	flag.Parse()
 
 	// Validate inputs
@@ -144,7 +145,7 @@ func main() {
 		stClient = stability.NewWithMiddleware(*apiKey,
 			stability.WithRateLimit(500*time.Millisecond),
 			stability.WithRetry(3, 1*time.Second, 10*time.Second),
-			stability.WithProxy(*proxyURL),
+			stability.WithRelay(*proxyURL),
 		).GetClient()
 	} else {
 		stClient = stability.New(*apiKey)
@@ -170,9 +171,7 @@ func main() {
 	response, err := stClient.Upscale(ctx, request)
 	if err != nil {
 		// Check if it's a content policy violation
-		if err.Error() != "" && (strings.Contains(err.Error(), "content policy violation") || 
-		   strings.Contains(err.Error(), "forbidden") ||
-		   strings.Contains(err.Error(), "no data received")) {
+		if stabilityerrors.IsContentPolicyViolation(err) || strings.Contains(err.Error(), "no data received") {
 			fmt.Printf("Content policy error: %v\n", err)
 			fmt.Println("This image likely violates Stability AI's content policies.")
 			fmt.Println("Please try a different image or check the image content against Stability AI's guidelines.")
@@ -192,44 +191,38 @@ func main() {
 	if upscaleTypeEnum == client.UpscaleTypeCreative {
 		fmt.Println("Creative upscale initiated. Polling for results...")
 		
-		// Poll for the result (every 2 seconds)
-		for {
-			time.Sleep(2 * time.Second)
-			
-			result, finished, err := stClient.PollCreativeResult(ctx, response.CreativeID)
-			if err != nil {
-				// Check if it's a content policy violation
-				if err.Error() != "" && (strings.Contains(err.Error(), "content policy violation") || 
-				   strings.Contains(err.Error(), "forbidden")) {
-					fmt.Printf("\nContent policy error during processing: %v\n", err)
-					fmt.Println("This may indicate that the image violates Stability AI's content policies.")
-					fmt.Println("Please try a different image or check the image content against Stability AI's guidelines.")
-				} else {
-					fmt.Printf("\nError polling for results: %v\n", err)
-				}
-				os.Exit(1)
+		// Wait for the result, printing a dot for every pending poll
+		result, err := stClient.WaitForCreativeResult(ctx, response.CreativeID, client.WaitOptions{
+			OnProgress: func(client.WaitProgress) { fmt.Print(".") },
+		})
+		if err != nil {
+			// Check if it's a content policy violation
+			if stabilityerrors.IsContentPolicyViolation(err) {
+				fmt.Printf("\nContent policy error during processing: %v\n", err)
+				fmt.Println("This may indicate that the image violates Stability AI's content policies.")
+				fmt.Println("Please try a different image or check the image content against Stability AI's guidelines.")
+			} else {
+				fmt.Printf("\nError polling for results: %v\n", err)
 			}
-			
-			if finished {
-				response = result
-				break
-			}
-			
-			fmt.Print(".")
+			os.Exit(1)
 		}
+		response = result
 		fmt.Println("\nCreative upscale completed!")
 	}
 
 	duration := time.Since(startTime)
 	fmt.Printf("Upscale completed in %.2f seconds\n", duration.Seconds())
 
-	// Create output filename
-	ext := "." + string(outputFormatEnum)
+	// Create output filename from the format the API actually returned
+	ext := client.ExtensionForMimeType(response.MimeType)
+	if ext == "" {
+		ext = "." + string(outputFormatEnum)
+	}
 	baseName := filename[:len(filename)-len(filepath.Ext(filename))]
 	outputPath := filepath.Join(*outputDir, fmt.Sprintf("%s_upscaled%s", baseName, ext))
 
 	// Save the image
-	if err := os.WriteFile(outputPath, response.ImageData, 0644); err != nil {
+	if err := client.SaveAsset(response.ImageData, outputPath); err != nil {
 		fmt.Printf("Failed to save image: %v\n", err)
 		os.Exit(1)
 	}
@@ -4,4 +4,4 @@ go 1.24.1
 
 require golang.org/x/image v0.25.0
 
-require github.com/joho/godotenv v1.5.1 // indirect
+require github.com/joho/godotenv v1.5.1
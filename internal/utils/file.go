@@ -12,7 +12,7 @@ import (
 	"path/filepath"
 	"strings"
 
-	"golang.org/x/image/webp"
+	_ "golang.org/x/image/webp" // Register the webp decoder
 )
 
 // ReadImageFile reads an image file and returns its contents as a byte slice
@@ -31,6 +31,25 @@ func ReadImageFile(filePath string) ([]byte, error) {
 	return data, nil
 }
 
+// ImageDimensions decodes the header of a jpeg, png or webp image and returns its size and format
+func ImageDimensions(data []byte) (width, height int, format string, err error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("failed to decode image: %w", err)
+	}
+	return config.Width, config.Height, format, nil
+}
+
+// ReadImageDimensions decodes the header of a jpeg, png or webp image from a reader and returns its size and format.
+// Only the header is read, not the whole image.
+func ReadImageDimensions(r io.Reader) (width, height int, format string, err error) {
+	config, format, err := image.DecodeConfig(r)
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("failed to decode image: %w", err)
+	}
+	return config.Width, config.Height, format, nil
+}
+
 // ExtractFilename extracts the filename from a file path
 func ExtractFilename(filePath string) string {
 	return filepath.Base(filePath)
@@ -0,0 +1,364 @@
+// Package stabilitytest provides utilities for testing code that talks to the Stability AI API without a network
+// connection or API key: a fake Stability API server, a transport that records and replays real interactions,
+// and an in-memory tracer and meter.
+package stabilitytest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marcusziade/stability-go/client"
+)
+
+// DefaultImage is a 1x1 transparent PNG returned by the fake server unless another image is configured
+var DefaultImage = func() []byte {
+	var b bytes.Buffer
+	png.Encode(&b, image.NewNRGBA(image.Rect(0, 0, 1, 1)))
+	return b.Bytes()
+}()
+
+// ServerConfig configures the fake server. Zero values are replaced by the defaults.
+type ServerConfig struct {
+	// API key the server accepts; requests with another key get a 401 (defaults to accepting any key)
+	APIKey string
+	// Image returned by the upscale endpoints and finished jobs (defaults to DefaultImage)
+	Image []byte
+	// Number of polls that report a job as pending before it finishes
+	PendingPolls int
+	// Seed reported with every image
+	Seed int64
+	// Finish reason reported with every image, e.g. client.FinishReasonContentFiltered (defaults to SUCCESS)
+	FinishReason string
+}
+
+// Fault is an error response the fake server returns instead of handling a request
+type Fault struct {
+	// Path the fault applies to (empty for any path)
+	Path string
+	// Status code of the error response
+	StatusCode int
+	// Error name and message in the response body
+	Name    string
+	Message string
+	// Retry-After header sent with the response (rounded up to whole seconds)
+	RetryAfter time.Duration
+	// Number of requests the fault applies to (defaults to 1)
+	Times int
+}
+
+// ContentPolicyFault returns a 403 content moderation error for the given path
+func ContentPolicyFault(path string) Fault {
+	return Fault{
+		Path:       path,
+		StatusCode: http.StatusForbidden,
+		Name:       "content_moderation",
+		Message:    "Your request was flagged by our content moderation system, as a result your request was denied and you were not charged.",
+	}
+}
+
+// RateLimitFault returns a 429 rate limit error for the given path
+func RateLimitFault(path string, retryAfter time.Duration) Fault {
+	return Fault{
+		Path:       path,
+		StatusCode: http.StatusTooManyRequests,
+		Name:       "rate_limit_exceeded",
+		Message:    "You have exceeded the rate limit of 150 requests within a 10 second period.",
+		RetryAfter: retryAfter,
+	}
+}
+
+// ServerErrorFault returns a 500 internal error for the given path
+func ServerErrorFault(path string) Fault {
+	return Fault{
+		Path:       path,
+		StatusCode: http.StatusInternalServerError,
+		Name:       "internal_error",
+		Message:    "An unexpected server error has occurred, please try again later.",
+	}
+}
+
+// Request is a request received by the fake server
+type Request struct {
+	Method string
+	Path   string
+	Header http.Header
+	// Form fields of multipart requests
+	Fields map[string]string
+	// Names of the file fields of multipart requests
+	Files []string
+}
+
+// job is an asynchronous job started on the fake server
+type job struct {
+	polls int
+}
+
+// Server is a fake Stability API serving the upscale endpoints and result polling.
+// Responses carry an X-Request-Id header numbering the requests received (req-1, req-2, ...).
+// It embeds an *httptest.Server, so URL and Close work as usual.
+type Server struct {
+	*httptest.Server
+
+	config ServerConfig
+
+	mutex    sync.Mutex
+	jobs     map[string]*job
+	jobCount int
+	faults   []*Fault
+	requests []Request
+}
+
+// NewServer starts a fake Stability API server. The caller must call Close when finished.
+func NewServer(config ServerConfig) *Server {
+	if config.Image == nil {
+		config.Image = DefaultImage
+	}
+	if config.FinishReason == "" {
+		config.FinishReason = client.FinishReasonSuccess
+	}
+
+	s := &Server{
+		config: config,
+		jobs:   make(map[string]*job),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// NewClient returns a Stability client that talks to the fake server
+func (s *Server) NewClient() *client.Client {
+	apiKey := s.config.APIKey
+	if apiKey == "" {
+		apiKey = "sk-test"
+	}
+	return client.NewClient(apiKey).WithBaseURL(s.URL)
+}
+
+// InjectFault makes the server return an error response for the next matching requests
+func (s *Server) InjectFault(fault Fault) {
+	if fault.Times <= 0 {
+		fault.Times = 1
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.faults = append(s.faults, &fault)
+}
+
+// Requests returns the requests received so far
+func (s *Server) Requests() []Request {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// handle serves every request to the fake server
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	request := Request{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Header: r.Header.Clone(),
+		Fields: map[string]string{},
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			writeError(w, http.StatusBadRequest, "bad_request", "invalid multipart body: "+err.Error(), 0)
+			return
+		}
+		for key, values := range r.MultipartForm.Value {
+			request.Fields[key] = values[0]
+		}
+		for key := range r.MultipartForm.File {
+			request.Files = append(request.Files, key)
+		}
+	}
+
+	sort.Strings(request.Files)
+
+	s.mutex.Lock()
+	s.requests = append(s.requests, request)
+	requestNumber := len(s.requests)
+	s.mutex.Unlock()
+
+	// Like the real API, every response carries a request ID
+	w.Header().Set("X-Request-Id", fmt.Sprintf("req-%d", requestNumber))
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") ||
+		(s.config.APIKey != "" && r.Header.Get("Authorization") != "Bearer "+s.config.APIKey) {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Missing or invalid API key", 0)
+		return
+	}
+
+	s.mutex.Lock()
+	fault := s.takeFault(r.URL.Path)
+	s.mutex.Unlock()
+	if fault != nil {
+		writeError(w, fault.StatusCode, fault.Name, fault.Message, fault.RetryAfter)
+		return
+	}
+
+	switch {
+	case r.URL.Path == client.UpscaleFastPath:
+		s.handleUpscale(w, r, request, false)
+	case r.URL.Path == client.UpscaleConservativePath:
+		s.handleUpscale(w, r, request, true)
+	case r.URL.Path == client.UpscaleCreativePath:
+		s.handleCreative(w, r, request)
+	case strings.HasPrefix(r.URL.Path, client.ResultPath+"/"),
+		strings.HasPrefix(r.URL.Path, client.CreativeResultPath+"/"):
+		s.handleResult(w, r)
+	default:
+		writeError(w, http.StatusNotFound, "not_found", "The requested resource was not found", 0)
+	}
+}
+
+// takeFault returns the first injected fault matching the path, if any. Must be called with the mutex held.
+func (s *Server) takeFault(path string) *Fault {
+	for i, fault := range s.faults {
+		if fault.Path != "" && fault.Path != path {
+			continue
+		}
+
+		fault.Times--
+		if fault.Times == 0 {
+			s.faults = append(s.faults[:i], s.faults[i+1:]...)
+		}
+		return fault
+	}
+	return nil
+}
+
+// handleUpscale serves the synchronous fast and conservative upscale endpoints
+func (s *Server) handleUpscale(w http.ResponseWriter, r *http.Request, request Request, requirePrompt bool) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed", 0)
+		return
+	}
+	if !validateUpscale(w, request, requirePrompt) {
+		return
+	}
+
+	s.writeImage(w, r)
+}
+
+// handleCreative serves the asynchronous creative upscale endpoint
+func (s *Server) handleCreative(w http.ResponseWriter, r *http.Request, request Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed", 0)
+		return
+	}
+	if !validateUpscale(w, request, true) {
+		return
+	}
+
+	s.mutex.Lock()
+	s.jobCount++
+	sum := sha256.Sum256([]byte(strconv.Itoa(s.jobCount)))
+	id := hex.EncodeToString(sum[:])
+	s.jobs[id] = &job{}
+	s.mutex.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]string{"id": id})
+}
+
+// handleResult serves result polling, reporting jobs as pending for the configured number of polls
+func (s *Server) handleResult(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+
+	s.mutex.Lock()
+	j, ok := s.jobs[id]
+	pending := false
+	if ok {
+		j.polls++
+		pending = j.polls <= s.config.PendingPolls
+	}
+	s.mutex.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", fmt.Sprintf("Generation %s was not found", id), 0)
+		return
+	}
+	if pending {
+		writeJSON(w, http.StatusAccepted, map[string]string{"id": id, "status": "in-progress"})
+		return
+	}
+
+	s.writeImage(w, r)
+}
+
+// writeImage sends the configured image as binary or base64 JSON, depending on the Accept header
+func (s *Server) writeImage(w http.ResponseWriter, r *http.Request) {
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"image":         base64.StdEncoding.EncodeToString(s.config.Image),
+			"finish_reason": s.config.FinishReason,
+			"seed":          s.config.Seed,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", http.DetectContentType(s.config.Image))
+	w.Header().Set("Finish-Reason", s.config.FinishReason)
+	w.Header().Set("Seed", strconv.FormatInt(s.config.Seed, 10))
+	w.WriteHeader(http.StatusOK)
+	w.Write(s.config.Image)
+}
+
+// validateUpscale checks the fields of an upscale request, sending a 400 if they are invalid
+func validateUpscale(w http.ResponseWriter, request Request, requirePrompt bool) bool {
+	hasImage := false
+	for _, field := range request.Files {
+		hasImage = hasImage || field == "image"
+	}
+
+	var problems []string
+	if !hasImage {
+		problems = append(problems, "image: required")
+	}
+	if requirePrompt && request.Fields["prompt"] == "" {
+		problems = append(problems, "prompt: required")
+	}
+	if len(problems) > 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"id":     "bad-request",
+			"name":   "bad_request",
+			"errors": problems,
+		})
+		return false
+	}
+	return true
+}
+
+// writeError sends an error response in the format used by the Stability API
+func writeError(w http.ResponseWriter, statusCode int, name, message string, retryAfter time.Duration) {
+	if retryAfter > 0 {
+		seconds := int((retryAfter + time.Second - 1) / time.Second)
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	}
+	writeJSON(w, statusCode, map[string]interface{}{
+		"id":      strconv.Itoa(statusCode),
+		"name":    name,
+		"message": message,
+		"errors":  []string{message},
+	})
+}
+
+// writeJSON sends a JSON response
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(v)
+}
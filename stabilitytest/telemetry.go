@@ -0,0 +1,191 @@
+package stabilitytest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/marcusziade/stability-go/telemetry"
+)
+
+// Telemetry is an in-memory telemetry.Tracer and telemetry.Meter that records every span and measurement,
+// so tests can assert on what the client and the API server report. It is safe for concurrent use.
+type Telemetry struct {
+	mutex        sync.Mutex
+	spans        []*SpanRecord
+	measurements []Measurement
+}
+
+// SpanRecord is a span recorded by Telemetry
+type SpanRecord struct {
+	// Position of the span among the recorded spans, starting at 1
+	ID int
+	// ID of the span that was active in the context the span was started from (0 for root spans)
+	ParentID   int
+	Name       string
+	Attributes map[string]interface{}
+	// Errors recorded on the span
+	Errors []error
+	Start  time.Time
+	End    time.Time
+	// Whether End was called
+	Ended bool
+}
+
+// Measurement is a counter increment or histogram value recorded by Telemetry
+type Measurement struct {
+	Name string
+	// "counter" for Add, "histogram" for Record
+	Kind       string
+	Value      float64
+	Attributes map[string]interface{}
+}
+
+// NewTelemetry returns an empty in-memory recorder
+func NewTelemetry() *Telemetry {
+	return &Telemetry{}
+}
+
+// telemetrySpanKey is the context key of the span started by a Telemetry
+type telemetrySpanKey struct{}
+
+// Start records a new span, a child of the span carried by ctx if any
+func (t *Telemetry) Start(ctx context.Context, name string, attrs ...telemetry.Attribute) (context.Context, telemetry.Span) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	record := &SpanRecord{
+		ID:         len(t.spans) + 1,
+		Name:       name,
+		Attributes: attributeMap(attrs),
+		Start:      time.Now(),
+	}
+	if parent, ok := ctx.Value(telemetrySpanKey{}).(*SpanRecord); ok {
+		record.ParentID = parent.ID
+	}
+	t.spans = append(t.spans, record)
+
+	return context.WithValue(ctx, telemetrySpanKey{}, record), &recordedSpan{telemetry: t, record: record}
+}
+
+// Add records a counter increment
+func (t *Telemetry) Add(ctx context.Context, name string, value float64, attrs ...telemetry.Attribute) {
+	t.record(Measurement{Name: name, Kind: "counter", Value: value, Attributes: attributeMap(attrs)})
+}
+
+// Record records a histogram value
+func (t *Telemetry) Record(ctx context.Context, name string, value float64, attrs ...telemetry.Attribute) {
+	t.record(Measurement{Name: name, Kind: "histogram", Value: value, Attributes: attributeMap(attrs)})
+}
+
+// record appends a measurement
+func (t *Telemetry) record(measurement Measurement) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.measurements = append(t.measurements, measurement)
+}
+
+// Spans returns a copy of the recorded spans in the order they were started
+func (t *Telemetry) Spans() []SpanRecord {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	spans := make([]SpanRecord, len(t.spans))
+	for i, record := range t.spans {
+		spans[i] = *record
+		spans[i].Attributes = copyAttributes(record.Attributes)
+		spans[i].Errors = append([]error(nil), record.Errors...)
+	}
+	return spans
+}
+
+// SpansNamed returns a copy of the recorded spans with the given name
+func (t *Telemetry) SpansNamed(name string) []SpanRecord {
+	var spans []SpanRecord
+	for _, span := range t.Spans() {
+		if span.Name == name {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+// Measurements returns a copy of the recorded measurements in the order they were recorded
+func (t *Telemetry) Measurements() []Measurement {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	measurements := make([]Measurement, len(t.measurements))
+	for i, measurement := range t.measurements {
+		measurements[i] = measurement
+		measurements[i].Attributes = copyAttributes(measurement.Attributes)
+	}
+	return measurements
+}
+
+// Sum returns the sum of the values recorded for a metric
+func (t *Telemetry) Sum(name string) float64 {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var sum float64
+	for _, measurement := range t.measurements {
+		if measurement.Name == name {
+			sum += measurement.Value
+		}
+	}
+	return sum
+}
+
+// Reset discards everything recorded so far
+func (t *Telemetry) Reset() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.spans = nil
+	t.measurements = nil
+}
+
+// recordedSpan is the telemetry.Span returned by Telemetry.Start
+type recordedSpan struct {
+	telemetry *Telemetry
+	record    *SpanRecord
+}
+
+func (s *recordedSpan) SetAttributes(attrs ...telemetry.Attribute) {
+	s.telemetry.mutex.Lock()
+	defer s.telemetry.mutex.Unlock()
+	for _, attr := range attrs {
+		s.record.Attributes[attr.Key] = attr.Value
+	}
+}
+
+func (s *recordedSpan) RecordError(err error) {
+	s.telemetry.mutex.Lock()
+	defer s.telemetry.mutex.Unlock()
+	s.record.Errors = append(s.record.Errors, err)
+}
+
+func (s *recordedSpan) End() {
+	s.telemetry.mutex.Lock()
+	defer s.telemetry.mutex.Unlock()
+	s.record.End = time.Now()
+	s.record.Ended = true
+}
+
+// attributeMap converts attributes to a map, later attributes replacing earlier ones with the same key
+func attributeMap(attrs []telemetry.Attribute) map[string]interface{} {
+	m := make(map[string]interface{}, len(attrs))
+	for _, attr := range attrs {
+		m[attr.Key] = attr.Value
+	}
+	return m
+}
+
+// copyAttributes returns a copy of an attribute map
+func copyAttributes(attrs map[string]interface{}) map[string]interface{} {
+	m := make(map[string]interface{}, len(attrs))
+	for key, value := range attrs {
+		m[key] = value
+	}
+	return m
+}
@@ -0,0 +1,176 @@
+// Package telemetry defines the small tracing and metrics interfaces the client and the API server report to.
+// It has no dependencies, so adapters for OpenTelemetry, Prometheus or any other backend live in the application;
+// without one, everything is reported to the no-op implementations.
+package telemetry
+
+import (
+	"context"
+	"time"
+)
+
+// Attribute is a key-value pair describing a span or a measurement
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// String returns a string attribute
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Int returns an integer attribute
+func Int(key string, value int) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Int64 returns a 64-bit integer attribute
+func Int64(key string, value int64) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Float64 returns a floating point attribute
+func Float64(key string, value float64) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Bool returns a boolean attribute
+func Bool(key string, value bool) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Attribute keys set on spans and measurements
+const (
+	AttrOperation     = "stability.operation"
+	AttrUpscaleType   = "stability.upscale.type"
+	AttrImageBytes    = "stability.image.bytes"
+	AttrAttempts      = "stability.attempts"
+	AttrRequestID     = "stability.request_id"
+	AttrFinishReason  = "stability.finish_reason"
+	AttrJobID         = "stability.job.id"
+	AttrJobFinished   = "stability.job.finished"
+	AttrCircuitState  = "stability.circuit_breaker.state"
+	AttrRateLimitWait = "stability.rate_limit.wait_ms"
+	AttrProxy         = "stability.proxy"
+	AttrHandler       = "http.handler"
+	AttrMethod        = "http.method"
+	AttrStatusCode    = "http.status_code"
+)
+
+// Metric names. Durations are recorded in seconds.
+const (
+	// Counter of requests sent to the Stability API, by operation and status code
+	MetricClientRequests = "stability.client.requests"
+	// Histogram of how long requests to the Stability API took, including retries, by operation
+	MetricClientDuration = "stability.client.request.duration"
+	// Counter of requests the retry middleware resent
+	MetricClientRetries = "stability.client.retries"
+	// Histogram of how long requests waited for the rate limiter
+	MetricRateLimitWait = "stability.client.rate_limit.wait"
+	// Counter of requests rejected by an open circuit breaker
+	MetricCircuitRejected = "stability.client.circuit_breaker.rejected"
+	// Counter of credits spent on upscales according to client.UpscaleCredits, by upscale type
+	MetricUpscaleCredits = "stability.client.upscale.credits"
+	// Counter of requests served by the API server, by handler and status code
+	MetricServerRequests = "stability.server.requests"
+	// Histogram of how long the API server took to serve requests, by handler
+	MetricServerDuration = "stability.server.request.duration"
+)
+
+// Tracer starts spans
+type Tracer interface {
+	// Start starts a span, returning a context carrying it so spans started from that context are its children
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+// Span is a timed operation started by a Tracer
+type Span interface {
+	// SetAttributes adds attributes to the span, replacing attributes with the same key
+	SetAttributes(attrs ...Attribute)
+	// RecordError marks the span as failed with err
+	RecordError(err error)
+	// End finishes the span; it must be called exactly once
+	End()
+}
+
+// Meter records measurements
+type Meter interface {
+	// Add adds value to a counter
+	Add(ctx context.Context, name string, value float64, attrs ...Attribute)
+	// Record records a value of a histogram, such as a duration
+	Record(ctx context.Context, name string, value float64, attrs ...Attribute)
+}
+
+// NoopTracer is a Tracer whose spans do nothing
+type NoopTracer struct{}
+
+// Start returns ctx unchanged and a span that does nothing
+func (NoopTracer) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+// noopSpan is the span started by NoopTracer
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(attrs ...Attribute) {}
+func (noopSpan) RecordError(err error)            {}
+func (noopSpan) End()                             {}
+
+// NoopMeter is a Meter that discards every measurement
+type NoopMeter struct{}
+
+// Add discards the value
+func (NoopMeter) Add(ctx context.Context, name string, value float64, attrs ...Attribute) {}
+
+// Record discards the value
+func (NoopMeter) Record(ctx context.Context, name string, value float64, attrs ...Attribute) {}
+
+// contextKey is the type of the context keys used by this package
+type contextKey int
+
+const (
+	contextKeyTracer contextKey = iota
+	contextKeyMeter
+)
+
+// ContextWithTracer returns a context carrying the tracer, so code that only receives the context, such as
+// HTTP middleware, reports to it
+func ContextWithTracer(ctx context.Context, tracer Tracer) context.Context {
+	return context.WithValue(ctx, contextKeyTracer, tracer)
+}
+
+// TracerFromContext returns the tracer carried by ctx, or a NoopTracer if there is none
+func TracerFromContext(ctx context.Context) Tracer {
+	if tracer, ok := ctx.Value(contextKeyTracer).(Tracer); ok {
+		return tracer
+	}
+	return NoopTracer{}
+}
+
+// ContextWithMeter returns a context carrying the meter
+func ContextWithMeter(ctx context.Context, meter Meter) context.Context {
+	return context.WithValue(ctx, contextKeyMeter, meter)
+}
+
+// MeterFromContext returns the meter carried by ctx, or a NoopMeter if there is none
+func MeterFromContext(ctx context.Context) Meter {
+	if meter, ok := ctx.Value(contextKeyMeter).(Meter); ok {
+		return meter
+	}
+	return NoopMeter{}
+}
+
+// StartSpan starts a span with the tracer carried by ctx
+func StartSpan(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	return TracerFromContext(ctx).Start(ctx, name, attrs...)
+}
+
+// Add adds value to a counter of the meter carried by ctx
+func Add(ctx context.Context, name string, value float64, attrs ...Attribute) {
+	MeterFromContext(ctx).Add(ctx, name, value, attrs...)
+}
+
+// RecordDuration records the time elapsed since start, in seconds, in a histogram of the meter carried by ctx
+func RecordDuration(ctx context.Context, name string, start time.Time, attrs ...Attribute) {
+	MeterFromContext(ctx).Record(ctx, name, time.Since(start).Seconds(), attrs...)
+}